@@ -11,3 +11,15 @@
 {{- define "linux-node-selector" -}}
 kubernetes.io/os: linux
 {{- end -}}
+
+{{/*
+Name of the operator resources, suffixed by the instance ID so multiple
+operator instances can be installed in a cluster.
+*/}}
+{{- define "cilium-egress-operator.fullname" -}}
+{{- if .Values.operator.instanceID -}}
+{{- printf "cilium-egress-operator-%s" .Values.operator.instanceID -}}
+{{- else -}}
+{{- "cilium-egress-operator" -}}
+{{- end -}}
+{{- end -}}
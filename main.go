@@ -5,17 +5,31 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"net/http"
 	_ "net/http/pprof"
 
+	"github.com/cnrancher/cilium-egress-operator/pkg/config"
 	"github.com/cnrancher/cilium-egress-operator/pkg/controller/cegp"
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/group"
 	"github.com/cnrancher/cilium-egress-operator/pkg/controller/lease"
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/node"
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/standby"
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/state"
 	"github.com/cnrancher/cilium-egress-operator/pkg/controller/wrangler"
+	"github.com/cnrancher/cilium-egress-operator/pkg/elector"
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
 	"github.com/cnrancher/cilium-egress-operator/pkg/signal"
 	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/cnrancher/cilium-egress-operator/pkg/webhook"
 	"github.com/rancher/wrangler/v3/pkg/kubeconfig"
 	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 var (
@@ -28,6 +42,32 @@ var (
 	setNodeLabelSelector bool
 	profileServer        bool
 	profileServerAddr    string
+	metricsServerAddr    string
+	bootstrapTimeout     time.Duration
+	minDwell             time.Duration
+	stabilizationDelay   time.Duration
+	failbackMode         string
+	failbackDelay        time.Duration
+	preferredNodes       string
+	gatewayCount         int
+	gatewayNodeSelector  string
+	drainPeriod          time.Duration
+	resyncInterval       time.Duration
+	resyncJitter         float64
+	driftMode            string
+	webhookServerAddr    string
+	webhookCertDir       string
+	gatewayGroups        bool
+	isovalentPolicies    bool
+	instanceID           string
+	namespace            string
+	leaseDuration        time.Duration
+	renewDeadline        time.Duration
+	retryPeriod          time.Duration
+	standbyInterval      time.Duration
+	kubeVIPLease         string
+	configFile           string
+	logFormat            string
 	debug                bool
 )
 
@@ -52,6 +92,59 @@ func main() {
 	flag.BoolVar(&setNodeLabelSelector, "set-node-label-selector", true, "Set CiliumEgressGatewayPolicy NodeSelector to desired Node.")
 	flag.BoolVar(&profileServer, "profile-server", false, "Enable the Go pprof profiling HTTP server.")
 	flag.StringVar(&profileServerAddr, "profile-server-addr", "127.0.0.1:6060", "Profiling server listen address.")
+	flag.StringVar(&metricsServerAddr, "metrics-server-addr", ":8080", "Prometheus metrics server listen address, set to empty to disable.")
+	flag.DurationVar(&bootstrapTimeout, "bootstrap-timeout", time.Second*30,
+		"Timeout to determine the gateway leader node before starting policy handlers.")
+	flag.DurationVar(&minDwell, "gateway-min-dwell", 0,
+		"Minimum time a gateway node must hold before policies move to another node again.")
+	flag.DurationVar(&stabilizationDelay, "gateway-stabilization-delay", 0,
+		"Time a new KubeVIP lease holder must keep the lease before policies follow it.")
+	flag.StringVar(&failbackMode, "failback-mode", elector.FailbackModeNever,
+		"Preferred node failback mode (never, immediate, delayed).")
+	flag.DurationVar(&failbackDelay, "failback-delay", time.Minute*5,
+		"Time the preferred node must keep ready before failback in delayed mode.")
+	flag.StringVar(&preferredNodes, "preferred-nodes", "",
+		"Comma separated preferred gateway node names, ordered by priority.")
+	flag.IntVar(&gatewayCount, "gateway-count", 1,
+		"Number of gateway nodes selected by the policies, the leader node and the top healthy candidate nodes "+
+			"are selected by a hostname In matchExpression if greater than 1.")
+	flag.StringVar(&gatewayNodeSelector, "gateway-node-selector", "node-role.kubernetes.io/control-plane",
+		"Label selector of the candidate gateway nodes used when the gateway node is in maintenance.")
+	flag.DurationVar(&drainPeriod, "drain-period", 0,
+		"Time to wait before moving policies on planned gateway moves (maintenance, failback), 0 to move immediately.")
+	flag.DurationVar(&resyncInterval, "resync-interval", cegp.DefaultResyncInterval,
+		"Interval to re-enqueue the monitored policies, 0 to disable the periodic re-enqueue.")
+	flag.Float64Var(&resyncJitter, "resync-jitter", cegp.DefaultResyncJitter,
+		"Max jitter factor added to the resync interval (0-1).")
+	flag.StringVar(&driftMode, "drift-mode", utils.DriftModeLenient,
+		"How to handle policy manual edits: lenient (revert at next resync), strict (revert immediately).")
+	flag.StringVar(&webhookServerAddr, "webhook-server-addr", "",
+		"Admission webhook server listen address, set to empty to disable.")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", "/etc/cilium-egress-operator/certs",
+		"Directory containing the webhook server tls.crt and tls.key files.")
+	flag.BoolVar(&gatewayGroups, "gateway-groups", false,
+		"Enable the EgressGatewayGroup controller, requires the EgressGatewayGroup CRD installed.")
+	flag.BoolVar(&isovalentPolicies, "isovalent-policies", false,
+		"Manage IsovalentEgressGatewayPolicy of Isovalent Enterprise, requires the Isovalent CRDs installed.")
+	flag.StringVar(&instanceID, "instance-id", "",
+		"ID of the operator instance, only the policies and groups annotated with the same "+
+			"egress.cilium.pandaria.io/instance are managed.")
+	flag.StringVar(&namespace, "namespace", os.Getenv("POD_NAMESPACE"),
+		"Namespace of the leader election lock and the operator state, defaults to the POD_NAMESPACE env or kube-system.")
+	flag.DurationVar(&leaseDuration, "leader-election-lease-duration", 0,
+		"Duration the non-leader operator pods wait before trying to acquire the leadership, 0 to use the default 45s.")
+	flag.DurationVar(&renewDeadline, "leader-election-renew-deadline", 0,
+		"Duration the leader operator pod retries refreshing the leadership before giving up, 0 to use the default 30s.")
+	flag.DurationVar(&retryPeriod, "leader-election-retry-period", 0,
+		"Duration the operator pods wait between the leader election actions, 0 to use the default 2s.")
+	flag.DurationVar(&standbyInterval, "standby-interval", standby.DefaultInterval,
+		"Interval the standby operator pods evaluate the gateway state read-only to take over quickly, 0 to disable.")
+	flag.StringVar(&kubeVIPLease, "kube-vip-lease", lease.DefaultLeaseName,
+		"Name of the KubeVIP lease in kube-system followed by the default gateway group.")
+	flag.StringVar(&configFile, "config", "",
+		"Path to the YAML or JSON config file overriding the flags, reloaded on change.")
+	flag.StringVar(&logFormat, "log-format", utils.LogFormatText,
+		"Log format: text (colored, warnings and errors to stderr), json (JSON lines to stdout).")
 	flag.BoolVar(&debug, "debug", false, "Enable the debug output.")
 	flag.Parse()
 
@@ -59,6 +152,10 @@ func main() {
 		fmt.Printf("cilium-egress-operator %v\n", versionString)
 		return
 	}
+	if err := utils.SetLogFormat(logFormat); err != nil {
+		logrus.Warnf("Invalid log format: %q, set to default: %v", logFormat, utils.LogFormatText)
+		logFormat = utils.LogFormatText
+	}
 	if debug || os.Getenv("CATTLE_DEV_MODE") != "" {
 		logrus.SetLevel(logrus.DebugLevel)
 		logrus.Debugf("Debug output enabled")
@@ -67,6 +164,95 @@ func main() {
 		logrus.Warnf("Invalid worker: %v, should be 1-50, set to default: 10", worker)
 		worker = 10
 	}
+	if resyncInterval < 0 {
+		logrus.Warnf("Invalid resync interval: %v, set to default: %v", resyncInterval, cegp.DefaultResyncInterval)
+		resyncInterval = cegp.DefaultResyncInterval
+	}
+	if resyncJitter < 0 || resyncJitter > 1 {
+		logrus.Warnf("Invalid resync jitter: %v, should be 0-1, set to default: %v", resyncJitter, cegp.DefaultResyncJitter)
+		resyncJitter = cegp.DefaultResyncJitter
+	}
+	if !utils.ValidDriftMode(driftMode) {
+		logrus.Warnf("Invalid drift mode: %q, set to default: %v", driftMode, utils.DriftModeLenient)
+		driftMode = utils.DriftModeLenient
+	}
+	if gatewayCount < 1 {
+		logrus.Warnf("Invalid gateway count: %v, set to default: 1", gatewayCount)
+		gatewayCount = 1
+	}
+	if !elector.ValidFailbackMode(failbackMode) {
+		logrus.Warnf("Invalid failback mode: %q, set to default: %v", failbackMode, elector.FailbackModeNever)
+		failbackMode = elector.FailbackModeNever
+	}
+	if _, err := labels.Parse(gatewayNodeSelector); err != nil {
+		logrus.Fatalf("Invalid gateway node selector %q: %v", gatewayNodeSelector, err)
+	}
+	if kubeVIPLease == "" {
+		kubeVIPLease = lease.DefaultLeaseName
+	}
+	if instanceID != "" {
+		if errs := validation.IsDNS1123Label(instanceID); len(errs) > 0 {
+			logrus.Fatalf("Invalid instance ID %q: %v", instanceID, strings.Join(errs, ", "))
+		}
+		logrus.Infof("Operator instance [%v] manages the objects annotated with %v=%v",
+			instanceID, utils.InstanceAnnotation, instanceID)
+	}
+	utils.SetInstanceID(instanceID)
+	if namespace == "" {
+		namespace = wrangler.DefaultNamespace
+	}
+	if err := wrangler.ValidateElection(wrangler.Options{
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+	}); err != nil {
+		logrus.Warnf("Invalid leader election timings: %v, set to default", err)
+		leaseDuration, renewDeadline, retryPeriod = 0, 0, 0
+		if err := wrangler.ValidateElection(wrangler.Options{}); err != nil {
+			logrus.Fatalf("Invalid leader election env: %v", err)
+		}
+	}
+	if standbyInterval < 0 {
+		logrus.Warnf("Invalid standby interval: %v, set to default: %v", standbyInterval, standby.DefaultInterval)
+		standbyInterval = standby.DefaultInterval
+	}
+	flagConfig := config.Config{
+		Log: config.Log{
+			Debug:  logrus.GetLevel() >= logrus.DebugLevel,
+			Format: logFormat,
+		},
+		Gateway: config.Gateway{
+			Lease:              kubeVIPLease,
+			Count:              gatewayCount,
+			NodeSelector:       gatewayNodeSelector,
+			MinDwell:           metav1.Duration{Duration: minDwell},
+			StabilizationDelay: metav1.Duration{Duration: stabilizationDelay},
+			Failback: config.Failback{
+				Mode:           failbackMode,
+				Delay:          metav1.Duration{Duration: failbackDelay},
+				PreferredNodes: utils.SplitList(preferredNodes),
+			},
+			Groups:          gatewayGroups,
+			StandbyInterval: metav1.Duration{Duration: standbyInterval},
+		},
+		Policy: config.Policy{
+			SetNodeIP:            setNodeIP,
+			SetNodeLabelSelector: setNodeLabelSelector,
+			DrainPeriod:          metav1.Duration{Duration: drainPeriod},
+			ResyncInterval:       metav1.Duration{Duration: resyncInterval},
+			ResyncJitter:         resyncJitter,
+			DriftMode:            driftMode,
+		},
+	}
+	cfg := flagConfig
+	if configFile != "" {
+		var err error
+		if cfg, err = config.Load(configFile, flagConfig); err != nil {
+			logrus.Fatalf("Failed to load config file: %v", err)
+		}
+		setLogging(cfg.Log)
+		logrus.Infof("Loaded config file [%v]", configFile)
+	}
 	if profileServer {
 		go func() {
 			logrus.Infof("Go pprof server listen on: http://%v", profileServerAddr)
@@ -76,13 +262,30 @@ func main() {
 		}()
 	}
 
+	if metricsServerAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			mux.Handle("/debug/gateway", standby.Handler())
+			logrus.Infof("Metrics server listen on: http://%v/metrics", metricsServerAddr)
+			if err := http.ListenAndServe(metricsServerAddr, mux); err != nil {
+				logrus.Errorf("Failed to start metrics server: %v", err)
+			}
+		}()
+	}
+
 	// This will load the kubeconfig file in a style the same as kubectl
-	cfg, err := kubeconfig.GetNonInteractiveClientConfig(kubeconfigFile).ClientConfig()
+	restCfg, err := kubeconfig.GetNonInteractiveClientConfig(kubeconfigFile).ClientConfig()
 	if err != nil {
 		logrus.Fatalf("Error building kubeconfig: %v", err)
 	}
 
-	wctx, err := wrangler.NewContext(cfg)
+	wctx, err := wrangler.NewContext(restCfg, wrangler.Options{
+		Namespace:     namespace,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+	})
 	if err != nil {
 		logrus.Fatalf("Failed to build wrangler context: %v", err)
 	}
@@ -90,21 +293,167 @@ func main() {
 		logrus.Fatalf("Failed to wait for cache synced: %v", err)
 	}
 
-	lease.Register(ctx, wctx)
-	cegp.Register(ctx, wctx, cegp.Options{
-		SetPolicyEgressIPToNodeIP: setNodeIP,
-		SetPolicyNodeSelector:     setNodeLabelSelector,
-	})
+	if webhookServerAddr != "" {
+		// Only the leader pod receives the admission requests.
+		if err := webhook.SetLeader(ctx, wctx, false); err != nil {
+			logrus.Warnf("Failed to remove the webhook leader label: %v", err)
+		}
+		go func() {
+			opts := webhookOptions(cfg)
+			opts.Addr = webhookServerAddr
+			opts.CertDir = webhookCertDir
+			if err := webhook.Run(ctx, wctx, opts); err != nil {
+				logrus.Fatalf("Webhook server failed: %v", err)
+			}
+		}()
+	}
+
+	policy.Register(policy.NewCiliumKind(wctx))
+	if isovalentPolicies {
+		policy.Register(policy.NewIsovalentKind(wctx))
+	}
+	lease.Register(ctx, wctx, leaseOptions(cfg))
+	node.Register(ctx, wctx)
+	if cfg.Gateway.Groups {
+		group.Register(ctx, wctx, groupOptions(cfg))
+	}
+	cegp.Register(ctx, wctx, policyOptions(cfg))
+	standbyCtx, stopStandby := context.WithCancel(ctx)
+	if cfg.Gateway.StandbyInterval.Duration > 0 {
+		go func() {
+			if err := standby.Run(standbyCtx, wctx, standby.Options{
+				GatewayGroups: cfg.Gateway.Groups,
+				Interval:      cfg.Gateway.StandbyInterval.Duration,
+			}); err != nil {
+				logrus.Warnf("Failed to run standby gateway evaluation: %v", err)
+			}
+		}()
+	}
+	if configFile != "" {
+		startup := cfg
+		go func() {
+			if err := config.Watch(ctx, configFile, flagConfig, cfg, func(cfg config.Config) {
+				reload(wctx, startup, cfg)
+			}); err != nil {
+				logrus.Errorf("Failed to watch config file: %v", err)
+			}
+		}()
+	}
 	wctx.OnLeader(func(ctx context.Context) error {
 		logrus.Infof("Pod [%v] is leader, starting handlers", utils.Hostname())
+		standby.SetLeader(true)
+		stopStandby()
+
+		// Reload the gateway state persisted by the previous leader pod, the
+		// newer state evaluated while standby is kept.
+		if err := state.NewStore(wctx).Load(); err != nil {
+			logrus.Warnf("Failed to reload gateway state: %v", err)
+		}
+		// Determine the gateway leader node before reconciling policies.
+		lease.Bootstrap(ctx, wctx, bootstrapTimeout)
+		// Report the policies still selecting the deleted nodes.
+		if err := node.CheckPolicies(ctx, wctx); err != nil {
+			logrus.Warnf("Failed to check policy gateway nodes: %v", err)
+		}
 
 		// Start controller when this pod becomes leader.
 		if err := wctx.StartHandler(ctx, worker); err != nil {
 			return err
 		}
+		// Route the admission requests to this pod after the gateway state
+		// is determined.
+		if webhookServerAddr != "" {
+			if err := webhook.SetLeader(ctx, wctx, true); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	wctx.Run(ctx)
 
 	select {}
 }
+
+// setLogging applies the log format and switches the log level between debug
+// and info.
+func setLogging(log config.Log) {
+	if err := utils.SetLogFormat(log.Format); err != nil {
+		logrus.Warnf("Failed to set log format: %v", err)
+	}
+	if log.Debug {
+		logrus.SetLevel(logrus.DebugLevel)
+		return
+	}
+	logrus.SetLevel(logrus.InfoLevel)
+}
+
+// reload applies the reloaded config to the handlers, the startup config
+// decides which handlers are registered.
+func reload(wctx *wrangler.Context, startup, cfg config.Config) {
+	setLogging(cfg.Log)
+	lease.Reload(wctx, leaseOptions(cfg))
+	if startup.Gateway.Groups {
+		if err := group.Reload(wctx, groupOptions(cfg)); err != nil {
+			logrus.Warnf("Failed to reload EgressGatewayGroup handler options: %v", err)
+		}
+	}
+	if err := cegp.Reload(policyOptions(cfg)); err != nil {
+		logrus.Warnf("Failed to reload policy handler options: %v", err)
+	}
+	if webhookServerAddr != "" {
+		webhook.Reload(webhookOptions(cfg))
+	}
+}
+
+// candidateSelector returns the candidate gateway node selector of the
+// config, which is validated on load.
+func candidateSelector(cfg config.Config) labels.Selector {
+	selector, err := labels.Parse(cfg.Gateway.NodeSelector)
+	if err != nil {
+		return labels.Everything()
+	}
+	return selector
+}
+
+func leaseOptions(cfg config.Config) lease.Options {
+	return lease.Options{
+		LeaseName: cfg.Gateway.Lease,
+		Elector: elector.Options{
+			MinDwell:           cfg.Gateway.MinDwell.Duration,
+			StabilizationDelay: cfg.Gateway.StabilizationDelay.Duration,
+			Failback: elector.FailbackOptions{
+				Mode:           cfg.Gateway.Failback.Mode,
+				Delay:          cfg.Gateway.Failback.Delay.Duration,
+				PreferredNodes: cfg.Gateway.Failback.PreferredNodes,
+			},
+			CandidateSelector: candidateSelector(cfg),
+			GatewayCount:      cfg.Gateway.Count,
+		},
+	}
+}
+
+func groupOptions(cfg config.Config) group.Options {
+	return group.Options{
+		SetPolicyEgressIPToNodeIP: cfg.Policy.SetNodeIP,
+		SetPolicyNodeSelector:     cfg.Policy.SetNodeLabelSelector,
+	}
+}
+
+func policyOptions(cfg config.Config) cegp.Options {
+	return cegp.Options{
+		SetPolicyEgressIPToNodeIP: cfg.Policy.SetNodeIP,
+		SetPolicyNodeSelector:     cfg.Policy.SetNodeLabelSelector,
+		DrainPeriod:               cfg.Policy.DrainPeriod.Duration,
+		ResyncInterval:            cfg.Policy.ResyncInterval.Duration,
+		ResyncJitter:              cfg.Policy.ResyncJitter,
+		DriftMode:                 cfg.Policy.DriftMode,
+	}
+}
+
+func webhookOptions(cfg config.Config) webhook.Options {
+	return webhook.Options{
+		SetPolicyEgressIPToNodeIP: cfg.Policy.SetNodeIP,
+		SetPolicyNodeSelector:     cfg.Policy.SetNodeLabelSelector,
+		CandidateSelector:         candidateSelector(cfg),
+	}
+}
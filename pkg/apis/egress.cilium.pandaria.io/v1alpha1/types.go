@@ -0,0 +1,173 @@
+package v1alpha1
+
+import (
+	"github.com/rancher/wrangler/v3/pkg/condition"
+	"github.com/rancher/wrangler/v3/pkg/genericcondition"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	// GroupConditionReady is true if the gateway node is known, healthy and
+	// all bound policies are synced.
+	GroupConditionReady condition.Cond = "Ready"
+	// GroupConditionDegraded is true if the group works with reduced
+	// redundancy or some bound policies are not synced.
+	GroupConditionDegraded condition.Cond = "Degraded"
+	// GroupConditionNoLeader is true if no gateway node is selected.
+	GroupConditionNoLeader condition.Cond = "NoLeader"
+	// GroupConditionFlapping is true if the gateway node changes too often.
+	GroupConditionFlapping condition.Cond = "Flapping"
+)
+
+type SourceType string
+
+const (
+	// SourceTypeLease follows the holder of the lease (e.g. KubeVIP).
+	SourceTypeLease SourceType = "Lease"
+	// SourceTypeStatic uses the first healthy node of the static node list.
+	SourceTypeStatic SourceType = "Static"
+	// SourceTypeElection elects the gateway from the healthy candidate nodes
+	// by the operator.
+	SourceTypeElection SourceType = "Election"
+)
+
+type IPMode string
+
+const (
+	// IPModeNodeIP sets the policy egressIP to the gateway node IP.
+	IPModeNodeIP IPMode = "NodeIP"
+	// IPModeManual keeps the policy egressIP managed by the user.
+	IPModeManual IPMode = "Manual"
+)
+
+type FailbackMode string
+
+const (
+	FailbackModeNever     FailbackMode = "Never"
+	FailbackModeImmediate FailbackMode = "Immediate"
+	FailbackModeDelayed   FailbackMode = "Delayed"
+)
+
+type PolicySyncState string
+
+const (
+	// PolicySyncStateSynced means the policy points to the gateway node.
+	PolicySyncStateSynced PolicySyncState = "Synced"
+	// PolicySyncStatePending means the policy is waiting for the drain
+	// period before moving to the gateway node.
+	PolicySyncStatePending PolicySyncState = "Pending"
+	// PolicySyncStateOutOfSync means the policy does not point to the
+	// gateway node.
+	PolicySyncStateOutOfSync PolicySyncState = "OutOfSync"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// EgressGatewayGroup describes a group of egress gateway nodes, the monitored
+// policies annotated with the group name are managed by this group.
+type EgressGatewayGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EgressGatewayGroupSpec   `json:"spec"`
+	Status EgressGatewayGroupStatus `json:"status,omitempty"`
+}
+
+type EgressGatewayGroupSpec struct {
+	// Source describes how the gateway node is selected.
+	Source GatewaySource `json:"source"`
+	// CandidateNodeSelector selects the nodes can be the gateway node.
+	CandidateNodeSelector *metav1.LabelSelector `json:"candidateNodeSelector,omitempty"`
+	// IPMode is the policy egressIP management mode (NodeIP, Manual), the
+	// operator option is used if empty.
+	IPMode IPMode `json:"ipMode,omitempty"`
+	// Damping is the failover damping configuration.
+	Damping *Damping `json:"damping,omitempty"`
+	// Failback is the preferred node failback configuration.
+	Failback *Failback `json:"failback,omitempty"`
+	// GatewayCount is the number of the gateway nodes selected by the group
+	// policies, the policies select the gateway node and the top healthy
+	// candidate nodes by a hostname In matchExpression if greater than 1.
+	GatewayCount int `json:"gatewayCount,omitempty"`
+}
+
+type GatewaySource struct {
+	// Type is the gateway source type (Lease, Static, Election).
+	Type SourceType `json:"type"`
+	// Lease is the lease followed by the Lease source type.
+	Lease *LeaseSource `json:"lease,omitempty"`
+	// Static is the node list used by the Static source type.
+	Static *StaticSource `json:"static,omitempty"`
+}
+
+type LeaseSource struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type StaticSource struct {
+	// Nodes is the gateway node names ordered by priority.
+	Nodes []string `json:"nodes"`
+}
+
+type Damping struct {
+	// MinDwell is the minimum time a gateway node must hold before
+	// policies move to another node again.
+	MinDwell *metav1.Duration `json:"minDwell,omitempty"`
+	// StabilizationDelay is the time a new gateway node must keep stable
+	// before policies follow it.
+	StabilizationDelay *metav1.Duration `json:"stabilizationDelay,omitempty"`
+}
+
+type Failback struct {
+	// Mode is the failback mode (Never, Immediate, Delayed).
+	Mode FailbackMode `json:"mode,omitempty"`
+	// Delay is the time the preferred node must keep ready before
+	// failback in Delayed mode.
+	Delay *metav1.Duration `json:"delay,omitempty"`
+	// PreferredNodes is the preferred gateway node names ordered by priority.
+	PreferredNodes []string `json:"preferredNodes,omitempty"`
+}
+
+type EgressGatewayGroupStatus struct {
+	// ObservedGeneration is the latest spec generation observed by the
+	// operator.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions is the group conditions (Ready, Degraded, NoLeader,
+	// Flapping).
+	Conditions []genericcondition.GenericCondition `json:"conditions,omitempty"`
+	// CurrentGateway is the current gateway node hostname.
+	CurrentGateway string `json:"currentGateway,omitempty"`
+	// CurrentGatewayIP is the current gateway node IP.
+	CurrentGatewayIP string `json:"currentGatewayIP,omitempty"`
+	// GatewayNodes is the ordered gateway node hostnames selected by the
+	// policies if the gateway count is greater than 1, leader first.
+	GatewayNodes []string `json:"gatewayNodes,omitempty"`
+	// LastTransitionTime is the last time the gateway node changed.
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Members is the health of the candidate nodes.
+	Members []MemberStatus `json:"members,omitempty"`
+	// Policies is the sync state of the monitored policies bound to the
+	// group.
+	Policies []PolicyStatus `json:"policies,omitempty"`
+}
+
+type MemberStatus struct {
+	Node        string `json:"node"`
+	IP          string `json:"ip,omitempty"`
+	Ready       bool   `json:"ready"`
+	Maintenance bool   `json:"maintenance,omitempty"`
+}
+
+type PolicyStatus struct {
+	// Kind is the policy kind (CiliumEgressGatewayPolicy,
+	// IsovalentEgressGatewayPolicy).
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Gateway  string `json:"gateway,omitempty"`
+	EgressIP string `json:"egressIP,omitempty"`
+	// State is the policy sync state (Synced, Pending, OutOfSync).
+	State PolicySyncState `json:"state"`
+}
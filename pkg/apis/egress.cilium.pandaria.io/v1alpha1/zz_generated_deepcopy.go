@@ -0,0 +1,308 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2025 [SUSE Rancher](https://www.rancher.com/).
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	genericcondition "github.com/rancher/wrangler/v3/pkg/genericcondition"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Damping) DeepCopyInto(out *Damping) {
+	*out = *in
+	if in.MinDwell != nil {
+		in, out := &in.MinDwell, &out.MinDwell
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.StabilizationDelay != nil {
+		in, out := &in.StabilizationDelay, &out.StabilizationDelay
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Damping.
+func (in *Damping) DeepCopy() *Damping {
+	if in == nil {
+		return nil
+	}
+	out := new(Damping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressGatewayGroup) DeepCopyInto(out *EgressGatewayGroup) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressGatewayGroup.
+func (in *EgressGatewayGroup) DeepCopy() *EgressGatewayGroup {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressGatewayGroup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressGatewayGroup) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressGatewayGroupList) DeepCopyInto(out *EgressGatewayGroupList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressGatewayGroup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressGatewayGroupList.
+func (in *EgressGatewayGroupList) DeepCopy() *EgressGatewayGroupList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressGatewayGroupList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressGatewayGroupList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressGatewayGroupSpec) DeepCopyInto(out *EgressGatewayGroupSpec) {
+	*out = *in
+	in.Source.DeepCopyInto(&out.Source)
+	if in.CandidateNodeSelector != nil {
+		in, out := &in.CandidateNodeSelector, &out.CandidateNodeSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Damping != nil {
+		in, out := &in.Damping, &out.Damping
+		*out = new(Damping)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Failback != nil {
+		in, out := &in.Failback, &out.Failback
+		*out = new(Failback)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressGatewayGroupSpec.
+func (in *EgressGatewayGroupSpec) DeepCopy() *EgressGatewayGroupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressGatewayGroupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressGatewayGroupStatus) DeepCopyInto(out *EgressGatewayGroupStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]genericcondition.GenericCondition, len(*in))
+		copy(*out, *in)
+	}
+	if in.GatewayNodes != nil {
+		in, out := &in.GatewayNodes, &out.GatewayNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastTransitionTime != nil {
+		in, out := &in.LastTransitionTime, &out.LastTransitionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]MemberStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Policies != nil {
+		in, out := &in.Policies, &out.Policies
+		*out = make([]PolicyStatus, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressGatewayGroupStatus.
+func (in *EgressGatewayGroupStatus) DeepCopy() *EgressGatewayGroupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressGatewayGroupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Failback) DeepCopyInto(out *Failback) {
+	*out = *in
+	if in.Delay != nil {
+		in, out := &in.Delay, &out.Delay
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.PreferredNodes != nil {
+		in, out := &in.PreferredNodes, &out.PreferredNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Failback.
+func (in *Failback) DeepCopy() *Failback {
+	if in == nil {
+		return nil
+	}
+	out := new(Failback)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewaySource) DeepCopyInto(out *GatewaySource) {
+	*out = *in
+	if in.Lease != nil {
+		in, out := &in.Lease, &out.Lease
+		*out = new(LeaseSource)
+		**out = **in
+	}
+	if in.Static != nil {
+		in, out := &in.Static, &out.Static
+		*out = new(StaticSource)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewaySource.
+func (in *GatewaySource) DeepCopy() *GatewaySource {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewaySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LeaseSource) DeepCopyInto(out *LeaseSource) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LeaseSource.
+func (in *LeaseSource) DeepCopy() *LeaseSource {
+	if in == nil {
+		return nil
+	}
+	out := new(LeaseSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MemberStatus) DeepCopyInto(out *MemberStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemberStatus.
+func (in *MemberStatus) DeepCopy() *MemberStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MemberStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyStatus) DeepCopyInto(out *PolicyStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyStatus.
+func (in *PolicyStatus) DeepCopy() *PolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StaticSource) DeepCopyInto(out *StaticSource) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StaticSource.
+func (in *StaticSource) DeepCopy() *StaticSource {
+	if in == nil {
+		return nil
+	}
+	out := new(StaticSource)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2025 [SUSE Rancher](https://www.rancher.com/).
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+// +k8s:deepcopy-gen=package
+// +groupName=egress.cilium.pandaria.io
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// EgressGatewayGroupList is a list of EgressGatewayGroup resources
+type EgressGatewayGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []EgressGatewayGroup `json:"items"`
+}
+
+func NewEgressGatewayGroup(namespace, name string, obj EgressGatewayGroup) *EgressGatewayGroup {
+	obj.APIVersion, obj.Kind = SchemeGroupVersion.WithKind("EgressGatewayGroup").ToAPIVersionAndKind()
+	obj.Name = name
+	obj.Namespace = namespace
+	return &obj
+}
@@ -3,13 +3,14 @@ package main
 import (
 	"os"
 
+	"github.com/rancher/wrangler/v3/pkg/cleanup"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
-	// if err := cleanup.Cleanup("./pkg/apis"); err != nil {
-	// 	logrus.Fatal(err)
-	// }
+	if err := cleanup.Cleanup("./pkg/apis"); err != nil {
+		logrus.Fatal(err)
+	}
 	if err := os.RemoveAll("./pkg/generated"); err != nil {
 		logrus.Fatal(err)
 	}
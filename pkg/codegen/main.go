@@ -1,13 +1,18 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"reflect"
 
 	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	egressv1alpha1 "github.com/cnrancher/cilium-egress-operator/pkg/apis/egress.cilium.pandaria.io/v1alpha1"
 	controllergen "github.com/rancher/wrangler/v3/pkg/controller-gen"
 	"github.com/rancher/wrangler/v3/pkg/controller-gen/args"
+	"github.com/rancher/wrangler/v3/pkg/crd"
 	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 func main() {
@@ -22,6 +27,7 @@ func main() {
 					corev1.Pod{},
 					corev1.Node{},
 					corev1.Secret{},
+					corev1.ConfigMap{},
 				},
 			},
 			coordinationv1.GroupName: {
@@ -34,6 +40,43 @@ func main() {
 					ciliumv2.CiliumEgressGatewayPolicy{},
 				},
 			},
+			"egress.cilium.pandaria.io": {
+				Types: []any{
+					"./pkg/apis/egress.cilium.pandaria.io/v1alpha1",
+				},
+				GenerateTypes: true,
+			},
 		},
 	})
+
+	if err := crd.WriteFile("charts/cilium-egress-operator/crds/crds.yaml", []crd.CRD{
+		newCRD(&egressv1alpha1.EgressGatewayGroup{}, func(c crd.CRD) crd.CRD {
+			return c.
+				WithColumn("Source", ".spec.source.type").
+				WithColumn("Gateway", ".status.currentGateway").
+				WithColumn("GatewayIP", ".status.currentGatewayIP").
+				WithColumn("Ready", `.status.conditions[?(@.type=="Ready")].status`).
+				WithShortNames("egg")
+		}),
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write CRD: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newCRD(obj any, customize func(crd.CRD) crd.CRD) crd.CRD {
+	c := crd.CRD{
+		GVK: schema.GroupVersionKind{
+			Group:   "egress.cilium.pandaria.io",
+			Version: "v1alpha1",
+			Kind:    reflect.TypeOf(obj).Elem().Name(),
+		},
+		NonNamespace: true,
+		Status:       true,
+		SchemaObject: obj,
+	}
+	if customize != nil {
+		c = customize(c)
+	}
+	return c
 }
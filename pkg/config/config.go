@@ -0,0 +1,148 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/elector"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the operator configuration file, in YAML or JSON format.
+// The options not set in the file keep the values of the command line flags.
+type Config struct {
+	Log     Log     `json:"log"`
+	Gateway Gateway `json:"gateway"`
+	Policy  Policy  `json:"policy"`
+}
+
+type Log struct {
+	// Debug enables the debug output.
+	Debug bool `json:"debug"`
+	// Format is the log format, text or json.
+	Format string `json:"format"`
+}
+
+type Gateway struct {
+	// Lease is the name of the KubeVIP lease of the default gateway group.
+	Lease string `json:"lease"`
+	// Count is the number of gateway nodes selected by the policies.
+	Count int `json:"count"`
+	// NodeSelector is the label selector of the candidate gateway nodes.
+	NodeSelector       string          `json:"nodeSelector"`
+	MinDwell           metav1.Duration `json:"minDwell"`
+	StabilizationDelay metav1.Duration `json:"stabilizationDelay"`
+	Failback           Failback        `json:"failback"`
+	// Groups enables the EgressGatewayGroup controller, requires restart.
+	Groups bool `json:"groups"`
+	// StandbyInterval is the interval of the standby gateway evaluation,
+	// requires restart.
+	StandbyInterval metav1.Duration `json:"standbyInterval"`
+}
+
+type Failback struct {
+	Mode           string          `json:"mode"`
+	Delay          metav1.Duration `json:"delay"`
+	PreferredNodes []string        `json:"preferredNodes"`
+}
+
+type Policy struct {
+	// SetNodeIP sets the policy egressIP to the gateway node IP.
+	SetNodeIP bool `json:"setNodeIP"`
+	// SetNodeLabelSelector sets the policy nodeSelector to the gateway node.
+	SetNodeLabelSelector bool            `json:"setNodeLabelSelector"`
+	DrainPeriod          metav1.Duration `json:"drainPeriod"`
+	ResyncInterval       metav1.Duration `json:"resyncInterval"`
+	ResyncJitter         float64         `json:"resyncJitter"`
+	DriftMode            string          `json:"driftMode"`
+}
+
+// Load reads the configuration file over the base configuration and
+// validates it, the unknown fields are rejected.
+func Load(path string, base Config) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return base, fmt.Errorf("failed to read config file %q: %w", path, err)
+	}
+	// The decoder reuses the slice backing array, clone it to keep the base
+	// configuration unchanged.
+	cfg := base
+	cfg.Gateway.Failback.PreferredNodes = slices.Clone(base.Gateway.Failback.PreferredNodes)
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return base, fmt.Errorf("failed to decode config file %q: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return base, fmt.Errorf("invalid config file %q: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration values.
+func (c *Config) Validate() error {
+	var errs []error
+	if !utils.ValidLogFormat(c.Log.Format) {
+		errs = append(errs, fmt.Errorf("unknown log.format %q", c.Log.Format))
+	}
+	if c.Gateway.Lease == "" {
+		errs = append(errs, errors.New("gateway.lease must not be empty"))
+	}
+	if c.Gateway.Count < 1 {
+		errs = append(errs, fmt.Errorf("gateway.count %v must be at least 1", c.Gateway.Count))
+	}
+	if _, err := labels.Parse(c.Gateway.NodeSelector); err != nil {
+		errs = append(errs, fmt.Errorf("gateway.nodeSelector: %w", err))
+	}
+	if !elector.ValidFailbackMode(c.Gateway.Failback.Mode) {
+		errs = append(errs, fmt.Errorf("unknown gateway.failback.mode %q", c.Gateway.Failback.Mode))
+	}
+	if !utils.ValidDriftMode(c.Policy.DriftMode) {
+		errs = append(errs, fmt.Errorf("unknown policy.driftMode %q", c.Policy.DriftMode))
+	}
+	if c.Policy.ResyncJitter < 0 || c.Policy.ResyncJitter > 1 {
+		errs = append(errs, fmt.Errorf("policy.resyncJitter %v should be 0-1", c.Policy.ResyncJitter))
+	}
+	for _, d := range []struct {
+		name  string
+		value metav1.Duration
+	}{
+		{"gateway.minDwell", c.Gateway.MinDwell},
+		{"gateway.stabilizationDelay", c.Gateway.StabilizationDelay},
+		{"gateway.failback.delay", c.Gateway.Failback.Delay},
+		{"gateway.standbyInterval", c.Gateway.StandbyInterval},
+		{"policy.drainPeriod", c.Policy.DrainPeriod},
+		{"policy.resyncInterval", c.Policy.ResyncInterval},
+	} {
+		if d.value.Duration < 0 {
+			errs = append(errs, fmt.Errorf("%v %v must not be negative", d.name, d.value.Duration))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RestartRequired returns the changed options which are only applied on
+// startup.
+func (c *Config) RestartRequired(old Config) []string {
+	var changed []string
+	if c.Gateway.Groups != old.Gateway.Groups {
+		changed = append(changed, "gateway.groups")
+	}
+	if c.Gateway.StandbyInterval != old.Gateway.StandbyInterval {
+		changed = append(changed, "gateway.standbyInterval")
+	}
+	return changed
+}
+
+// keepRestartRequired returns the configuration with the options only
+// applied on startup set to the applied values, as they are not changed
+// until the operator restarts.
+func (c *Config) keepRestartRequired(applied Config) Config {
+	cfg := *c
+	cfg.Gateway.Groups = applied.Gateway.Groups
+	cfg.Gateway.StandbyInterval = applied.Gateway.StandbyInterval
+	return cfg
+}
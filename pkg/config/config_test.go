@@ -0,0 +1,235 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/elector"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validConfig returns the configuration of the default flag values.
+func validConfig() Config {
+	return Config{
+		Log: Log{Format: utils.LogFormatText},
+		Gateway: Gateway{
+			Lease:    "plndr-svcs-lock",
+			Count:    1,
+			Failback: Failback{Mode: elector.FailbackModeNever},
+		},
+		Policy: Policy{
+			SetNodeIP:            true,
+			SetNodeLabelSelector: true,
+			DriftMode:            utils.DriftModeLenient,
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{
+			name:   "valid",
+			modify: func(*Config) {},
+		},
+		{
+			name:    "unknown log format",
+			modify:  func(c *Config) { c.Log.Format = "xml" },
+			wantErr: true,
+		},
+		{
+			name:    "empty lease",
+			modify:  func(c *Config) { c.Gateway.Lease = "" },
+			wantErr: true,
+		},
+		{
+			name:    "zero gateway count",
+			modify:  func(c *Config) { c.Gateway.Count = 0 },
+			wantErr: true,
+		},
+		{
+			name:   "node selector",
+			modify: func(c *Config) { c.Gateway.NodeSelector = "egress-gateway=true" },
+		},
+		{
+			name:    "invalid node selector",
+			modify:  func(c *Config) { c.Gateway.NodeSelector = "egress-gateway in (" },
+			wantErr: true,
+		},
+		{
+			name:    "unknown failback mode",
+			modify:  func(c *Config) { c.Gateway.Failback.Mode = "always" },
+			wantErr: true,
+		},
+		{
+			name:    "unknown drift mode",
+			modify:  func(c *Config) { c.Policy.DriftMode = "ignore" },
+			wantErr: true,
+		},
+		{
+			name:   "resync jitter",
+			modify: func(c *Config) { c.Policy.ResyncJitter = 1 },
+		},
+		{
+			name:    "resync jitter out of range",
+			modify:  func(c *Config) { c.Policy.ResyncJitter = 1.5 },
+			wantErr: true,
+		},
+		{
+			name: "negative duration",
+			modify: func(c *Config) {
+				c.Policy.DrainPeriod = metav1.Duration{Duration: -time.Second}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(&c)
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{
+			name:   "unchanged",
+			modify: func(*Config) {},
+		},
+		{
+			name: "reloadable options",
+			modify: func(c *Config) {
+				c.Log.Debug = true
+				c.Gateway.Failback.Mode = elector.FailbackModeImmediate
+				c.Policy.DriftMode = utils.DriftModeStrict
+			},
+		},
+		{
+			name: "startup options",
+			modify: func(c *Config) {
+				c.Gateway.Groups = true
+				c.Gateway.StandbyInterval = metav1.Duration{Duration: time.Minute}
+			},
+			want: []string{"gateway.groups", "gateway.standbyInterval"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(&c)
+			if got := c.RestartRequired(validConfig()); !slices.Equal(got, tt.want) {
+				t.Errorf("RestartRequired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepRestartRequired(t *testing.T) {
+	applied := validConfig()
+	c := validConfig()
+	c.Gateway.Groups = true
+	c.Gateway.StandbyInterval = metav1.Duration{Duration: time.Minute}
+	c.Policy.DriftMode = utils.DriftModeStrict
+
+	got := c.keepRestartRequired(applied)
+	if changed := got.RestartRequired(applied); len(changed) > 0 {
+		t.Errorf("keepRestartRequired() changed %v, want the applied values", changed)
+	}
+	if got.Policy.DriftMode != utils.DriftModeStrict {
+		t.Errorf("keepRestartRequired() driftMode = %q, want %q", got.Policy.DriftMode, utils.DriftModeStrict)
+	}
+	// The warning is repeated by the next reload of the same file.
+	if changed := c.RestartRequired(got); len(changed) != 2 {
+		t.Errorf("RestartRequired() = %v, want the startup options changed", changed)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	base := validConfig()
+	base.Gateway.Failback.PreferredNodes = []string{"node-1", "node-2"}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    func(c *Config)
+		wantErr bool
+	}{
+		{
+			name: "empty file keeps the base",
+			want: func(*Config) {},
+		},
+		{
+			name: "yaml",
+			data: "gateway:\n  count: 2\n  failback:\n    mode: delayed\n    delay: 1m\n    preferredNodes: [node-3]\n",
+			want: func(c *Config) {
+				c.Gateway.Count = 2
+				c.Gateway.Failback = Failback{
+					Mode:           elector.FailbackModeDelayed,
+					Delay:          metav1.Duration{Duration: time.Minute},
+					PreferredNodes: []string{"node-3"},
+				}
+			},
+		},
+		{
+			name: "json",
+			data: `{"log":{"format":"json"},"policy":{"driftMode":"strict"}}`,
+			want: func(c *Config) {
+				c.Log.Format = utils.LogFormatJSON
+				c.Policy.DriftMode = utils.DriftModeStrict
+			},
+		},
+		{
+			name:    "unknown field",
+			data:    "gateway:\n  leases: kube-vip\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			data:    "gateway:\n  count: 0\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Load(path, base)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			want := validConfig()
+			want.Gateway.Failback.PreferredNodes = []string{"node-1", "node-2"}
+			if tt.want != nil {
+				tt.want(&want)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Load() = %+v, want %+v", got, want)
+			}
+			if !slices.Equal(base.Gateway.Failback.PreferredNodes, []string{"node-1", "node-2"}) {
+				t.Errorf("Load() modified the base preferred nodes: %v", base.Gateway.Failback.PreferredNodes)
+			}
+		})
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "not-found.yaml"), base); err == nil {
+		t.Error("Load() of a missing file returned no error")
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadDelay merges the file events of a ConfigMap update, which replaces
+// the mounted files by several symlink operations.
+const reloadDelay = time.Second
+
+// Watch reloads the configuration file on change and calls apply with the
+// new configuration, the invalid configuration is rejected and the current
+// configuration is kept. It blocks until the context is canceled.
+func Watch(ctx context.Context, path string, base, current Config, apply func(Config)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config file watcher: %w", err)
+	}
+	defer watcher.Close()
+	// Watch the directory as the ConfigMap volume replaces the file symlink.
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to watch config file %q: %w", path, err)
+	}
+	metrics.ConfigLastReloadSuccessful.Set(1)
+	logrus.Infof("Watching config file [%v] for changes", path)
+
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			logrus.Debugf("Config file event: %v", event)
+			timer.Reset(reloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logrus.Warnf("Config file watcher error: %v", err)
+		case <-timer.C:
+			cfg, err := Load(path, base)
+			if err != nil {
+				metrics.ConfigReloads.WithLabelValues("failure").Inc()
+				metrics.ConfigLastReloadSuccessful.Set(0)
+				logrus.Errorf("Rejected config file reload, keeping the current config: %v", err)
+				continue
+			}
+			metrics.ConfigLastReloadSuccessful.Set(1)
+			if changed := cfg.RestartRequired(current); len(changed) > 0 {
+				logrus.Warnf("Config options [%v] changed, restart the operator to apply them",
+					strings.Join(changed, ", "))
+				cfg = cfg.keepRestartRequired(current)
+			}
+			if reflect.DeepEqual(cfg, current) {
+				continue
+			}
+			metrics.ConfigReloads.WithLabelValues("success").Inc()
+			logrus.Infof("Reloaded config file [%v]", path)
+			apply(cfg)
+			current = cfg
+		}
+	}
+}
@@ -2,40 +2,82 @@ package cegp
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
-	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
 	"github.com/cnrancher/cilium-egress-operator/pkg/controller/wrangler"
-	ciliumcontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/cilium.io/v2"
+	corecontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/core/v1"
 	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
 	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
 	"github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 )
 
 const (
 	handlerName = "cilium-egress-operator-cegp"
 
-	hostnameLabelKey   = "kubernetes.io/hostname"
-	defaultEnqueueTime = time.Minute * 3
+	// DefaultResyncInterval is the default interval to re-enqueue the
+	// monitored policies.
+	DefaultResyncInterval = time.Minute * 3
+	// DefaultResyncJitter is the default jitter factor of the resync interval.
+	DefaultResyncJitter = 0.1
 )
 
 type handler struct {
-	cegpCache  ciliumcontroller.CiliumEgressGatewayPolicyCache
-	cegpClient ciliumcontroller.CiliumEgressGatewayPolicyClient
+	kind policy.Kind
 
-	cegpEnqueueAfter func(string, time.Duration)
-	cegpEnqueue      func(string)
+	recorder record.EventRecorder
 
-	opts Options
+	// pending records the policies waiting for the drain period.
+	pending   map[string]struct{}
+	pendingMu sync.Mutex
+
+	// drift records the time the manual edits were first detected.
+	drift map[string]time.Time
+	// writers records the policies whose gateway field was written by the
+	// operator.
+	writers map[string]struct{}
+	driftMu sync.Mutex
+
+	// conflicts records the egressIP conflicts of the policies.
+	conflicts   map[string]*conflict
+	conflictsMu sync.Mutex
+
+	// unmatchable records the policies whose nodeSelector excludes the
+	// desired gateway node.
+	unmatchable   map[string]string
+	unmatchableMu sync.Mutex
+
+	nodeCache corecontroller.NodeCache
 }
 
+// options is the current policy handler options shared by the policy kinds,
+// replaced on the config reload.
+var options atomic.Pointer[Options]
+
 type Options struct {
 	SetPolicyEgressIPToNodeIP bool
 	SetPolicyNodeSelector     bool
+	// DrainPeriod is the time to wait before moving policies on planned
+	// gateway moves, 0 moves policies immediately.
+	DrainPeriod time.Duration
+	// ResyncInterval is the interval to re-enqueue the monitored policies,
+	// 0 disables the periodic re-enqueue.
+	ResyncInterval time.Duration
+	// ResyncJitter is the max jitter factor added to the resync interval to
+	// avoid reconciling all policies at the same time.
+	ResyncJitter float64
+	// DriftMode controls how to handle the manual edits of the policy.
+	DriftMode string
 }
 
 func Register(
@@ -43,145 +85,314 @@ func Register(
 	wctx *wrangler.Context,
 	opts Options,
 ) {
-	logrus.Debugf("CiliumEgressGatewayPolicy Handler Options: %v", utils.DebugPrint(opts))
-	h := &handler{
-		cegpCache:  wctx.Cilium.CiliumEgressGatewayPolicy().Cache(),
-		cegpClient: wctx.Cilium.CiliumEgressGatewayPolicy(),
+	logrus.Debugf("Egress Policy Handler Options: %v", utils.DebugPrint(opts))
+	options.Store(&opts)
+	for _, kind := range policy.Kinds() {
+		h := &handler{
+			kind: kind,
 
-		cegpEnqueueAfter: wctx.Cilium.CiliumEgressGatewayPolicy().EnqueueAfter,
-		cegpEnqueue:      wctx.Cilium.CiliumEgressGatewayPolicy().Enqueue,
+			recorder: wctx.Recorder,
+
+			pending:   make(map[string]struct{}),
+			drift:     make(map[string]time.Time),
+			writers:   make(map[string]struct{}),
+			conflicts: make(map[string]*conflict),
+
+			unmatchable: make(map[string]string),
+
+			nodeCache: wctx.Core.Node().Cache(),
+		}
+		name := handlerName
+		if kind.Kind() != policy.CiliumKind {
+			name = handlerName + "-" + strings.ToLower(kind.Kind())
+		}
+		kind.OnChange(ctx, name, h.handleError(h.sync))
+	}
+}
 
-		opts: opts,
+// Reload replaces the policy handler options and re-enqueues the monitored
+// policies.
+func Reload(opts Options) error {
+	logrus.Debugf("Reload Egress Policy Handler Options: %v", utils.DebugPrint(opts))
+	options.Store(&opts)
+	for _, kind := range policy.Kinds() {
+		policies, err := policy.ListMonitored(kind)
+		if err != nil {
+			return err
+		}
+		for _, p := range policies {
+			kind.Enqueue(p.GetName())
+		}
 	}
+	return nil
+}
 
-	wctx.Cilium.CiliumEgressGatewayPolicy().OnChange(ctx, handlerName, h.handleError(h.sync))
+// options returns the current policy handler options.
+func (h *handler) options() *Options {
+	return options.Load()
 }
 
 func (h *handler) handleError(
-	sync func(string, *ciliumv2.CiliumEgressGatewayPolicy) (*ciliumv2.CiliumEgressGatewayPolicy, error),
-) func(string, *ciliumv2.CiliumEgressGatewayPolicy) (*ciliumv2.CiliumEgressGatewayPolicy, error) {
-	return func(s string, policy *ciliumv2.CiliumEgressGatewayPolicy) (*ciliumv2.CiliumEgressGatewayPolicy, error) {
-		policySynced, err := sync(s, policy)
+	sync func(string, policy.Policy) (policy.Policy, error),
+) func(string, policy.Policy) (policy.Policy, error) {
+	return func(s string, p policy.Policy) (policy.Policy, error) {
+		policySynced, err := sync(s, p)
 		if err != nil {
-			logrus.WithFields(fieldEgressPolicy(policy)).Error(err)
-			return policy, err
+			logrus.WithFields(h.fieldEgressPolicy(p)).Error(err)
+			return p, err
 		}
 		return policySynced, nil
 	}
 }
 
-func (h *handler) sync(_ string, policy *ciliumv2.CiliumEgressGatewayPolicy) (*ciliumv2.CiliumEgressGatewayPolicy, error) {
-	if policy == nil || policy.DeletionTimestamp != nil {
-		return policy, nil
+func (h *handler) sync(key string, p policy.Policy) (policy.Policy, error) {
+	if p == nil || p.GetDeletionTimestamp() != nil {
+		// The policies are cluster scoped, the key is the policy name.
+		h.cleanup(key)
+		return p, nil
 	}
-	if len(policy.Annotations) == 0 || policy.Annotations[utils.WatchAnnotationPrefix] != utils.WatchAnnotationValue {
-		return policy, nil
+	if !policy.Monitored(p) {
+		h.cleanup(p.GetName())
+		return p, nil
 	}
-	if err := h.ensurePolicyAvailable(policy); err != nil {
-		return policy, err
+	if err := h.ensurePolicyAvailable(p); err != nil {
+		return p, err
 	}
-	h.cegpEnqueueAfter(policy.Name, defaultEnqueueTime)
-	return policy, nil
+	if interval := h.options().ResyncInterval; interval > 0 {
+		// wait.Jitter treats the factor 0 as 1, the jitter is disabled by 0.
+		if jitter := h.options().ResyncJitter; jitter > 0 {
+			interval = wait.Jitter(interval, jitter)
+		}
+		h.kind.EnqueueAfter(p.GetName(), interval)
+	}
+	return p, nil
 }
 
-func (h *handler) ensurePolicyAvailable(p *ciliumv2.CiliumEgressGatewayPolicy) error {
-	if p.Spec.EgressGateway == nil {
+func (h *handler) ensurePolicyAvailable(p policy.Policy) error {
+	if !p.HasGateway() {
+		return nil
+	}
+	ip := p.EgressIP()
+
+	g, ok := gateway.Lookup(policy.Group(p))
+	if !ok {
+		logrus.WithFields(h.fieldEgressPolicy(p)).
+			Debugf("Gateway group [%v] not found, skip", policy.Group(p))
 		return nil
 	}
-	ip := getPolicyIP(p)
-	hostname := getPolicyHostname(p)
+	setEgressIP := g.EgressIPToNodeIP(h.options().SetPolicyEgressIPToNodeIP)
 
-	desiredPolicy, needUpdate := h.policyNeedUpdate(p)
+	c, err := h.checkConflict(p)
+	if err != nil {
+		return err
+	}
+	h.setConflict(p, c)
+
+	desiredPolicy, needUpdate := h.policyNeedUpdate(p, g)
 	if !needUpdate {
-		logrus.WithFields(fieldEgressPolicy(p)).
-			Debugf("Policy EgressIP [%v] HostName [%v] is available", ip, hostname)
+		logrus.WithFields(h.fieldEgressPolicy(p)).
+			Debugf("Policy EgressIP [%v] HostName [%v] is available", ip, policy.Gateway(p))
+		h.resetDrift(p.GetName())
+		h.resetUnmatchable(p.GetName())
+		h.observeOwner(p)
+		if hasPendingMove(p) {
+			return h.cancelPendingMove(p)
+		}
 		return nil
 	}
 
+	desiredIP := desiredPolicy.EgressIP()
+	desiredHostname := desiredPolicy.Hostname()
+	desiredHostnames := desiredPolicy.Hostnames()
+	desiredGateway := policy.Gateway(desiredPolicy)
+	if c != nil {
+		delay := h.conflictRetry(p.GetName())
+		logrus.WithFields(h.fieldEgressPolicy(p)).
+			WithFields(logrus.Fields{utils.FieldOldIP: ip, utils.FieldNewIP: desiredIP, utils.FieldReason: "conflict"}).
+			Warnf("Skip updating policy to egressIP [%v] gateway [%v]: conflict detected, retry in %v",
+				desiredIP, desiredGateway, delay)
+		h.kind.EnqueueAfter(p.GetName(), delay)
+		return nil
+	}
+	if err := checkHostnamesMatchable(p, desiredHostname, desiredHostnames); err != nil {
+		h.setUnmatchable(p, err)
+		return nil
+	}
+
+	remaining, driftType := h.checkDrift(p, desiredIP, desiredGateway)
+	if remaining > 0 {
+		logrus.WithFields(h.fieldEgressPolicy(p)).
+			Debugf("Policy manual edit will be reverted in %v", remaining.Round(time.Second))
+		h.kind.EnqueueAfter(p.GetName(), remaining)
+		return nil
+	}
+
+	// Planned moves (maintenance or failback) wait for the drain period
+	// before rewriting the policy to keep the existing connections.
+	st := g.Snapshot()
+	planned := h.plannedMove(p, st)
+	if planned {
+		target := desiredGateway
+		if target == "" {
+			target = desiredIP
+		}
+		remaining, err := h.pendingMove(p, target, st.LastTransitionTime)
+		if err != nil {
+			return err
+		}
+		if remaining > 0 {
+			h.kind.EnqueueAfter(p.GetName(), remaining)
+			return nil
+		}
+	}
+
 	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		pp, err := h.cegpClient.Get(p.Name, metav1.GetOptions{})
+		pp, err := h.kind.Get(p.GetName())
 		if err != nil {
 			return err
 		}
-		pp = pp.DeepCopy()
-		pp.Spec.EgressGateway.EgressIP = desiredPolicy.Spec.EgressGateway.EgressIP
-		if pp.Spec.EgressGateway.NodeSelector == nil {
-			pp.Spec.EgressGateway.NodeSelector = &slimv1.LabelSelector{}
+		if !pp.HasGateway() {
+			return nil
+		}
+		// Only merge the operator managed fields into the latest object,
+		// other matchLabels and matchExpressions are kept as-is.
+		pp = pp.Copy()
+		if err := checkHostnamesMatchable(pp, desiredHostname, desiredHostnames); err != nil {
+			return err
+		}
+		if setEgressIP && desiredIP != "" {
+			pp.SetEgressIP(desiredIP)
 		}
-		if pp.Spec.EgressGateway.NodeSelector.MatchLabels == nil {
-			pp.Spec.EgressGateway.NodeSelector.MatchLabels = make(map[string]slimv1.MatchLabelsValue)
+		if h.options().SetPolicyNodeSelector {
+			switch {
+			case len(desiredHostnames) > 0 && desiredHostname == "":
+				pp.SetHostnames(desiredHostnames)
+			case desiredHostname != "":
+				pp.SetHostname(desiredHostname)
+			}
 		}
-		pp.Spec.EgressGateway.NodeSelector = desiredPolicy.Spec.EgressGateway.NodeSelector
-		_, err = h.cegpClient.Update(pp)
+		clearPendingMove(pp)
+		_, err = h.kind.Update(pp)
 		return err
 	}); err != nil {
-		return fmt.Errorf("failed to sync CiliumEgressGatewayIP %q: %w",
-			p.Name, err)
+		if errors.Is(err, utils.ErrHostnameUnmatchable) {
+			h.setUnmatchable(p, err)
+			return nil
+		}
+		return fmt.Errorf("failed to sync %v %q: %w",
+			h.kind.Kind(), p.GetName(), err)
+	}
+	h.setPending(p.GetName(), false)
+	h.resetDrift(p.GetName())
+	h.resetUnmatchable(p.GetName())
+	h.setOwned(p.GetName(), true)
+	if driftType == driftTypeFailover {
+		metrics.PolicyDrifts.WithLabelValues(driftTypeFailover).Inc()
 	}
+	if planned {
+		h.recorder.Eventf(p.Object(), corev1.EventTypeNormal, eventReasonGatewayMoved,
+			"Policy moved from gateway [%v] to [%v] after drain period %v",
+			policy.Gateway(p), desiredGateway, h.options().DrainPeriod)
+	}
+
+	return nil
+}
+
+// cleanup removes the recorded states and gauges of the deleted policy.
+func (h *handler) cleanup(name string) {
+	h.setPending(name, false)
+	h.resetDrift(name)
+	h.setOwned(name, false)
+	h.resetConflict(name)
+	h.resetUnmatchable(name)
+}
 
+// cancelPendingMove removes the pending move annotations when the policy
+// does not need to be moved anymore.
+func (h *handler) cancelPendingMove(p policy.Policy) error {
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pp, err := h.kind.Get(p.GetName())
+		if err != nil {
+			return err
+		}
+		if !hasPendingMove(pp) {
+			return nil
+		}
+		pp = pp.Copy()
+		clearPendingMove(pp)
+		_, err = h.kind.Update(pp)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to remove pending gateway move on policy %q: %w", p.GetName(), err)
+	}
+	h.setPending(p.GetName(), false)
 	return nil
 }
 
-func (h *handler) policyNeedUpdate(p *ciliumv2.CiliumEgressGatewayPolicy) (*ciliumv2.CiliumEgressGatewayPolicy, bool) {
-	if p == nil || p.Spec.EgressGateway == nil {
+func (h *handler) policyNeedUpdate(p policy.Policy, g *gateway.Group) (policy.Policy, bool) {
+	if p == nil || !p.HasGateway() {
 		return nil, false
 	}
 
-	desiredIP := gateway.LeaderNodeIP()
-	desiredHostname := gateway.LeaderNode()
+	desiredIP := g.LeaderNodeIP()
+	desiredHostname := g.LeaderNode()
 
 	needUpdate := false
-	pp := p.DeepCopy()
-	if h.opts.SetPolicyEgressIPToNodeIP && desiredIP != "" {
-		ip := getPolicyIP(p)
+	pp := p.Copy()
+	if g.EgressIPToNodeIP(h.options().SetPolicyEgressIPToNodeIP) && desiredIP != "" {
+		ip := p.EgressIP()
 		if ip != desiredIP {
 			needUpdate = true
-			pp.Spec.EgressGateway.EgressIP = desiredIP
-			logrus.WithFields(fieldEgressPolicy(p)).
+			pp.SetEgressIP(desiredIP)
+			logrus.WithFields(h.fieldEgressPolicy(p)).
+				WithFields(logrus.Fields{utils.FieldOldIP: ip, utils.FieldNewIP: desiredIP}).
 				Infof("Policy egressIP [%v] is not available, set to [%v]",
 					ip, desiredIP)
 		}
 	}
-	if h.opts.SetPolicyNodeSelector && desiredHostname != "" {
-		hostname := getPolicyHostname(p)
-		if hostname != desiredHostname {
-			needUpdate = true
-			if pp.Spec.EgressGateway.NodeSelector == nil {
-				pp.Spec.EgressGateway.NodeSelector = &slimv1.LabelSelector{}
-			}
-			if pp.Spec.EgressGateway.NodeSelector.MatchLabels == nil {
-				pp.Spec.EgressGateway.NodeSelector.MatchLabels = make(map[string]slimv1.MatchLabelsValue)
-			}
-			pp.Spec.EgressGateway.NodeSelector.MatchLabels[hostnameLabelKey] = desiredHostname
-			logrus.WithFields(fieldEgressPolicy(p)).
-				Infof("Policy node hostname [%v] is not available, set to [%v]",
-					hostname, desiredHostname)
-		}
+	if !h.options().SetPolicyNodeSelector || policy.HostnameSynced(p, g) {
+		return pp, needUpdate
+	}
+	if nodes := g.GatewayNodes(); len(nodes) > 0 {
+		needUpdate = true
+		pp.SetHostnames(nodes)
+		logrus.WithFields(h.fieldEgressPolicy(p)).
+			WithFields(logrus.Fields{utils.FieldNode: strings.Join(nodes, ",")}).
+			Infof("Policy gateway nodes [%v] are not available, set to %v",
+				policy.Gateway(p), nodes)
+	} else if desiredHostname != "" {
+		needUpdate = true
+		pp.SetHostname(desiredHostname)
+		logrus.WithFields(h.fieldEgressPolicy(p)).
+			WithFields(logrus.Fields{utils.FieldNode: desiredHostname}).
+			Infof("Policy node hostname [%v] is not available, set to [%v]",
+				policy.Gateway(p), desiredHostname)
 	}
 
 	return pp, needUpdate
 }
 
-func getPolicyIP(p *ciliumv2.CiliumEgressGatewayPolicy) string {
-	if p == nil || p.Spec.EgressGateway == nil {
-		return ""
+// checkHostnamesMatchable ensures the policy nodeSelector does not exclude
+// the desired gateway node, or the desired gateway nodes of multi-gateway
+// policies.
+func checkHostnamesMatchable(p policy.Policy, hostname string, hostnames []string) error {
+	if hostname == "" && len(hostnames) > 0 {
+		return p.CheckHostnamesMatchable(hostnames)
 	}
-	return p.Spec.EgressGateway.EgressIP
+	return p.CheckHostnameMatchable(hostname)
 }
 
-func getPolicyHostname(p *ciliumv2.CiliumEgressGatewayPolicy) string {
-	if p == nil || p.Spec.EgressGateway == nil || p.Spec.EgressGateway.NodeSelector == nil ||
-		p.Spec.EgressGateway.NodeSelector.MatchLabels == nil {
-		return ""
-	}
-	return p.Spec.EgressGateway.NodeSelector.MatchLabels[hostnameLabelKey]
-}
-
-func fieldEgressPolicy(p *ciliumv2.CiliumEgressGatewayPolicy) logrus.Fields {
+func (h *handler) fieldEgressPolicy(p policy.Policy) logrus.Fields {
 	if p == nil {
 		return logrus.Fields{}
 	}
+	if h.kind.Kind() != policy.CiliumKind {
+		return logrus.Fields{
+			utils.FieldKind:   h.kind.Kind(),
+			utils.FieldPolicy: p.GetName(),
+		}
+	}
 	return logrus.Fields{
-		"EGP": fmt.Sprintf("%v", p.Name),
+		utils.FieldPolicy: p.GetName(),
 	}
 }
@@ -0,0 +1,486 @@
+package cegp
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/fake"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// testKind is the fake policy kind registered for the tests.
+var testKind = &fakeKind{}
+
+func TestMain(m *testing.M) {
+	logrus.SetLevel(logrus.PanicLevel)
+	policy.Register(testKind)
+	os.Exit(m.Run())
+}
+
+// fakeKind is the CiliumEgressGatewayPolicy kind backed by a map.
+type fakeKind struct {
+	mu       sync.Mutex
+	policies map[string]policy.Policy
+	enqueued []string
+	// delays are the durations of the EnqueueAfter calls.
+	delays []time.Duration
+}
+
+// reset replaces the policies of the kind and clears the enqueued names.
+func (k *fakeKind) reset(policies ...policy.Policy) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.policies = make(map[string]policy.Policy, len(policies))
+	for _, p := range policies {
+		k.policies[p.GetName()] = p
+	}
+	k.enqueued = nil
+	k.delays = nil
+}
+
+func (k *fakeKind) Enqueued() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return slices.Clone(k.enqueued)
+}
+
+func (k *fakeKind) Kind() string {
+	return policy.CiliumKind
+}
+
+func (k *fakeKind) OnChange(context.Context, string, func(string, policy.Policy) (policy.Policy, error)) {
+}
+
+func (k *fakeKind) Enqueue(name string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.enqueued = append(k.enqueued, name)
+}
+
+func (k *fakeKind) EnqueueAfter(name string, duration time.Duration) {
+	k.mu.Lock()
+	k.delays = append(k.delays, duration)
+	k.mu.Unlock()
+	k.Enqueue(name)
+}
+
+func (k *fakeKind) Delays() []time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return slices.Clone(k.delays)
+}
+
+func (k *fakeKind) Get(name string) (policy.Policy, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	p, ok := k.policies[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(ciliumv2.Resource("ciliumegressgatewaypolicies"), name)
+	}
+	return p.Copy(), nil
+}
+
+func (k *fakeKind) ByIndex(indexName, key string) ([]policy.Policy, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var policies []policy.Policy
+	for _, p := range k.policies {
+		if !policy.Monitored(p) {
+			continue
+		}
+		var match bool
+		switch indexName {
+		case policy.IndexMonitored:
+			match = true
+		case policy.IndexGroup:
+			match = policy.Group(p) == key
+		case policy.IndexEgressIP:
+			match = p.EgressIP() == key
+		case policy.IndexGatewayNode:
+			match = p.Hostname() == key || slices.Contains(p.Hostnames(), key)
+		}
+		if match {
+			policies = append(policies, p)
+		}
+	}
+	return policies, nil
+}
+
+func (k *fakeKind) Update(p policy.Policy) (policy.Policy, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.policies[p.GetName()] = p.Copy()
+	return p, nil
+}
+
+func newNode(name, ip string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{utils.HostnameLabelKey: name},
+			Annotations: map[string]string{utils.ProvidedNodeIPAnnotationKey: ip},
+		},
+	}
+}
+
+// newTestHandler returns the policy handler of the fake kind with the given
+// options and nodes.
+func newTestHandler(t *testing.T, opts Options, nodes ...*corev1.Node) *handler {
+	t.Helper()
+	options.Store(&opts)
+	t.Cleanup(func() { options.Store(nil) })
+	testKind.reset()
+
+	return &handler{
+		kind:        testKind,
+		recorder:    record.NewFakeRecorder(100),
+		pending:     make(map[string]struct{}),
+		drift:       make(map[string]time.Time),
+		writers:     make(map[string]struct{}),
+		conflicts:   make(map[string]*conflict),
+		unmatchable: make(map[string]string),
+		nodeCache:   fake.NewNodeCache(nodes...),
+	}
+}
+
+// newPolicy returns a monitored policy of the gateway group with the
+// egressIP and the hostname label.
+func newPolicy(name, group, ip, hostname string) policy.Policy {
+	annotations := map[string]string{
+		utils.WatchAnnotationPrefix: utils.WatchAnnotationValue,
+	}
+	if group != "" {
+		annotations[utils.GroupAnnotation] = group
+	}
+	gw := &ciliumv2.EgressGateway{
+		EgressIP:     ip,
+		NodeSelector: &slimv1.LabelSelector{},
+	}
+	if hostname != "" {
+		gw.NodeSelector.MatchLabels = map[string]slimv1.MatchLabelsValue{
+			utils.HostnameLabelKey: hostname,
+		}
+	}
+	return policy.NewCiliumPolicy(&ciliumv2.CiliumEgressGatewayPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: annotations,
+		},
+		Spec: ciliumv2.CiliumEgressGatewayPolicySpec{
+			EgressGateway: gw,
+		},
+	})
+}
+
+func TestCleanup(t *testing.T) {
+	tests := []struct {
+		name    string
+		cleanup string
+		want    int
+	}{
+		{name: "deleted policy", cleanup: "policy-1", want: 0},
+		{name: "other policy", cleanup: "policy-2", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, Options{})
+			p := newPolicy("policy-1", "", "10.0.0.1", "node-1")
+			pending := testutil.ToFloat64(metrics.PolicyPendingMoves)
+			conflicts := testutil.ToFloat64(metrics.PolicyEgressIPConflicts)
+			unmatchable := testutil.ToFloat64(metrics.PolicyHostnameUnmatchable)
+
+			h.setPending(p.GetName(), true)
+			h.setOwned(p.GetName(), true)
+			h.drift[p.GetName()] = time.Now()
+			h.setConflict(p, &conflict{kind: conflictTypeNode, message: "conflict"})
+			h.setUnmatchable(p, utils.ErrHostnameUnmatchable)
+			// The policy object is not available after it is deleted.
+			h.cleanup(tt.cleanup)
+
+			for name, got := range map[string]int{
+				"pending":     len(h.pending),
+				"writers":     len(h.writers),
+				"drift":       len(h.drift),
+				"conflicts":   len(h.conflicts),
+				"unmatchable": len(h.unmatchable),
+			} {
+				if got != tt.want {
+					t.Errorf("%v entries = %v, want %v", name, got, tt.want)
+				}
+			}
+			for name, got := range map[string]float64{
+				"pending":     testutil.ToFloat64(metrics.PolicyPendingMoves) - pending,
+				"conflicts":   testutil.ToFloat64(metrics.PolicyEgressIPConflicts) - conflicts,
+				"unmatchable": testutil.ToFloat64(metrics.PolicyHostnameUnmatchable) - unmatchable,
+			} {
+				if got != float64(tt.want) {
+					t.Errorf("%v gauge = %v, want %v", name, got, tt.want)
+				}
+			}
+			h.cleanup(p.GetName())
+		})
+	}
+}
+
+func TestSyncResync(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		wantMin  time.Duration
+		wantMax  time.Duration
+		disabled bool
+	}{
+		{
+			name:     "resync disabled",
+			opts:     Options{ResyncJitter: DefaultResyncJitter},
+			disabled: true,
+		},
+		{
+			name:    "without jitter",
+			opts:    Options{ResyncInterval: time.Minute},
+			wantMin: time.Minute,
+			wantMax: time.Minute,
+		},
+		{
+			name:    "jitter",
+			opts:    Options{ResyncInterval: time.Minute, ResyncJitter: DefaultResyncJitter},
+			wantMin: time.Minute,
+			wantMax: time.Minute + time.Second*6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, tt.opts)
+			p := newPolicy("policy", "", "10.0.0.1", "node-1")
+			testKind.reset(p)
+
+			const syncs = 100
+			for range syncs {
+				if _, err := h.sync(p.GetName(), p); err != nil {
+					t.Fatalf("sync() error = %v", err)
+				}
+			}
+			delays := testKind.Delays()
+			if tt.disabled {
+				if len(delays) > 0 {
+					t.Errorf("sync() resync delays = %v, want no resync", delays)
+				}
+				return
+			}
+			if len(delays) != syncs {
+				t.Fatalf("sync() resyncs = %v, want %v", len(delays), syncs)
+			}
+			for _, d := range delays {
+				if d < tt.wantMin || d > tt.wantMax {
+					t.Errorf("sync() resync delay = %v, want %v-%v", d, tt.wantMin, tt.wantMax)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncInstance(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance string
+		// policyInstance is the instance annotation of the policy.
+		policyInstance string
+		want           bool
+	}{
+		{name: "default instance", want: true},
+		{name: "default instance ignores other instances", policyInstance: "a"},
+		{name: "instance", instance: "a", policyInstance: "a", want: true},
+		{name: "instance ignores other instances", instance: "a", policyInstance: "b"},
+		{name: "instance ignores unannotated policies", instance: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			utils.SetInstanceID(tt.instance)
+			t.Cleanup(func() { utils.SetInstanceID("") })
+			h := newTestHandler(t, Options{ResyncInterval: time.Minute})
+			p := newPolicy("policy", "", "10.0.0.1", "node-1")
+			if tt.policyInstance != "" {
+				annotations := p.GetAnnotations()
+				annotations[utils.InstanceAnnotation] = tt.policyInstance
+				p.SetAnnotations(annotations)
+			}
+			testKind.reset(p)
+			// The recorded state of the policy managed before it is
+			// reassigned to another instance is removed.
+			h.setOwned(p.GetName(), true)
+
+			if _, err := h.sync(p.GetName(), p); err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+			if got := len(testKind.Enqueued()) > 0; got != tt.want {
+				t.Errorf("sync() resync enqueued = %v, want %v", got, tt.want)
+			}
+			if got := h.owned(p.GetName()); got != tt.want {
+				t.Errorf("owned = %v, want %v", got, tt.want)
+			}
+			policies, err := policy.ListMonitored(testKind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(policies) > 0; got != tt.want {
+				t.Errorf("ListMonitored() = %v, want listed %v", policies, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyNeedUpdate(t *testing.T) {
+	// userHostnames is the hostname In matchExpression owned by the user.
+	userHostnames := slimv1.LabelSelectorRequirement{
+		Key:      utils.HostnameLabelKey,
+		Operator: slimv1.LabelSelectorOpIn,
+		Values:   []string{"node-1", "node-2", "node-3"},
+	}
+	multi := func(hostnames ...string) policy.Policy {
+		p := newPolicy("policy-1", "", "10.0.0.1", "")
+		p.SetHostnames(hostnames)
+		return p
+	}
+
+	tests := []struct {
+		name         string
+		policy       policy.Policy
+		gatewayNodes []string
+		want         bool
+		wantHostname string
+		wantNodes    []string
+		// wantUser is set if the user hostname In matchExpression is kept.
+		wantUser bool
+	}{
+		{
+			name:         "single gateway synced",
+			policy:       newPolicy("policy-1", "", "10.0.0.1", "node-1"),
+			wantHostname: "node-1",
+		},
+		{
+			name:         "single to multi gateway",
+			policy:       newPolicy("policy-1", "", "10.0.0.1", "node-1"),
+			gatewayNodes: []string{"node-1", "node-2"},
+			want:         true,
+			wantNodes:    []string{"node-1", "node-2"},
+		},
+		{
+			name:         "multi gateway synced",
+			policy:       multi("node-1", "node-2"),
+			gatewayNodes: []string{"node-1", "node-2"},
+			wantNodes:    []string{"node-1", "node-2"},
+		},
+		{
+			name:         "multi to single gateway",
+			policy:       multi("node-1", "node-2"),
+			want:         true,
+			wantHostname: "node-1",
+		},
+		{
+			name: "multi to single gateway keeps the user hostnames",
+			policy: func() policy.Policy {
+				p := multi("node-1", "node-2")
+				selector := p.Object().(*ciliumv2.CiliumEgressGatewayPolicy).Spec.EgressGateway.NodeSelector
+				selector.MatchExpressions = append(selector.MatchExpressions, userHostnames)
+				return p
+			}(),
+			want:         true,
+			wantHostname: "node-1",
+			wantUser:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, Options{SetPolicyNodeSelector: true})
+			g := gateway.For(t.Name())
+			t.Cleanup(func() { gateway.Delete(t.Name()) })
+			g.SetLeaderNode("10.0.0.1", "node-1", false)
+			g.SetGatewayNodes(tt.gatewayNodes)
+
+			got, needUpdate := h.policyNeedUpdate(tt.policy, g)
+			if needUpdate != tt.want {
+				t.Errorf("policyNeedUpdate() = %v, want %v", needUpdate, tt.want)
+			}
+			if got.Hostname() != tt.wantHostname {
+				t.Errorf("hostname = %q, want %q", got.Hostname(), tt.wantHostname)
+			}
+			if !slices.Equal(got.Hostnames(), tt.wantNodes) {
+				t.Errorf("gateway nodes = %v, want %v", got.Hostnames(), tt.wantNodes)
+			}
+			if want := strings.Join(tt.wantNodes, ","); got.GetAnnotations()[utils.GatewayNodesAnnotation] != want {
+				t.Errorf("gateway nodes annotation = %q, want %q",
+					got.GetAnnotations()[utils.GatewayNodesAnnotation], want)
+			}
+			if !policy.HostnameSynced(got, g) {
+				t.Error("desired policy is not synced with the gateway group")
+			}
+			expressions := got.Object().(*ciliumv2.CiliumEgressGatewayPolicy).Spec.EgressGateway.NodeSelector.MatchExpressions
+			hasUser := slices.ContainsFunc(expressions, func(e slimv1.LabelSelectorRequirement) bool {
+				return slices.Equal(e.Values, userHostnames.Values)
+			})
+			if hasUser != tt.wantUser {
+				t.Errorf("user hostname matchExpression kept = %v, want %v", hasUser, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestEnsurePolicyAvailableMergesLatest(t *testing.T) {
+	h := newTestHandler(t, Options{SetPolicyNodeSelector: true},
+		newNode("node-1", "10.0.0.1"), newNode("node-2", "10.0.0.2"))
+	g := gateway.For(t.Name())
+	t.Cleanup(func() { gateway.Delete(t.Name()) })
+	g.SetLeaderNode("10.0.0.2", "node-2", false)
+
+	// The egressIP is a virtual IP not managed by the operator.
+	cached := newPolicy("policy-1", t.Name(), "192.168.0.10", "node-1")
+	// The selector is edited by the user after the cached object is
+	// observed, the edit is only visible in the fetched object.
+	latest := cached.Copy()
+	selector := latest.Object().(*ciliumv2.CiliumEgressGatewayPolicy).Spec.EgressGateway.NodeSelector
+	selector.MatchLabels["zone"] = "zone-a"
+	selector.MatchExpressions = []slimv1.LabelSelectorRequirement{{
+		Key:      "egress-gateway",
+		Operator: slimv1.LabelSelectorOpExists,
+	}}
+	testKind.reset(latest)
+
+	if err := h.ensurePolicyAvailable(cached); err != nil {
+		t.Fatalf("ensurePolicyAvailable() error = %v", err)
+	}
+	got, err := testKind.Get("policy-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := latest.Copy()
+	want.SetHostname("node-2")
+	if !reflect.DeepEqual(got.Object(), want.Object()) {
+		t.Errorf("updated policy = %+v, want only the hostname changed %+v",
+			got.Object().(*ciliumv2.CiliumEgressGatewayPolicy).Spec.EgressGateway,
+			want.Object().(*ciliumv2.CiliumEgressGatewayPolicy).Spec.EgressGateway)
+	}
+}
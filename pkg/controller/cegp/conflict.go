@@ -0,0 +1,217 @@
+package cegp
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	conflictTypePolicy = "policy"
+	conflictTypeNode   = "node"
+
+	eventReasonEgressIPConflict = "EgressIPConflict"
+
+	// conflictRetryBase and conflictRetryMax are the backoff of re-checking
+	// the policies blocked by the conflict.
+	conflictRetryBase = time.Second * 5
+	conflictRetryMax  = time.Minute * 5
+)
+
+// conflict is the egressIP conflict of the policy.
+type conflict struct {
+	kind    string
+	message string
+	// peerKind and peer are the conflicting policy, empty for the node
+	// conflicts.
+	peerKind string
+	peer     string
+	// retries is the number of the re-checks while the conflict is kept.
+	retries int
+}
+
+// desiredGateway returns the egressIP and the gateway nodes the policy is
+// going to use, the current values are used for the fields not managed by
+// the operator. The gateway nodes are formatted as policy.Gateway.
+func (h *handler) desiredGateway(p policy.Policy) (string, string) {
+	ip, node := p.EgressIP(), policy.Gateway(p)
+	g, ok := gateway.Lookup(policy.Group(p))
+	if !ok {
+		return ip, node
+	}
+	if g.EgressIPToNodeIP(h.options().SetPolicyEgressIPToNodeIP) && g.LeaderNodeIP() != "" {
+		ip = g.LeaderNodeIP()
+	}
+	if h.options().SetPolicyNodeSelector {
+		if nodes := g.GatewayNodes(); len(nodes) > 0 {
+			node = strings.Join(nodes, ",")
+		} else if g.LeaderNode() != "" {
+			node = g.LeaderNode()
+		}
+	}
+	return ip, node
+}
+
+// checkConflict ensures the desired egressIP of the policy is not assigned
+// to other monitored policies on a different gateway node, and is not owned
+// by a node other than the gateway node. It returns nil if no conflict
+// found.
+func (h *handler) checkConflict(p policy.Policy) (*conflict, error) {
+	ip, node := h.desiredGateway(p)
+	if ip == "" {
+		return nil, nil
+	}
+	for _, kind := range policy.Kinds() {
+		policies, err := h.egressIPPeers(kind, ip)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range policies {
+			if kind.Kind() == h.kind.Kind() && other.GetName() == p.GetName() {
+				continue
+			}
+			if !other.HasGateway() {
+				continue
+			}
+			otherIP, otherNode := h.desiredGateway(other)
+			if otherIP != ip || otherNode == node {
+				continue
+			}
+			return &conflict{
+				kind: conflictTypePolicy,
+				message: fmt.Sprintf("egressIP [%v] is also assigned to %v [%v] on gateway [%v]",
+					ip, kind.Kind(), other.GetName(), otherNode),
+				peerKind: kind.Kind(),
+				peer:     other.GetName(),
+			}, nil
+		}
+	}
+
+	nodes, err := h.nodeCache.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes from cache: %w", err)
+	}
+	for _, n := range nodes {
+		if utils.NodeHostname(n) == node || !utils.NodeOwnsIP(n, ip) {
+			continue
+		}
+		return &conflict{
+			kind: conflictTypeNode,
+			message: fmt.Sprintf("egressIP [%v] is the IP of node [%v] other than gateway [%v]",
+				ip, n.Name, node),
+		}, nil
+	}
+	return nil, nil
+}
+
+// egressIPPeers lists the monitored policies of the kind which may use the
+// egressIP: the policies with the egressIP, and the policies of the gateway
+// groups setting the egressIP to the gateway node IP.
+func (h *handler) egressIPPeers(kind policy.Kind, ip string) ([]policy.Policy, error) {
+	policies, err := policy.ListEgressIP(kind, ip)
+	if err != nil {
+		return nil, err
+	}
+	for name, st := range gateway.Snapshots() {
+		if st.LeaderNodeIP != ip {
+			continue
+		}
+		if g, ok := gateway.Lookup(name); !ok || !g.EgressIPToNodeIP(h.options().SetPolicyEgressIPToNodeIP) {
+			continue
+		}
+		group, err := policy.ListGroup(kind, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range group {
+			if !slices.ContainsFunc(policies, func(other policy.Policy) bool {
+				return other.GetName() == p.GetName()
+			}) {
+				policies = append(policies, p)
+			}
+		}
+	}
+	return policies, nil
+}
+
+// setConflict records the conflict state of the policy, the conflict is
+// reported by a Warning Event when it is detected or changed. The
+// conflicting policy is enqueued when the conflict is resolved, as it may be
+// blocked by the same conflict.
+func (h *handler) setConflict(p policy.Policy, c *conflict) {
+	h.conflictsMu.Lock()
+	defer h.conflictsMu.Unlock()
+
+	// The gauge is shared by the handlers of all policy kinds.
+	last, ok := h.conflicts[p.GetName()]
+	switch {
+	case c == nil:
+		if ok {
+			delete(h.conflicts, p.GetName())
+			metrics.PolicyEgressIPConflicts.Dec()
+			logrus.WithFields(h.fieldEgressPolicy(p)).Infof("Policy egressIP conflict resolved")
+			enqueuePeer(last)
+		}
+		return
+	case !ok:
+		metrics.PolicyEgressIPConflicts.Inc()
+	case last.message == c.message:
+		return
+	}
+	h.conflicts[p.GetName()] = c
+	metrics.PolicyEgressIPConflictsDetected.WithLabelValues(c.kind).Inc()
+	logrus.WithFields(h.fieldEgressPolicy(p)).
+		WithFields(logrus.Fields{utils.FieldReason: c.kind}).
+		Warnf("Policy %v", c.message)
+	h.recorder.Eventf(p.Object(), corev1.EventTypeWarning, eventReasonEgressIPConflict,
+		"Policy %v, the gateway assignment is not written until the conflict is resolved", c.message)
+}
+
+// conflictRetry returns the backoff to re-check the policy blocked by the
+// conflict, doubled on each retry up to conflictRetryMax.
+func (h *handler) conflictRetry(name string) time.Duration {
+	h.conflictsMu.Lock()
+	defer h.conflictsMu.Unlock()
+
+	c, ok := h.conflicts[name]
+	if !ok {
+		return conflictRetryBase
+	}
+	delay := min(conflictRetryBase<<c.retries, conflictRetryMax)
+	if delay < conflictRetryMax {
+		c.retries++
+	}
+	return delay
+}
+
+func (h *handler) resetConflict(name string) {
+	h.conflictsMu.Lock()
+	defer h.conflictsMu.Unlock()
+
+	if c, ok := h.conflicts[name]; ok {
+		delete(h.conflicts, name)
+		metrics.PolicyEgressIPConflicts.Dec()
+		enqueuePeer(c)
+	}
+}
+
+// enqueuePeer enqueues the conflicting policy of the conflict.
+func enqueuePeer(c *conflict) {
+	if c == nil || c.peer == "" {
+		return
+	}
+	for _, kind := range policy.Kinds() {
+		if kind.Kind() == c.peerKind {
+			kind.Enqueue(c.peer)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package cegp
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCheckConflict(t *testing.T) {
+	// The policies of the group follow the gateway node-1, the policies of
+	// the groups not found keep their egressIP and hostname.
+	const group = "conflict"
+	g := gateway.For(group)
+	t.Cleanup(func() { gateway.Delete(group) })
+	g.SetLeaderNode("10.0.0.1", "node-1", false)
+	// The policies of the multi-gateway group follow the gateway nodes
+	// node-5 and node-6.
+	const multiGroup = "conflict-multi"
+	mg := gateway.For(multiGroup)
+	t.Cleanup(func() { gateway.Delete(multiGroup) })
+	mg.SetLeaderNode("10.0.0.5", "node-5", false)
+	mg.SetGatewayNodes([]string{"node-5", "node-6"})
+
+	multi := newPolicy("other", "manual", "10.0.0.5", "")
+	multi.SetHostnames([]string{"node-5", "node-6"})
+
+	unmonitored := newPolicy("unmonitored", "manual", "10.0.0.1", "node-2")
+	unmonitored.SetAnnotations(nil)
+
+	tests := []struct {
+		name     string
+		policy   policy.Policy
+		others   []policy.Policy
+		nodes    []*corev1.Node
+		want     string
+		wantPeer string
+	}{
+		{
+			name:   "no egressIP",
+			policy: newPolicy("policy", "manual", "", "node-1"),
+			others: []policy.Policy{newPolicy("other", "manual", "", "node-2")},
+		},
+		{
+			name:   "gateway node IP",
+			policy: newPolicy("policy", group, "", ""),
+			nodes:  []*corev1.Node{newNode("node-1", "10.0.0.1")},
+		},
+		{
+			name:     "same egressIP on another gateway",
+			policy:   newPolicy("policy", group, "", ""),
+			others:   []policy.Policy{newPolicy("other", "manual", "10.0.0.1", "node-2")},
+			want:     conflictTypePolicy,
+			wantPeer: "other",
+		},
+		{
+			name:   "same egressIP on the same gateway",
+			policy: newPolicy("policy", group, "", ""),
+			others: []policy.Policy{
+				newPolicy("same-group", group, "10.0.0.9", "node-9"),
+				newPolicy("other", "manual", "10.0.0.1", "node-1"),
+			},
+		},
+		{
+			name:     "group policy following the same egressIP on another gateway",
+			policy:   newPolicy("policy", "manual", "10.0.0.1", "node-2"),
+			others:   []policy.Policy{newPolicy("other", group, "", "")},
+			want:     conflictTypePolicy,
+			wantPeer: "other",
+		},
+		{
+			name:   "same egressIP on the same gateway nodes",
+			policy: newPolicy("policy", multiGroup, "", ""),
+			others: []policy.Policy{multi},
+		},
+		{
+			name:     "same egressIP on one of the gateway nodes",
+			policy:   newPolicy("policy", multiGroup, "", ""),
+			others:   []policy.Policy{newPolicy("other", "manual", "10.0.0.5", "node-5")},
+			want:     conflictTypePolicy,
+			wantPeer: "other",
+		},
+		{
+			name:   "unmonitored policy is ignored",
+			policy: newPolicy("policy", group, "", ""),
+			others: []policy.Policy{unmonitored},
+		},
+		{
+			name:   "egressIP of another node",
+			policy: newPolicy("policy", "manual", "10.0.0.2", "node-1"),
+			nodes: []*corev1.Node{
+				newNode("node-1", "10.0.0.1"),
+				newNode("node-2", "10.0.0.2"),
+			},
+			want: conflictTypeNode,
+		},
+		{
+			name:   "egressIP of the gateway node",
+			policy: newPolicy("policy", "manual", "10.0.0.2", "node-2"),
+			nodes: []*corev1.Node{
+				newNode("node-1", "10.0.0.1"),
+				newNode("node-2", "10.0.0.2"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, Options{
+				SetPolicyEgressIPToNodeIP: true,
+				SetPolicyNodeSelector:     true,
+			}, tt.nodes...)
+			testKind.reset(append([]policy.Policy{tt.policy}, tt.others...)...)
+
+			c, err := h.checkConflict(tt.policy)
+			if err != nil {
+				t.Fatalf("checkConflict() error = %v", err)
+			}
+			var got, peer string
+			if c != nil {
+				got, peer = c.kind, c.peer
+			}
+			if got != tt.want {
+				t.Errorf("checkConflict() type = %q, want %q", got, tt.want)
+			}
+			if peer != tt.wantPeer {
+				t.Errorf("checkConflict() peer = %q, want %q", peer, tt.wantPeer)
+			}
+		})
+	}
+}
+
+func TestSetConflict(t *testing.T) {
+	h := newTestHandler(t, Options{})
+	p := newPolicy("policy", "", "10.0.0.1", "node-1")
+	c := &conflict{
+		kind:     conflictTypePolicy,
+		message:  "egressIP conflict",
+		peerKind: policy.CiliumKind,
+		peer:     "other",
+	}
+	conflicts := testutil.ToFloat64(metrics.PolicyEgressIPConflicts)
+	detected := testutil.ToFloat64(metrics.PolicyEgressIPConflictsDetected.WithLabelValues(conflictTypePolicy))
+
+	steps := []struct {
+		name          string
+		conflict      *conflict
+		wantConflicts float64
+		wantDetected  float64
+		wantEnqueued  []string
+	}{
+		{name: "detected", conflict: c, wantConflicts: 1, wantDetected: 1},
+		{name: "unchanged", conflict: c, wantConflicts: 1, wantDetected: 1},
+		{name: "resolved", wantDetected: 1, wantEnqueued: []string{"other"}},
+		{name: "still resolved", wantDetected: 1, wantEnqueued: []string{"other"}},
+	}
+	for _, step := range steps {
+		h.setConflict(p, step.conflict)
+		if got := testutil.ToFloat64(metrics.PolicyEgressIPConflicts) - conflicts; got != step.wantConflicts {
+			t.Errorf("%v: conflicts = %v, want %v", step.name, got, step.wantConflicts)
+		}
+		got := testutil.ToFloat64(metrics.PolicyEgressIPConflictsDetected.WithLabelValues(conflictTypePolicy)) - detected
+		if got != step.wantDetected {
+			t.Errorf("%v: detected conflicts = %v, want %v", step.name, got, step.wantDetected)
+		}
+		if got := testKind.Enqueued(); !slices.Equal(got, step.wantEnqueued) {
+			t.Errorf("%v: enqueued = %v, want %v", step.name, got, step.wantEnqueued)
+		}
+	}
+}
+
+func TestConflictRetry(t *testing.T) {
+	h := newTestHandler(t, Options{})
+	p := newPolicy("policy", "", "10.0.0.1", "node-1")
+	if got := h.conflictRetry(p.GetName()); got != conflictRetryBase {
+		t.Errorf("conflictRetry() without conflict = %v, want %v", got, conflictRetryBase)
+	}
+
+	h.setConflict(p, &conflict{kind: conflictTypeNode, message: "egressIP conflict"})
+	t.Cleanup(func() { h.resetConflict(p.GetName()) })
+	want := []time.Duration{
+		time.Second * 5,
+		time.Second * 10,
+		time.Second * 20,
+		time.Second * 40,
+		time.Second * 80,
+		time.Second * 160,
+		time.Minute * 5,
+		time.Minute * 5,
+	}
+	for i, w := range want {
+		if got := h.conflictRetry(p.GetName()); got != w {
+			t.Errorf("conflictRetry() #%v = %v, want %v", i, got, w)
+		}
+	}
+
+	// A changed conflict restarts the backoff.
+	h.setConflict(p, &conflict{kind: conflictTypeNode, message: "another egressIP conflict"})
+	if got := h.conflictRetry(p.GetName()); got != conflictRetryBase {
+		t.Errorf("conflictRetry() after the conflict changed = %v, want %v", got, conflictRetryBase)
+	}
+}
+
+func TestResetConflict(t *testing.T) {
+	tests := []struct {
+		name         string
+		conflict     *conflict
+		wantEnqueued []string
+	}{
+		{
+			name: "policy conflict",
+			conflict: &conflict{
+				kind:     conflictTypePolicy,
+				message:  "egressIP conflict",
+				peerKind: policy.CiliumKind,
+				peer:     "other",
+			},
+			wantEnqueued: []string{"other"},
+		},
+		{
+			name: "peer of another kind",
+			conflict: &conflict{
+				kind:     conflictTypePolicy,
+				message:  "egressIP conflict",
+				peerKind: policy.IsovalentKind,
+				peer:     "other",
+			},
+		},
+		{
+			name:     "node conflict",
+			conflict: &conflict{kind: conflictTypeNode, message: "egressIP conflict"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, Options{})
+			p := newPolicy("policy", "", "10.0.0.1", "node-1")
+			h.setConflict(p, tt.conflict)
+			h.resetConflict(p.GetName())
+			if got := testKind.Enqueued(); !slices.Equal(got, tt.wantEnqueued) {
+				t.Errorf("enqueued = %v, want %v", got, tt.wantEnqueued)
+			}
+		})
+	}
+}
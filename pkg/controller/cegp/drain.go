@@ -0,0 +1,108 @@
+package cegp
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	eventReasonGatewayMovePending = "GatewayMovePending"
+	eventReasonGatewayMoved       = "GatewayMoved"
+)
+
+// plannedMove returns true if the policy update is the planned move of the
+// gateway group from the previous node to the current leader node, the
+// other updates of the group policies are not delayed by the drain period.
+func (h *handler) plannedMove(p policy.Policy, st gateway.State) bool {
+	if h.options().DrainPeriod <= 0 || !st.Planned || st.PreviousNode == "" {
+		return false
+	}
+	return p.Hostname() == st.PreviousNode ||
+		slices.Contains(p.Hostnames(), st.PreviousNode) ||
+		(st.PreviousNodeIP != "" && p.EgressIP() == st.PreviousNodeIP)
+}
+
+// pendingMove annotates the move intent on the policy and returns the
+// remaining drain period before the policy can be moved to the target. The
+// annotated intent is only reused if it is recorded for the same target
+// after the gateway transition.
+func (h *handler) pendingMove(p policy.Policy, target string, transition time.Time) (time.Duration, error) {
+	now := time.Now()
+	annotations := p.GetAnnotations()
+	since, err := time.Parse(time.RFC3339, annotations[utils.PendingSinceAnnotation])
+	if err == nil && annotations[utils.PendingGatewayAnnotation] == target &&
+		!since.Before(transition.Truncate(time.Second)) {
+		h.setPending(p.GetName(), true)
+		return since.Add(h.options().DrainPeriod).Sub(now), nil
+	}
+
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pp, err := h.kind.Get(p.GetName())
+		if err != nil {
+			return err
+		}
+		pp = pp.Copy()
+		annotations := pp.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[utils.PendingGatewayAnnotation] = target
+		annotations[utils.PendingSinceAnnotation] = now.UTC().Format(time.RFC3339)
+		pp.SetAnnotations(annotations)
+		_, err = h.kind.Update(pp)
+		return err
+	}); err != nil {
+		return 0, fmt.Errorf("failed to annotate pending gateway move on policy %q: %w", p.GetName(), err)
+	}
+	h.setPending(p.GetName(), true)
+	logrus.WithFields(h.fieldEgressPolicy(p)).
+		WithFields(logrus.Fields{utils.FieldReason: "drain-period"}).
+		Infof("Policy will be moved to gateway [%v] after drain period %v", target, h.options().DrainPeriod)
+	h.recorder.Eventf(p.Object(), corev1.EventTypeNormal, eventReasonGatewayMovePending,
+		"Policy will be moved from gateway [%v] to [%v] after drain period %v",
+		policy.Gateway(p), target, h.options().DrainPeriod)
+	return h.options().DrainPeriod, nil
+}
+
+func (h *handler) setPending(name string, pending bool) {
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+
+	// The gauge is shared by the handlers of all policy kinds.
+	_, ok := h.pending[name]
+	switch {
+	case pending && !ok:
+		h.pending[name] = struct{}{}
+		metrics.PolicyPendingMoves.Inc()
+	case !pending && ok:
+		delete(h.pending, name)
+		metrics.PolicyPendingMoves.Dec()
+	}
+}
+
+func hasPendingMove(p policy.Policy) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.GetAnnotations()[utils.PendingGatewayAnnotation]
+	return ok
+}
+
+func clearPendingMove(p policy.Policy) {
+	annotations := p.GetAnnotations()
+	if len(annotations) == 0 {
+		return
+	}
+	delete(annotations, utils.PendingGatewayAnnotation)
+	delete(annotations, utils.PendingSinceAnnotation)
+	p.SetAnnotations(annotations)
+}
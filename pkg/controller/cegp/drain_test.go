@@ -0,0 +1,223 @@
+package cegp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPlannedMove(t *testing.T) {
+	planned := gateway.State{
+		LeaderNode:         "node-2",
+		LeaderNodeIP:       "10.0.0.2",
+		PreviousNode:       "node-1",
+		PreviousNodeIP:     "10.0.0.1",
+		LastTransitionTime: time.Now(),
+		Planned:            true,
+	}
+	failover := planned
+	failover.Planned = false
+
+	multi := newPolicy("policy", "", "", "")
+	multi.SetHostnames([]string{"node-1", "node-3"})
+
+	tests := []struct {
+		name        string
+		drainPeriod time.Duration
+		state       gateway.State
+		policy      policy.Policy
+		want        bool
+	}{
+		{
+			name:   "drain period disabled",
+			state:  planned,
+			policy: newPolicy("policy", "", "10.0.0.1", "node-1"),
+		},
+		{
+			name:        "failover move",
+			drainPeriod: time.Minute,
+			state:       failover,
+			policy:      newPolicy("policy", "", "10.0.0.1", "node-1"),
+		},
+		{
+			name:        "policy on the previous node",
+			drainPeriod: time.Minute,
+			state:       planned,
+			policy:      newPolicy("policy", "", "10.0.0.1", "node-1"),
+			want:        true,
+		},
+		{
+			name:        "policy egressIP of the previous node",
+			drainPeriod: time.Minute,
+			state:       planned,
+			policy:      newPolicy("policy", "", "10.0.0.1", ""),
+			want:        true,
+		},
+		{
+			name:        "multi-gateway policy selecting the previous node",
+			drainPeriod: time.Minute,
+			state:       planned,
+			policy:      multi,
+			want:        true,
+		},
+		{
+			name:        "new policy without gateway",
+			drainPeriod: time.Minute,
+			state:       planned,
+			policy:      newPolicy("policy", "", "", ""),
+		},
+		{
+			name:        "policy edited to another node",
+			drainPeriod: time.Minute,
+			state:       planned,
+			policy:      newPolicy("policy", "", "10.0.0.3", "node-3"),
+		},
+		{
+			name:        "no previous node",
+			drainPeriod: time.Minute,
+			state: gateway.State{
+				LeaderNode:   "node-2",
+				LeaderNodeIP: "10.0.0.2",
+				Planned:      true,
+			},
+			policy: newPolicy("policy", "", "", ""),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, Options{DrainPeriod: tt.drainPeriod})
+			if got := h.plannedMove(tt.policy, tt.state); got != tt.want {
+				t.Errorf("plannedMove() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPendingMove(t *testing.T) {
+	const drainPeriod = time.Minute
+	transition := time.Now().Add(-time.Second * 30)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		target      string
+		// wantRemaining is the expected remaining drain period, compared
+		// within a few seconds.
+		wantRemaining time.Duration
+		wantAnnotated bool
+	}{
+		{
+			name:          "new pending move",
+			target:        "node-2",
+			wantRemaining: drainPeriod,
+			wantAnnotated: true,
+		},
+		{
+			name: "pending move of the same target",
+			annotations: map[string]string{
+				utils.PendingGatewayAnnotation: "node-2",
+				utils.PendingSinceAnnotation:   transition.Add(time.Second * 10).UTC().Format(time.RFC3339),
+			},
+			target:        "node-2",
+			wantRemaining: drainPeriod - time.Second*20,
+		},
+		{
+			name: "pending move of another target",
+			annotations: map[string]string{
+				utils.PendingGatewayAnnotation: "node-3",
+				utils.PendingSinceAnnotation:   transition.UTC().Format(time.RFC3339),
+			},
+			target:        "node-2",
+			wantRemaining: drainPeriod,
+			wantAnnotated: true,
+		},
+		{
+			name: "pending move before the transition",
+			annotations: map[string]string{
+				utils.PendingGatewayAnnotation: "node-2",
+				utils.PendingSinceAnnotation:   transition.Add(-time.Hour).UTC().Format(time.RFC3339),
+			},
+			target:        "node-2",
+			wantRemaining: drainPeriod,
+			wantAnnotated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, Options{DrainPeriod: drainPeriod})
+			p := newPolicy("policy", "", "10.0.0.1", "node-1")
+			annotations := p.GetAnnotations()
+			for k, v := range tt.annotations {
+				annotations[k] = v
+			}
+			p.SetAnnotations(annotations)
+			testKind.reset(p)
+			t.Cleanup(func() { h.setPending(p.GetName(), false) })
+
+			remaining, err := h.pendingMove(p, tt.target, transition)
+			if err != nil {
+				t.Fatalf("pendingMove() error = %v", err)
+			}
+			if d := remaining - tt.wantRemaining; d < -time.Second*2 || d > time.Second*2 {
+				t.Errorf("pendingMove() remaining = %v, want %v", remaining, tt.wantRemaining)
+			}
+			updated, _ := testKind.Get(p.GetName())
+			annotated := updated.GetAnnotations()[utils.PendingSinceAnnotation] != tt.annotations[utils.PendingSinceAnnotation]
+			if annotated != tt.wantAnnotated {
+				t.Errorf("pendingMove() annotated = %v, want %v", annotated, tt.wantAnnotated)
+			}
+			if got := updated.GetAnnotations()[utils.PendingGatewayAnnotation]; got != tt.target {
+				t.Errorf("pending gateway = %q, want %q", got, tt.target)
+			}
+		})
+	}
+}
+
+func TestPendingMoveEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		hostname  string
+		hostnames []string
+		want      string
+	}{
+		{
+			name:     "single gateway",
+			hostname: "node-1",
+			want:     "from gateway [node-1] to [node-2]",
+		},
+		{
+			name:      "multiple gateways",
+			hostnames: []string{"node-1", "node-3"},
+			want:      "from gateway [node-1,node-3] to [node-2]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, Options{DrainPeriod: time.Minute})
+			recorder := h.recorder.(*record.FakeRecorder)
+			p := newPolicy("policy", "", "10.0.0.1", tt.hostname)
+			if len(tt.hostnames) > 0 {
+				p.SetHostnames(tt.hostnames)
+			}
+			testKind.reset(p)
+			t.Cleanup(func() { h.setPending(p.GetName(), false) })
+
+			if _, err := h.pendingMove(p, "node-2", time.Now()); err != nil {
+				t.Fatalf("pendingMove() error = %v", err)
+			}
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, tt.want) {
+					t.Errorf("pendingMove() event = %q, want %q", event, tt.want)
+				}
+			default:
+				t.Errorf("pendingMove() expected a %v event", eventReasonGatewayMovePending)
+			}
+		})
+	}
+}
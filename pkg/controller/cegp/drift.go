@@ -0,0 +1,204 @@
+package cegp
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	driftTypeFailover   = "failover"
+	driftTypeManualEdit = "manual-edit"
+
+	eventReasonDriftDetected = "DriftDetected"
+)
+
+var (
+	// egressIPFields is the managedFields path of the policy egressIP under
+	// the gateway field.
+	egressIPFields = []string{"f:egressIP"}
+	// hostnameFields are the managedFields paths of the gateway node
+	// hostname matchLabel and the gateway nodes expression under the
+	// gateway field, the user labels of the nodeSelector are not included.
+	hostnameFields = [][]string{
+		{"f:nodeSelector", "f:matchLabels", "f:" + corev1.LabelHostname},
+		{"f:nodeSelector", "f:matchExpressions"},
+	}
+)
+
+// classifyDrift classifies the difference between the policy and the desired
+// gateway by the field managers which own the differing gateway fields, owned
+// reports whether the operator wrote the gateway field before. Returns an
+// empty drift type if the gateway field was never written by the operator,
+// e.g. a new policy, which is filled immediately.
+func classifyDrift(p policy.Policy, owned bool, fields [][]string) (driftType, manager string) {
+	var operator bool
+	for _, path := range fields {
+		managers := gatewayFieldManagers(p, path)
+		if slices.Contains(managers, utils.FieldManager) {
+			operator = true
+			continue
+		}
+		if manager == "" && len(managers) > 0 {
+			manager = managers[0]
+		}
+	}
+	switch {
+	case manager == "" && operator:
+		// The differing fields were last written by the operator, the
+		// gateway moved.
+		return driftTypeFailover, utils.FieldManager
+	case !owned:
+		return "", manager
+	case manager == "":
+		// The managedFields are not available, the operator wrote the
+		// gateway field before.
+		return driftTypeFailover, manager
+	}
+	// Another manager overwrote the gateway field written by the operator.
+	return driftTypeManualEdit, manager
+}
+
+// driftFields returns the managedFields paths of the gateway fields which
+// differ from the desired gateway, or all gateway fields if the difference
+// is not in the egressIP or the gateway nodes.
+func driftFields(p policy.Policy, desiredIP, desiredGateway string) [][]string {
+	var fields [][]string
+	if p.EgressIP() != desiredIP {
+		fields = append(fields, egressIPFields)
+	}
+	if policy.Gateway(p) != desiredGateway {
+		fields = append(fields, hostnameFields...)
+	}
+	if len(fields) == 0 {
+		fields = append([][]string{egressIPFields}, hostnameFields...)
+	}
+	return fields
+}
+
+// checkDrift classifies the difference between the policy and the desired
+// gateway, returns the remaining time to wait before reverting the manual edit
+// and the drift type.
+func (h *handler) checkDrift(p policy.Policy, desiredIP, desiredGateway string) (time.Duration, string) {
+	driftType, manager := classifyDrift(p, h.owned(p.GetName()), driftFields(p, desiredIP, desiredGateway))
+	if driftType != driftTypeManualEdit {
+		if driftType == driftTypeFailover {
+			h.setOwned(p.GetName(), true)
+		}
+		h.resetDrift(p.GetName())
+		return 0, driftType
+	}
+
+	h.driftMu.Lock()
+	detected, ok := h.drift[p.GetName()]
+	if !ok {
+		detected = time.Now()
+		h.drift[p.GetName()] = detected
+	}
+	h.driftMu.Unlock()
+
+	if !ok {
+		metrics.PolicyDrifts.WithLabelValues(driftTypeManualEdit).Inc()
+		logrus.WithFields(h.fieldEgressPolicy(p)).
+			WithFields(logrus.Fields{
+				utils.FieldOldIP:  p.EgressIP(),
+				utils.FieldNewIP:  desiredIP,
+				utils.FieldReason: driftTypeManualEdit,
+			}).
+			Warnf("Policy %v was manually edited by [%v]: egressIP [%v] gateway [%v], desired egressIP [%v] gateway [%v]",
+				p.GatewayField(), manager, p.EgressIP(), policy.Gateway(p), desiredIP, desiredGateway)
+		h.recorder.Eventf(p.Object(), corev1.EventTypeWarning, eventReasonDriftDetected,
+			"Policy %v was manually edited by [%v], desired egressIP [%v] gateway [%v]",
+			p.GatewayField(), manager, desiredIP, desiredGateway)
+	}
+	if h.options().DriftMode == utils.DriftModeStrict {
+		return 0, driftType
+	}
+
+	grace := h.options().ResyncInterval
+	if grace <= 0 {
+		grace = DefaultResyncInterval
+	}
+	return time.Until(detected.Add(grace)), driftType
+}
+
+// observeOwner records the policy gateway field owned by the operator, so the
+// later manual edits are detected after the operator restarts.
+func (h *handler) observeOwner(p policy.Policy) {
+	for _, path := range append([][]string{egressIPFields}, hostnameFields...) {
+		if slices.Contains(gatewayFieldManagers(p, path), utils.FieldManager) {
+			h.setOwned(p.GetName(), true)
+			return
+		}
+	}
+}
+
+// owned returns true if the operator wrote the policy gateway field.
+func (h *handler) owned(name string) bool {
+	h.driftMu.Lock()
+	defer h.driftMu.Unlock()
+
+	_, ok := h.writers[name]
+	return ok
+}
+
+func (h *handler) setOwned(name string, owned bool) {
+	h.driftMu.Lock()
+	defer h.driftMu.Unlock()
+
+	if owned {
+		h.writers[name] = struct{}{}
+		return
+	}
+	delete(h.writers, name)
+}
+
+func (h *handler) resetDrift(name string) {
+	h.driftMu.Lock()
+	defer h.driftMu.Unlock()
+
+	delete(h.drift, name)
+}
+
+// gatewayFieldManagers returns the field managers which own the field path
+// under the policy gateway field. An atomic gateway field, e.g. the
+// egressGroups list of the isovalent policy, is owned as a whole. The entry
+// timestamps are not compared, as they are refreshed by the writes of any
+// field of the manager.
+func gatewayFieldManagers(p policy.Policy, path []string) []string {
+	var managers []string
+	for _, f := range p.GetManagedFields() {
+		if f.FieldsV1 == nil {
+			continue
+		}
+		var set map[string]any
+		if err := json.Unmarshal(f.FieldsV1.Raw, &set); err != nil {
+			continue
+		}
+		gateway, ok := lookupFields(set, "f:spec", "f:"+p.GatewayField())
+		if !ok {
+			continue
+		}
+		if _, ok := lookupFields(gateway, path...); ok || len(gateway) == 0 {
+			managers = append(managers, f.Manager)
+		}
+	}
+	return managers
+}
+
+func lookupFields(set map[string]any, path ...string) (map[string]any, bool) {
+	for _, key := range path {
+		next, ok := set[key].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		set = next
+	}
+	return set, true
+}
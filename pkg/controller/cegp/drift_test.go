@@ -0,0 +1,279 @@
+package cegp
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	gatewayFields  = `{"f:spec":{"f:egressGateway":{"f:egressIP":{},"f:nodeSelector":{"f:matchLabels":{"f:kubernetes.io/hostname":{}}}}}}`
+	egressIPField  = `{"f:spec":{"f:egressGateway":{"f:egressIP":{}}}}`
+	hostnameField  = `{"f:spec":{"f:egressGateway":{"f:nodeSelector":{"f:matchLabels":{"f:kubernetes.io/hostname":{}}}}}}`
+	userLabelField = `{"f:spec":{"f:egressGateway":{"f:nodeSelector":{"f:matchLabels":{"f:app":{}}}}}}`
+	otherFields    = `{"f:metadata":{"f:labels":{}}}`
+)
+
+func managedFieldsEntry(manager, fields string, t time.Time) metav1.ManagedFieldsEntry {
+	mt := metav1.NewTime(t)
+	return metav1.ManagedFieldsEntry{
+		Manager:   manager,
+		Operation: metav1.ManagedFieldsOperationUpdate,
+		Time:      &mt,
+		FieldsV1:  &metav1.FieldsV1{Raw: []byte(fields)},
+	}
+}
+
+func policyManagedBy(entries ...metav1.ManagedFieldsEntry) policy.Policy {
+	p := newPolicy("policy", "", "10.0.0.1", "node-1")
+	p.SetManagedFields(entries)
+	return p
+}
+
+func TestGatewayFieldManagers(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		entries []metav1.ManagedFieldsEntry
+		path    []string
+		want    []string
+	}{
+		{
+			name: "no managed fields",
+			path: egressIPFields,
+		},
+		{
+			name: "operator",
+			entries: []metav1.ManagedFieldsEntry{
+				managedFieldsEntry(utils.FieldManager, gatewayFields, now),
+			},
+			path: egressIPFields,
+			want: []string{utils.FieldManager},
+		},
+		{
+			name: "owner of the field regardless of the entry time",
+			entries: []metav1.ManagedFieldsEntry{
+				managedFieldsEntry("kubectl-edit", egressIPField, now.Add(-time.Minute)),
+				managedFieldsEntry(utils.FieldManager, hostnameField, now),
+			},
+			path: egressIPFields,
+			want: []string{"kubectl-edit"},
+		},
+		{
+			name: "user labels of the nodeSelector are ignored",
+			entries: []metav1.ManagedFieldsEntry{
+				managedFieldsEntry("kubectl-edit", userLabelField, now),
+				managedFieldsEntry(utils.FieldManager, hostnameField, now.Add(-time.Minute)),
+			},
+			path: hostnameFields[0],
+			want: []string{utils.FieldManager},
+		},
+		{
+			name: "other fields are ignored",
+			entries: []metav1.ManagedFieldsEntry{
+				managedFieldsEntry("kubectl-label", otherFields, now),
+			},
+			path: egressIPFields,
+		},
+		{
+			name: "invalid fields are ignored",
+			entries: []metav1.ManagedFieldsEntry{
+				managedFieldsEntry("kubectl-edit", `{"f:spec"`, now),
+			},
+			path: egressIPFields,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := gatewayFieldManagers(policyManagedBy(tt.entries...), tt.path)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("gatewayFieldManagers() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// atomicPolicy reports an atomic list gateway field, as the egressGroups of
+// the isovalent policy.
+type atomicPolicy struct {
+	policy.Policy
+}
+
+func (atomicPolicy) GatewayField() string {
+	return "egressGroups"
+}
+
+func TestGatewayFieldManagersAtomic(t *testing.T) {
+	p := atomicPolicy{newPolicy("policy", "", "10.0.0.1", "node-1")}
+	p.SetManagedFields([]metav1.ManagedFieldsEntry{
+		managedFieldsEntry(utils.FieldManager, `{"f:spec":{"f:egressGroups":{}}}`, time.Now()),
+	})
+	for _, path := range append([][]string{egressIPFields}, hostnameFields...) {
+		if got := gatewayFieldManagers(p, path); !slices.Equal(got, []string{utils.FieldManager}) {
+			t.Errorf("gatewayFieldManagers(%v) = %q, want the operator", path, got)
+		}
+	}
+}
+
+func TestClassifyDrift(t *testing.T) {
+	now := time.Now()
+	operator := managedFieldsEntry(utils.FieldManager, gatewayFields, now)
+	user := managedFieldsEntry("kubectl-edit", gatewayFields, now)
+	allFields := append([][]string{egressIPFields}, hostnameFields...)
+
+	tests := []struct {
+		name    string
+		entries []metav1.ManagedFieldsEntry
+		fields  [][]string
+		owned   bool
+		want    string
+	}{
+		{
+			name:    "last written by the operator",
+			entries: []metav1.ManagedFieldsEntry{operator},
+			fields:  allFields,
+			want:    driftTypeFailover,
+		},
+		{
+			name:    "first fill of a new policy",
+			entries: []metav1.ManagedFieldsEntry{user},
+			fields:  allFields,
+		},
+		{
+			name:   "first fill without managed fields",
+			fields: allFields,
+		},
+		{
+			name:   "owned without managed fields",
+			fields: allFields,
+			owned:  true,
+			want:   driftTypeFailover,
+		},
+		{
+			name:    "overwritten after the operator write",
+			entries: []metav1.ManagedFieldsEntry{user},
+			fields:  allFields,
+			owned:   true,
+			want:    driftTypeManualEdit,
+		},
+		{
+			name: "egressIP overwritten before an operator annotation write",
+			entries: []metav1.ManagedFieldsEntry{
+				managedFieldsEntry("kubectl-edit", egressIPField, now.Add(-time.Minute)),
+				managedFieldsEntry(utils.FieldManager, hostnameField, now),
+			},
+			fields: [][]string{egressIPFields},
+			owned:  true,
+			want:   driftTypeManualEdit,
+		},
+		{
+			name: "gateway moved with the egressIP set by the user on creation",
+			entries: []metav1.ManagedFieldsEntry{
+				managedFieldsEntry("kubectl-create", egressIPField, now.Add(-time.Minute)),
+				managedFieldsEntry(utils.FieldManager, hostnameField, now),
+			},
+			fields: hostnameFields,
+			owned:  true,
+			want:   driftTypeFailover,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := classifyDrift(policyManagedBy(tt.entries...), tt.owned, tt.fields); got != tt.want {
+				t.Errorf("classifyDrift() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDriftFields(t *testing.T) {
+	p := newPolicy("policy", "", "10.0.0.1", "node-1")
+	allFields := append([][]string{egressIPFields}, hostnameFields...)
+
+	tests := []struct {
+		name           string
+		desiredIP      string
+		desiredGateway string
+		want           [][]string
+	}{
+		{name: "egressIP", desiredIP: "10.0.0.2", desiredGateway: "node-1", want: [][]string{egressIPFields}},
+		{name: "gateway", desiredIP: "10.0.0.1", desiredGateway: "node-2", want: hostnameFields},
+		{name: "both", desiredIP: "10.0.0.2", desiredGateway: "node-2", want: allFields},
+		{name: "none", desiredIP: "10.0.0.1", desiredGateway: "node-1", want: allFields},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := driftFields(p, tt.desiredIP, tt.desiredGateway)
+			if !slices.EqualFunc(got, tt.want, slices.Equal[[]string]) {
+				t.Errorf("driftFields() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	now := time.Now()
+	user := managedFieldsEntry("kubectl-edit", gatewayFields, now)
+
+	tests := []struct {
+		name          string
+		opts          Options
+		owned         bool
+		wantType      string
+		wantRemaining bool
+		wantDrifts    float64
+	}{
+		{
+			name: "first fill",
+			opts: Options{DriftMode: utils.DriftModeLenient},
+		},
+		{
+			name:          "lenient manual edit",
+			opts:          Options{DriftMode: utils.DriftModeLenient, ResyncInterval: time.Minute},
+			owned:         true,
+			wantType:      driftTypeManualEdit,
+			wantRemaining: true,
+			wantDrifts:    1,
+		},
+		{
+			name:       "strict manual edit",
+			opts:       Options{DriftMode: utils.DriftModeStrict},
+			owned:      true,
+			wantType:   driftTypeManualEdit,
+			wantDrifts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, tt.opts)
+			p := policyManagedBy(user)
+			h.setOwned(p.GetName(), tt.owned)
+			manualEdits := testutil.ToFloat64(metrics.PolicyDrifts.WithLabelValues(driftTypeManualEdit))
+			failovers := testutil.ToFloat64(metrics.PolicyDrifts.WithLabelValues(driftTypeFailover))
+
+			// The drift is only reported once until it is reverted.
+			for range 2 {
+				remaining, driftType := h.checkDrift(p, "10.0.0.2", "node-2")
+				if driftType != tt.wantType {
+					t.Errorf("checkDrift() type = %q, want %q", driftType, tt.wantType)
+				}
+				if (remaining > 0) != tt.wantRemaining {
+					t.Errorf("checkDrift() remaining = %v, want remaining %v", remaining, tt.wantRemaining)
+				}
+			}
+			if got := testutil.ToFloat64(metrics.PolicyDrifts.WithLabelValues(driftTypeManualEdit)) - manualEdits; got != tt.wantDrifts {
+				t.Errorf("manual-edit drifts = %v, want %v", got, tt.wantDrifts)
+			}
+			if got := testutil.ToFloat64(metrics.PolicyDrifts.WithLabelValues(driftTypeFailover)) - failovers; got != 0 {
+				t.Errorf("failover drifts = %v, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package cegp
+
+import (
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	eventReasonHostnameUnmatchable = "HostnameUnmatchable"
+)
+
+// setUnmatchable records the policy whose nodeSelector excludes the desired
+// gateway node, it is reported by a Warning Event when it is detected or the
+// reason changed.
+func (h *handler) setUnmatchable(p policy.Policy, err error) {
+	h.unmatchableMu.Lock()
+	defer h.unmatchableMu.Unlock()
+
+	// The gauge is shared by the handlers of all policy kinds.
+	last, ok := h.unmatchable[p.GetName()]
+	switch {
+	case !ok:
+		metrics.PolicyHostnameUnmatchable.Inc()
+	case last == err.Error():
+		return
+	}
+	h.unmatchable[p.GetName()] = err.Error()
+	metrics.PolicyHostnameUnmatchableDetected.Inc()
+	logrus.WithFields(h.fieldEgressPolicy(p)).
+		Warnf("Skip updating policy: %v", err)
+	h.recorder.Eventf(p.Object(), corev1.EventTypeWarning, eventReasonHostnameUnmatchable,
+		"Skip updating policy: %v", err)
+}
+
+func (h *handler) resetUnmatchable(name string) {
+	h.unmatchableMu.Lock()
+	defer h.unmatchableMu.Unlock()
+
+	if _, ok := h.unmatchable[name]; ok {
+		delete(h.unmatchable, name)
+		metrics.PolicyHostnameUnmatchable.Dec()
+	}
+}
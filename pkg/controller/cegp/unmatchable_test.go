@@ -0,0 +1,57 @@
+package cegp
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestSetUnmatchable(t *testing.T) {
+	h := newTestHandler(t, Options{})
+	recorder := h.recorder.(*record.FakeRecorder)
+	p := newPolicy("policy", "", "10.0.0.1", "node-1")
+	unmatchable := testutil.ToFloat64(metrics.PolicyHostnameUnmatchable)
+	detected := testutil.ToFloat64(metrics.PolicyHostnameUnmatchableDetected)
+
+	errNotIn := fmt.Errorf("%w: NotIn node-2", utils.ErrHostnameUnmatchable)
+	errIn := fmt.Errorf("%w: In node-3", utils.ErrHostnameUnmatchable)
+	steps := []struct {
+		name            string
+		err             error
+		wantUnmatchable float64
+		wantDetected    float64
+		wantEvent       bool
+	}{
+		{name: "detected", err: errNotIn, wantUnmatchable: 1, wantDetected: 1, wantEvent: true},
+		{name: "unchanged", err: errNotIn, wantUnmatchable: 1, wantDetected: 1},
+		{name: "changed", err: errIn, wantUnmatchable: 1, wantDetected: 2, wantEvent: true},
+		{name: "reset", wantDetected: 2},
+	}
+	for _, step := range steps {
+		if step.err != nil {
+			h.setUnmatchable(p, step.err)
+		} else {
+			h.resetUnmatchable(p.GetName())
+		}
+		if got := testutil.ToFloat64(metrics.PolicyHostnameUnmatchable) - unmatchable; got != step.wantUnmatchable {
+			t.Errorf("%v: unmatchable = %v, want %v", step.name, got, step.wantUnmatchable)
+		}
+		if got := testutil.ToFloat64(metrics.PolicyHostnameUnmatchableDetected) - detected; got != step.wantDetected {
+			t.Errorf("%v: detected = %v, want %v", step.name, got, step.wantDetected)
+		}
+		select {
+		case event := <-recorder.Events:
+			if !step.wantEvent {
+				t.Errorf("%v: unexpected event %q", step.name, event)
+			}
+		default:
+			if step.wantEvent {
+				t.Errorf("%v: expected a %v event", step.name, eventReasonHostnameUnmatchable)
+			}
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	egressv1alpha1 "github.com/cnrancher/cilium-egress-operator/pkg/apis/egress.cilium.pandaria.io/v1alpha1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/state"
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/wrangler"
+	"github.com/cnrancher/cilium-egress-operator/pkg/elector"
+	coordinationcontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/coordination.k8s.io/v1"
+	corecontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/core/v1"
+	egresscontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/egress.cilium.pandaria.io/v1alpha1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	handlerName = "cilium-egress-operator-group"
+)
+
+var errInvalidSpec = errors.New("invalid EgressGatewayGroup spec")
+
+type handler struct {
+	groupCache  egresscontroller.EgressGatewayGroupCache
+	groupClient egresscontroller.EgressGatewayGroupClient
+	nodeCache   corecontroller.NodeCache
+	leaseCache  coordinationcontroller.LeaseCache
+
+	groupEnqueue      func(string)
+	groupEnqueueAfter func(string, time.Duration)
+
+	state *state.Store
+	// nodes records the last observed nodes to resolve the hostname of the
+	// deleted nodes.
+	nodes *elector.NodeTracker
+	// namespace is the namespace of the leases watched by the operator.
+	namespace string
+}
+
+// options is the current group handler options, replaced on the config
+// reload.
+var options atomic.Pointer[Options]
+
+type Options struct {
+	// SetPolicyEgressIPToNodeIP and SetPolicyNodeSelector are the operator
+	// policy options, used for checking the policy sync state.
+	SetPolicyEgressIPToNodeIP bool
+	SetPolicyNodeSelector     bool
+}
+
+func Register(
+	ctx context.Context,
+	wctx *wrangler.Context,
+	opts Options,
+) {
+	logrus.Debugf("EgressGatewayGroup Handler Options: %v", utils.DebugPrint(opts))
+	options.Store(&opts)
+	h := newHandler(wctx)
+	wctx.Egress.EgressGatewayGroup().OnChange(ctx, handlerName, h.handleError(h.sync))
+	wctx.Coordination.Lease().OnChange(ctx, leaseHandlerName, h.syncLease)
+	wctx.Core.Node().OnChange(ctx, nodeHandlerName, h.syncNode)
+	for _, kind := range policy.Kinds() {
+		kind.OnChange(ctx, policyHandlerName+"-"+strings.ToLower(kind.Kind()), h.syncPolicy)
+	}
+}
+
+func newHandler(wctx *wrangler.Context) *handler {
+	return &handler{
+		groupCache:  wctx.Egress.EgressGatewayGroup().Cache(),
+		groupClient: wctx.Egress.EgressGatewayGroup(),
+		nodeCache:   wctx.Core.Node().Cache(),
+		leaseCache:  wctx.Coordination.Lease().Cache(),
+
+		groupEnqueue:      wctx.Egress.EgressGatewayGroup().Enqueue,
+		groupEnqueueAfter: wctx.Egress.EgressGatewayGroup().EnqueueAfter,
+
+		state:     state.NewStore(wctx),
+		nodes:     elector.NewNodeTracker(),
+		namespace: wctx.LeaseNamespace,
+	}
+}
+
+// Reload replaces the group handler options and re-enqueues the groups to
+// update the policy sync status.
+func Reload(wctx *wrangler.Context, opts Options) error {
+	logrus.Debugf("Reload EgressGatewayGroup Handler Options: %v", utils.DebugPrint(opts))
+	options.Store(&opts)
+	groups, err := wctx.Egress.EgressGatewayGroup().Cache().List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list EgressGatewayGroup from cache: %w", err)
+	}
+	for _, group := range groups {
+		wctx.Egress.EgressGatewayGroup().Enqueue(group.Name)
+	}
+	return nil
+}
+
+// options returns the current group handler options.
+func (h *handler) options() *Options {
+	if opts := options.Load(); opts != nil {
+		return opts
+	}
+	return &Options{}
+}
+
+func (h *handler) handleError(
+	sync func(string, *egressv1alpha1.EgressGatewayGroup) (*egressv1alpha1.EgressGatewayGroup, error),
+) func(string, *egressv1alpha1.EgressGatewayGroup) (*egressv1alpha1.EgressGatewayGroup, error) {
+	return func(s string, group *egressv1alpha1.EgressGatewayGroup) (*egressv1alpha1.EgressGatewayGroup, error) {
+		groupSynced, err := sync(s, group)
+		if err != nil {
+			if errors.Is(err, errInvalidSpec) {
+				logrus.WithFields(fieldsGroup(group)).Warn(err)
+				return group, nil
+			}
+			logrus.WithFields(fieldsGroup(group)).Error(err)
+			return group, err
+		}
+		return groupSynced, nil
+	}
+}
+
+func (h *handler) sync(key string, group *egressv1alpha1.EgressGatewayGroup) (*egressv1alpha1.EgressGatewayGroup, error) {
+	// The groups assigned to other operator instances are treated as removed.
+	if group == nil || group.DeletionTimestamp != nil || !utils.ManagedByInstance(group.Annotations) {
+		if _, ok := gateway.Lookup(key); ok {
+			logrus.WithFields(logrus.Fields{utils.FieldGroup: key}).Infof("Gateway group removed")
+			gateway.Delete(key)
+			h.saveState(key)
+		}
+		return group, nil
+	}
+
+	el, err := h.elect(group, false)
+	if err != nil {
+		if errors.Is(err, errInvalidSpec) {
+			return h.updateInvalidStatus(group, err)
+		}
+		return group, err
+	}
+	if el.result.Requeue > 0 {
+		h.groupEnqueueAfter(group.Name, el.result.Requeue)
+	}
+	if el.result.Changed {
+		h.saveState(group.Name)
+	}
+	if el.result.Changed || el.ipModeChanged {
+		if err := policy.EnqueueGroup(group.Name); err != nil {
+			return group, err
+		}
+	}
+	return h.updateStatus(group, el.elector, el.group)
+}
+
+// election is the gateway election result of a group.
+type election struct {
+	group         *gateway.Group
+	elector       *elector.Elector
+	result        elector.Result
+	ipModeChanged bool
+}
+
+// elect updates the gateway node of the group in the gateway store, the
+// state is not persisted and the policies are not enqueued. The metrics are
+// not updated if evaluate is true.
+func (h *handler) elect(group *egressv1alpha1.EgressGatewayGroup, evaluate bool) (*election, error) {
+	opts, err := electorOptions(group)
+	if err != nil {
+		return nil, err
+	}
+	g := gateway.For(group.Name)
+	ipModeChanged := g.SetEgressIPToNodeIP(egressIPToNodeIP(group.Spec.IPMode))
+
+	e := elector.New(h.nodeCache, g, opts)
+	holder, reason, err := h.sourceHolder(group, e)
+	if err != nil {
+		return nil, err
+	}
+	elect := e.Elect
+	if evaluate {
+		elect = e.Evaluate
+	}
+	result, err := elect(holder, reason, fieldsGroup(group))
+	if err != nil {
+		return nil, err
+	}
+	return &election{
+		group:         g,
+		elector:       e,
+		result:        result,
+		ipModeChanged: ipModeChanged,
+	}, nil
+}
+
+// Evaluate computes the gateway nodes of the groups in the gateway store
+// without persisting the state, enqueueing the policies or updating the group
+// status, used by the standby operator pods to keep the gateway state warm.
+func Evaluate(wctx *wrangler.Context) error {
+	h := newHandler(wctx)
+	groups, err := h.groupCache.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list EgressGatewayGroup from cache: %w", err)
+	}
+	existing := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		if group.DeletionTimestamp != nil || !utils.ManagedByInstance(group.Annotations) {
+			continue
+		}
+		existing[group.Name] = true
+		if _, err := h.elect(group, true); err != nil {
+			logrus.WithFields(fieldsGroup(group)).Debugf("Skip evaluating group: %v", err)
+		}
+	}
+	for name := range gateway.Snapshots() {
+		if name != gateway.DefaultGroup && !existing[name] {
+			gateway.Delete(name)
+		}
+	}
+	return nil
+}
+
+// sourceHolder returns the node desired by the gateway source of the group.
+func (h *handler) sourceHolder(
+	group *egressv1alpha1.EgressGatewayGroup, e *elector.Elector,
+) (string, string, error) {
+	source := group.Spec.Source
+	switch source.Type {
+	case egressv1alpha1.SourceTypeLease:
+		if source.Lease == nil || source.Lease.Name == "" {
+			return "", "", fmt.Errorf("%w: spec.source.lease.name is required by the Lease source", errInvalidSpec)
+		}
+		namespace := source.Lease.Namespace
+		if namespace == "" {
+			namespace = h.namespace
+		}
+		if namespace != h.namespace {
+			return "", "", fmt.Errorf("%w: lease namespace %q is not supported, only leases in %q are watched",
+				errInvalidSpec, namespace, h.namespace)
+		}
+		lease, err := h.leaseCache.Get(namespace, source.Lease.Name)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				logrus.WithFields(fieldsGroup(group)).
+					Debugf("Waiting for lease [%v/%v] to be created", namespace, source.Lease.Name)
+				return "", "", nil
+			}
+			return "", "", fmt.Errorf("failed to get lease from cache: %w", err)
+		}
+		return utils.Value(lease.Spec.HolderIdentity), "lease holder node", nil
+	case egressv1alpha1.SourceTypeStatic:
+		if source.Static == nil || len(source.Static.Nodes) == 0 {
+			return "", "", fmt.Errorf("%w: spec.source.static.nodes is required by the Static source", errInvalidSpec)
+		}
+		for _, name := range source.Static.Nodes {
+			node, err := h.nodeCache.Get(name)
+			if err != nil {
+				logrus.WithFields(fieldsGroup(group)).Debugf("Skip static node [%v]: %v", name, err)
+				continue
+			}
+			if elector.HealthyNode(node) {
+				return name, "static gateway node", nil
+			}
+		}
+		return "", "", nil
+	case egressv1alpha1.SourceTypeElection:
+		name, err := e.FallbackNode("")
+		if err != nil {
+			return "", "", err
+		}
+		return name, "elected gateway node", nil
+	}
+	return "", "", fmt.Errorf("%w: unknown source type %q", errInvalidSpec, source.Type)
+}
+
+// egressIPToNodeIP returns the egressIP override of the IP mode, nil
+// follows the operator option if the IP mode is not set.
+func egressIPToNodeIP(mode egressv1alpha1.IPMode) *bool {
+	var enabled bool
+	switch mode {
+	case egressv1alpha1.IPModeNodeIP:
+		enabled = true
+	case egressv1alpha1.IPModeManual:
+		enabled = false
+	default:
+		return nil
+	}
+	return &enabled
+}
+
+// electorOptions converts the group spec into the gateway elector options.
+func electorOptions(group *egressv1alpha1.EgressGatewayGroup) (elector.Options, error) {
+	var opts elector.Options
+	spec := group.Spec
+	if spec.GatewayCount < 0 {
+		return opts, fmt.Errorf("%w: spec.gatewayCount must not be negative", errInvalidSpec)
+	}
+	opts.GatewayCount = spec.GatewayCount
+	if spec.CandidateNodeSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.CandidateNodeSelector)
+		if err != nil {
+			return opts, fmt.Errorf("%w: spec.candidateNodeSelector: %w", errInvalidSpec, err)
+		}
+		opts.CandidateSelector = selector
+	}
+	if d := spec.Damping; d != nil {
+		if d.MinDwell != nil {
+			opts.MinDwell = d.MinDwell.Duration
+		}
+		if d.StabilizationDelay != nil {
+			opts.StabilizationDelay = d.StabilizationDelay.Duration
+		}
+	}
+	if f := spec.Failback; f != nil {
+		opts.Failback = elector.FailbackOptions{
+			Mode:           strings.ToLower(string(f.Mode)),
+			PreferredNodes: f.PreferredNodes,
+		}
+		if f.Delay != nil {
+			opts.Failback.Delay = f.Delay.Duration
+		}
+		if opts.Failback.Mode != "" && !elector.ValidFailbackMode(opts.Failback.Mode) {
+			return opts, fmt.Errorf("%w: unknown failback mode %q", errInvalidSpec, f.Mode)
+		}
+	}
+	return opts, nil
+}
+
+func (h *handler) saveState(name string) {
+	if err := h.state.Save(); err != nil {
+		logrus.WithFields(logrus.Fields{utils.FieldGroup: name}).
+			Warnf("Failed to persist gateway state: %v", err)
+	}
+}
+
+func fieldsGroup(group *egressv1alpha1.EgressGatewayGroup) logrus.Fields {
+	if group == nil {
+		return logrus.Fields{}
+	}
+	return logrus.Fields{
+		utils.FieldGroup: group.Name,
+	}
+}
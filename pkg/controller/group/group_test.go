@@ -0,0 +1,211 @@
+package group
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	egressv1alpha1 "github.com/cnrancher/cilium-egress-operator/pkg/apis/egress.cilium.pandaria.io/v1alpha1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/state"
+	"github.com/cnrancher/cilium-egress-operator/pkg/elector"
+	egresscontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/egress.cilium.pandaria.io/v1alpha1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/fake"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetLevel(logrus.PanicLevel)
+	os.Exit(m.Run())
+}
+
+// fakeGroupClient records the group status updates, the methods not used by
+// the handler panic.
+type fakeGroupClient struct {
+	egresscontroller.EgressGatewayGroupClient
+
+	updated []*egressv1alpha1.EgressGatewayGroup
+}
+
+func (c *fakeGroupClient) UpdateStatus(group *egressv1alpha1.EgressGatewayGroup) (*egressv1alpha1.EgressGatewayGroup, error) {
+	c.updated = append(c.updated, group.DeepCopy())
+	return group, nil
+}
+
+// testHandler is the group handler of the test with the enqueued groups.
+type testHandler struct {
+	*handler
+
+	client   *fakeGroupClient
+	enqueued []string
+}
+
+func newTestHandler(t *testing.T, opts Options, groups []*egressv1alpha1.EgressGatewayGroup, nodes ...*corev1.Node) *testHandler {
+	t.Helper()
+	options.Store(&opts)
+	t.Cleanup(func() { options.Store(nil) })
+
+	th := &testHandler{client: &fakeGroupClient{}}
+	th.handler = &handler{
+		groupCache:  fake.NewNonNamespacedCache(egressv1alpha1.Resource("egressgatewaygroups"), groups...),
+		groupClient: th.client,
+		nodeCache:   fake.NewNodeCache(nodes...),
+		leaseCache:  fake.NewCache[*coordinationv1.Lease](coordinationv1.Resource("leases")),
+
+		groupEnqueue: func(name string) {
+			th.enqueued = append(th.enqueued, name)
+		},
+		groupEnqueueAfter: func(string, time.Duration) {},
+
+		state:     state.New(&fake.ConfigMapClient{}, "kube-system", "gateway-state"),
+		nodes:     elector.NewNodeTracker(),
+		namespace: "kube-system",
+	}
+	return th
+}
+
+func readyNode(name, ip string, labels map[string]string) *corev1.Node {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{utils.HostnameLabelKey: name},
+			Annotations: map[string]string{utils.ProvidedNodeIPAnnotationKey: ip},
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{
+				Type:               corev1.NodeReady,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Hour)),
+			}},
+		},
+	}
+	for k, v := range labels {
+		node.Labels[k] = v
+	}
+	return node
+}
+
+// staticGroup returns the group of the static gateway nodes, the gateway
+// group state is removed after the test.
+func staticGroup(t *testing.T, name string, nodes ...string) *egressv1alpha1.EgressGatewayGroup {
+	t.Helper()
+	t.Cleanup(func() { gateway.Delete(name) })
+	return &egressv1alpha1.EgressGatewayGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: egressv1alpha1.EgressGatewayGroupSpec{
+			Source: egressv1alpha1.GatewaySource{
+				Type:   egressv1alpha1.SourceTypeStatic,
+				Static: &egressv1alpha1.StaticSource{Nodes: nodes},
+			},
+		},
+	}
+}
+
+func TestElectIPMode(t *testing.T) {
+	tests := []struct {
+		name string
+		mode egressv1alpha1.IPMode
+		// option is the operator SetPolicyEgressIPToNodeIP option.
+		option bool
+		want   bool
+	}{
+		{name: "inherit enabled", option: true, want: true},
+		{name: "inherit disabled", option: false, want: false},
+		{name: "node IP overrides", mode: egressv1alpha1.IPModeNodeIP, option: false, want: true},
+		{name: "manual overrides", mode: egressv1alpha1.IPModeManual, option: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := staticGroup(t, "group-ip-mode", "node-1")
+			group.Spec.IPMode = tt.mode
+			h := newTestHandler(t, Options{SetPolicyEgressIPToNodeIP: tt.option}, nil,
+				readyNode("node-1", "10.0.0.1", nil))
+
+			el, err := h.elect(group, false)
+			if err != nil {
+				t.Fatalf("elect() error = %v", err)
+			}
+			if got := el.group.EgressIPToNodeIP(h.options().SetPolicyEgressIPToNodeIP); got != tt.want {
+				t.Errorf("EgressIPToNodeIP() = %v, want %v", got, tt.want)
+			}
+			if el.ipModeChanged != (tt.mode != "") {
+				t.Errorf("elect() ipModeChanged = %v, want %v", el.ipModeChanged, tt.mode != "")
+			}
+			if el.group.LeaderNode() != "node-1" {
+				t.Errorf("leader node = %q, want node-1", el.group.LeaderNode())
+			}
+
+			// The IP mode is reported changed once until it is changed again.
+			el, err = h.elect(group, false)
+			if err != nil {
+				t.Fatalf("elect() error = %v", err)
+			}
+			if el.ipModeChanged {
+				t.Error("elect() of the same IP mode reported ipModeChanged")
+			}
+			group.Spec.IPMode = ""
+			el, err = h.elect(group, false)
+			if err != nil {
+				t.Fatalf("elect() error = %v", err)
+			}
+			if el.ipModeChanged != (tt.mode != "") {
+				t.Errorf("elect() of the removed IP mode ipModeChanged = %v, want %v", el.ipModeChanged, tt.mode != "")
+			}
+			if got := el.group.EgressIPToNodeIP(tt.option); got != tt.option {
+				t.Errorf("EgressIPToNodeIP() after removing the IP mode = %v, want %v", got, tt.option)
+			}
+		})
+	}
+}
+
+func TestSyncInstance(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance string
+		// groupInstance is the instance annotation of the group.
+		groupInstance string
+		want          bool
+	}{
+		{name: "default instance", want: true},
+		{name: "default instance ignores other instances", groupInstance: "a"},
+		{name: "instance", instance: "a", groupInstance: "a", want: true},
+		{name: "instance ignores other instances", instance: "a", groupInstance: "b"},
+		{name: "instance ignores unannotated groups", instance: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			utils.SetInstanceID(tt.instance)
+			t.Cleanup(func() { utils.SetInstanceID("") })
+			group := staticGroup(t, "group-instance", "node-1")
+			if tt.groupInstance != "" {
+				group.Annotations = map[string]string{utils.InstanceAnnotation: tt.groupInstance}
+			}
+			h := newTestHandler(t, Options{}, []*egressv1alpha1.EgressGatewayGroup{group},
+				readyNode("node-1", "10.0.0.1", nil))
+			// The group state of another instance is removed if it was
+			// managed before the group is reassigned.
+			gateway.For(group.Name)
+
+			if _, err := h.sync(group.Name, group); err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+			g, ok := gateway.Lookup(group.Name)
+			if ok != tt.want {
+				t.Fatalf("gateway group exists = %v, want %v", ok, tt.want)
+			}
+			if ok && g.LeaderNode() != "node-1" {
+				t.Errorf("leader node = %q, want node-1", g.LeaderNode())
+			}
+
+			h.enqueued = nil
+			h.enqueueGroups(func(*egressv1alpha1.EgressGatewayGroup) bool { return true })
+			if got := len(h.enqueued) > 0; got != tt.want {
+				t.Errorf("enqueueGroups() enqueued = %v, want enqueued %v", h.enqueued, tt.want)
+			}
+		})
+	}
+}
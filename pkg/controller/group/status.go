@@ -0,0 +1,224 @@
+package group
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	egressv1alpha1 "github.com/cnrancher/cilium-egress-operator/pkg/apis/egress.cilium.pandaria.io/v1alpha1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/elector"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/rancher/wrangler/v3/pkg/condition"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// The group is flapping if the gateway node changes flappingThreshold
+	// times in flappingWindow.
+	flappingWindow    = time.Minute * 10
+	flappingThreshold = 3
+
+	reasonInvalidSpec       = "InvalidSpec"
+	reasonGatewayUnknown    = "GatewayUnknown"
+	reasonGatewayUnhealthy  = "GatewayUnhealthy"
+	reasonNoRedundancy      = "NoRedundancy"
+	reasonPoliciesOutOfSync = "PoliciesOutOfSync"
+	reasonFrequentFailover  = "FrequentFailover"
+)
+
+// updateStatus reports the current gateway node, the candidate node health,
+// the bound policy sync state and the conditions into the group status.
+func (h *handler) updateStatus(
+	group *egressv1alpha1.EgressGatewayGroup, e *elector.Elector, g *gateway.Group,
+) (*egressv1alpha1.EgressGatewayGroup, error) {
+	nodes, err := e.CandidateNodes()
+	if err != nil {
+		return group, err
+	}
+	slices.SortFunc(nodes, func(a, b *corev1.Node) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	policies, err := h.policyStatuses(group.Name, g)
+	if err != nil {
+		return group, err
+	}
+
+	desired := group.DeepCopy()
+	st := g.Snapshot()
+	status := &desired.Status
+	status.ObservedGeneration = group.Generation
+	status.CurrentGateway = st.LeaderNode
+	status.CurrentGatewayIP = st.LeaderNodeIP
+	status.GatewayNodes = st.GatewayNodes
+	status.LastTransitionTime = nil
+	if !st.LastTransitionTime.IsZero() {
+		// The status time is serialized in seconds.
+		t := metav1.NewTime(st.LastTransitionTime.Truncate(time.Second))
+		status.LastTransitionTime = &t
+	}
+	status.Members = nil
+	healthy := 0
+	leaderHealthy := false
+	for _, n := range nodes {
+		if !e.CandidateNode(n) {
+			continue
+		}
+		ready, _ := elector.NodeReady(n)
+		status.Members = append(status.Members, egressv1alpha1.MemberStatus{
+			Node:        n.Name,
+			IP:          utils.NodeIP(n),
+			Ready:       ready,
+			Maintenance: elector.NodeInMaintenance(n),
+		})
+		if elector.HealthyNode(n) {
+			healthy++
+			if utils.NodeHostname(n) == st.LeaderNode {
+				leaderHealthy = true
+			}
+		}
+	}
+	status.Policies = policies
+	outOfSync := 0
+	for _, p := range policies {
+		if p.State == egressv1alpha1.PolicySyncStateOutOfSync {
+			outOfSync++
+		}
+	}
+
+	leaderKnown := st.LeaderNode != ""
+	if leaderKnown {
+		setCondition(desired, egressv1alpha1.GroupConditionNoLeader, false, "", "")
+	} else {
+		setCondition(desired, egressv1alpha1.GroupConditionNoLeader, true, reasonGatewayUnknown,
+			"No gateway node is selected")
+	}
+
+	transitions := g.Transitions(time.Now().Add(-flappingWindow))
+	if transitions >= flappingThreshold {
+		setCondition(desired, egressv1alpha1.GroupConditionFlapping, true, reasonFrequentFailover,
+			fmt.Sprintf("Gateway node changed %v times in %v", transitions, flappingWindow))
+		// Re-evaluate after the transitions are out of the window.
+		h.groupEnqueueAfter(group.Name, flappingWindow)
+	} else {
+		setCondition(desired, egressv1alpha1.GroupConditionFlapping, false, "", "")
+	}
+
+	switch {
+	case !leaderKnown:
+		setCondition(desired, egressv1alpha1.GroupConditionDegraded, false, "", "")
+	case !leaderHealthy:
+		setCondition(desired, egressv1alpha1.GroupConditionDegraded, true, reasonGatewayUnhealthy,
+			fmt.Sprintf("Gateway node [%v] is not healthy", st.LeaderNode))
+	case outOfSync > 0:
+		setCondition(desired, egressv1alpha1.GroupConditionDegraded, true, reasonPoliciesOutOfSync,
+			fmt.Sprintf("%v of %v policies are out of sync", outOfSync, len(policies)))
+	case healthy < 2:
+		setCondition(desired, egressv1alpha1.GroupConditionDegraded, true, reasonNoRedundancy,
+			"No other healthy candidate node available for failover")
+	case group.Spec.GatewayCount > 1 && len(st.GatewayNodes) < group.Spec.GatewayCount:
+		setCondition(desired, egressv1alpha1.GroupConditionDegraded, true, reasonNoRedundancy,
+			fmt.Sprintf("Only %v of %v gateway nodes are healthy", len(st.GatewayNodes), group.Spec.GatewayCount))
+	default:
+		setCondition(desired, egressv1alpha1.GroupConditionDegraded, false, "", "")
+	}
+
+	switch {
+	case !leaderKnown:
+		setCondition(desired, egressv1alpha1.GroupConditionReady, false, reasonGatewayUnknown,
+			"No gateway node is selected")
+	case !leaderHealthy:
+		setCondition(desired, egressv1alpha1.GroupConditionReady, false, reasonGatewayUnhealthy,
+			fmt.Sprintf("Gateway node [%v] is not healthy", st.LeaderNode))
+	case outOfSync > 0:
+		setCondition(desired, egressv1alpha1.GroupConditionReady, false, reasonPoliciesOutOfSync,
+			fmt.Sprintf("%v of %v policies are out of sync", outOfSync, len(policies)))
+	default:
+		setCondition(desired, egressv1alpha1.GroupConditionReady, true, "", "")
+	}
+
+	return h.writeStatus(group, desired)
+}
+
+// updateInvalidStatus reports the invalid spec error in the Ready condition,
+// the error is returned as-is.
+func (h *handler) updateInvalidStatus(
+	group *egressv1alpha1.EgressGatewayGroup, specErr error,
+) (*egressv1alpha1.EgressGatewayGroup, error) {
+	desired := group.DeepCopy()
+	desired.Status.ObservedGeneration = group.Generation
+	setCondition(desired, egressv1alpha1.GroupConditionReady, false, reasonInvalidSpec, specErr.Error())
+	group, err := h.writeStatus(group, desired)
+	if err != nil {
+		return group, err
+	}
+	return group, specErr
+}
+
+func (h *handler) writeStatus(
+	group, desired *egressv1alpha1.EgressGatewayGroup,
+) (*egressv1alpha1.EgressGatewayGroup, error) {
+	if equality.Semantic.DeepEqual(group.Status, desired.Status) {
+		return group, nil
+	}
+	updated, err := h.groupClient.UpdateStatus(desired)
+	if err != nil {
+		return group, fmt.Errorf("failed to update EgressGatewayGroup status: %w", err)
+	}
+	return updated, nil
+}
+
+// policyStatuses returns the sync state of the monitored policies of all
+// kinds bound to the group sorted by kind and name.
+func (h *handler) policyStatuses(name string, g *gateway.Group) ([]egressv1alpha1.PolicyStatus, error) {
+	var statuses []egressv1alpha1.PolicyStatus
+	for _, kind := range policy.Kinds() {
+		policies, err := policy.ListGroup(kind, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range policies {
+			statuses = append(statuses, egressv1alpha1.PolicyStatus{
+				Kind:     kind.Kind(),
+				Name:     p.GetName(),
+				Gateway:  policy.Gateway(p),
+				EgressIP: p.EgressIP(),
+				State:    h.policySyncState(p, g),
+			})
+		}
+	}
+	slices.SortFunc(statuses, func(a, b egressv1alpha1.PolicyStatus) int {
+		if c := strings.Compare(a.Kind, b.Kind); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return statuses, nil
+}
+
+func (h *handler) policySyncState(p policy.Policy, g *gateway.Group) egressv1alpha1.PolicySyncState {
+	if p.GetAnnotations()[utils.PendingGatewayAnnotation] != "" {
+		return egressv1alpha1.PolicySyncStatePending
+	}
+	leader := g.LeaderNode()
+	if leader == "" || !p.HasGateway() {
+		return egressv1alpha1.PolicySyncStateOutOfSync
+	}
+	if h.options().SetPolicyNodeSelector && !policy.HostnameSynced(p, g) {
+		return egressv1alpha1.PolicySyncStateOutOfSync
+	}
+	if g.EgressIPToNodeIP(h.options().SetPolicyEgressIPToNodeIP) && p.EgressIP() != g.LeaderNodeIP() {
+		return egressv1alpha1.PolicySyncStateOutOfSync
+	}
+	return egressv1alpha1.PolicySyncStateSynced
+}
+
+func setCondition(group *egressv1alpha1.EgressGatewayGroup, cond condition.Cond, value bool, reason, message string) {
+	cond.SetStatusBool(group, value)
+	cond.Reason(group, reason)
+	cond.Message(group, message)
+}
@@ -0,0 +1,259 @@
+package group
+
+import (
+	"testing"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	egressv1alpha1 "github.com/cnrancher/cilium-egress-operator/pkg/apis/egress.cilium.pandaria.io/v1alpha1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/elector"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/rancher/wrangler/v3/pkg/condition"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestUpdateStatusConditions(t *testing.T) {
+	gatewayLabel := map[string]string{"gateway": "true"}
+	notReady := func(node *corev1.Node) *corev1.Node {
+		node.Status.Conditions[0].Status = corev1.ConditionFalse
+		return node
+	}
+
+	type conditions struct {
+		ready, degraded, noLeader, flapping bool
+		// reason is the reason of the Ready condition if not ready, or the
+		// Degraded condition if degraded.
+		reason string
+	}
+	tests := []struct {
+		name         string
+		nodes        []*corev1.Node
+		gatewayCount int
+		// leaders are the gateway nodes the group moved to in order.
+		leaders      []string
+		gatewayNodes []string
+		want         conditions
+	}{
+		{
+			name: "no leader",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", gatewayLabel),
+			},
+			want: conditions{noLeader: true, reason: reasonGatewayUnknown},
+		},
+		{
+			name: "ready",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", gatewayLabel),
+				readyNode("node-2", "10.0.0.2", gatewayLabel),
+			},
+			leaders: []string{"node-1"},
+			want:    conditions{ready: true},
+		},
+		{
+			name: "no redundancy",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", gatewayLabel),
+				notReady(readyNode("node-2", "10.0.0.2", gatewayLabel)),
+				// Not a candidate node of the group.
+				readyNode("node-3", "10.0.0.3", nil),
+			},
+			leaders: []string{"node-1"},
+			want:    conditions{ready: true, degraded: true, reason: reasonNoRedundancy},
+		},
+		{
+			name: "gateway unhealthy",
+			nodes: []*corev1.Node{
+				notReady(readyNode("node-1", "10.0.0.1", gatewayLabel)),
+				readyNode("node-2", "10.0.0.2", gatewayLabel),
+			},
+			leaders: []string{"node-1"},
+			want:    conditions{degraded: true, reason: reasonGatewayUnhealthy},
+		},
+		{
+			name: "missing gateway nodes",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", gatewayLabel),
+				readyNode("node-2", "10.0.0.2", gatewayLabel),
+			},
+			gatewayCount: 3,
+			leaders:      []string{"node-1"},
+			gatewayNodes: []string{"node-1", "node-2"},
+			want:         conditions{ready: true, degraded: true, reason: reasonNoRedundancy},
+		},
+		{
+			name: "flapping",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", gatewayLabel),
+				readyNode("node-2", "10.0.0.2", gatewayLabel),
+			},
+			leaders: []string{"node-1", "node-2", "node-1"},
+			want:    conditions{ready: true, flapping: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := staticGroup(t, "group-status", "node-1")
+			group.Spec.GatewayCount = tt.gatewayCount
+			h := newTestHandler(t, Options{}, nil, tt.nodes...)
+			g := gateway.For(group.Name)
+			ips := map[string]string{"node-1": "10.0.0.1", "node-2": "10.0.0.2"}
+			for _, leader := range tt.leaders {
+				g.SetLeaderNode(ips[leader], leader, false)
+			}
+			g.SetGatewayNodes(tt.gatewayNodes)
+			e := elector.New(h.nodeCache, g, elector.Options{
+				GatewayCount:      tt.gatewayCount,
+				CandidateSelector: labels.SelectorFromSet(gatewayLabel),
+			})
+
+			if _, err := h.updateStatus(group, e, g); err != nil {
+				t.Fatalf("updateStatus() error = %v", err)
+			}
+			if len(h.client.updated) != 1 {
+				t.Fatalf("status updated %v times, want 1", len(h.client.updated))
+			}
+			got := h.client.updated[0]
+			for name, c := range map[string]struct {
+				cond condition.Cond
+				want bool
+			}{
+				"Ready":    {egressv1alpha1.GroupConditionReady, tt.want.ready},
+				"Degraded": {egressv1alpha1.GroupConditionDegraded, tt.want.degraded},
+				"NoLeader": {egressv1alpha1.GroupConditionNoLeader, tt.want.noLeader},
+				"Flapping": {egressv1alpha1.GroupConditionFlapping, tt.want.flapping},
+			} {
+				if c.cond.IsTrue(got) != c.want {
+					t.Errorf("condition %v = %v, want %v", name, c.cond.IsTrue(got), c.want)
+				}
+			}
+			var reason string
+			switch {
+			case !tt.want.ready:
+				reason = egressv1alpha1.GroupConditionReady.GetReason(got)
+			case tt.want.degraded:
+				reason = egressv1alpha1.GroupConditionDegraded.GetReason(got)
+			}
+			if reason != tt.want.reason {
+				t.Errorf("condition reason = %q, want %q", reason, tt.want.reason)
+			}
+			if got.Status.CurrentGateway != g.LeaderNode() {
+				t.Errorf("status currentGateway = %q, want %q", got.Status.CurrentGateway, g.LeaderNode())
+			}
+
+			// The unchanged status is not written again.
+			if _, err := h.updateStatus(got, e, g); err != nil {
+				t.Fatalf("updateStatus() error = %v", err)
+			}
+			if len(h.client.updated) != 1 {
+				t.Errorf("unchanged status updated %v times, want 1", len(h.client.updated))
+			}
+		})
+	}
+}
+
+func TestPolicySyncState(t *testing.T) {
+	newPolicy := func(ip string, annotations map[string]string, hostnames ...string) policy.Policy {
+		selector := &slimv1.LabelSelector{}
+		if len(hostnames) == 1 {
+			selector.MatchLabels = map[string]slimv1.MatchLabelsValue{utils.HostnameLabelKey: hostnames[0]}
+		}
+		p := policy.NewCiliumPolicy(&ciliumv2.CiliumEgressGatewayPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy-1", Annotations: annotations},
+			Spec: ciliumv2.CiliumEgressGatewayPolicySpec{
+				EgressGateway: &ciliumv2.EgressGateway{EgressIP: ip, NodeSelector: selector},
+			},
+		})
+		if len(hostnames) > 1 {
+			p.SetHostnames(hostnames)
+		}
+		return p
+	}
+	manage := Options{SetPolicyEgressIPToNodeIP: true, SetPolicyNodeSelector: true}
+
+	tests := []struct {
+		name         string
+		opts         Options
+		policy       policy.Policy
+		noLeader     bool
+		gatewayNodes []string
+		want         egressv1alpha1.PolicySyncState
+	}{
+		{
+			name:   "synced",
+			opts:   manage,
+			policy: newPolicy("10.0.0.1", nil, "node-1"),
+			want:   egressv1alpha1.PolicySyncStateSynced,
+		},
+		{
+			name:   "pending move",
+			opts:   manage,
+			policy: newPolicy("10.0.0.2", map[string]string{utils.PendingGatewayAnnotation: "node-1"}, "node-2"),
+			want:   egressv1alpha1.PolicySyncStatePending,
+		},
+		{
+			name:     "leader unknown",
+			opts:     manage,
+			policy:   newPolicy("10.0.0.1", nil, "node-1"),
+			noLeader: true,
+			want:     egressv1alpha1.PolicySyncStateOutOfSync,
+		},
+		{
+			name:   "hostname out of sync",
+			opts:   manage,
+			policy: newPolicy("10.0.0.1", nil, "node-2"),
+			want:   egressv1alpha1.PolicySyncStateOutOfSync,
+		},
+		{
+			name:   "hostname not managed",
+			opts:   Options{SetPolicyEgressIPToNodeIP: true},
+			policy: newPolicy("10.0.0.1", nil, "node-2"),
+			want:   egressv1alpha1.PolicySyncStateSynced,
+		},
+		{
+			name:   "egressIP out of sync",
+			opts:   manage,
+			policy: newPolicy("10.0.0.2", nil, "node-1"),
+			want:   egressv1alpha1.PolicySyncStateOutOfSync,
+		},
+		{
+			name:   "egressIP not managed",
+			opts:   Options{SetPolicyNodeSelector: true},
+			policy: newPolicy("192.168.0.10", nil, "node-1"),
+			want:   egressv1alpha1.PolicySyncStateSynced,
+		},
+		{
+			name:         "gateway nodes synced",
+			opts:         manage,
+			policy:       newPolicy("10.0.0.1", nil, "node-1", "node-2"),
+			gatewayNodes: []string{"node-1", "node-2"},
+			want:         egressv1alpha1.PolicySyncStateSynced,
+		},
+		{
+			name:         "gateway nodes out of sync",
+			opts:         manage,
+			policy:       newPolicy("10.0.0.1", nil, "node-1"),
+			gatewayNodes: []string{"node-1", "node-2"},
+			want:         egressv1alpha1.PolicySyncStateOutOfSync,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, tt.opts, nil)
+			g := gateway.For(t.Name())
+			t.Cleanup(func() { gateway.Delete(t.Name()) })
+			if !tt.noLeader {
+				g.SetLeaderNode("10.0.0.1", "node-1", false)
+			}
+			g.SetGatewayNodes(tt.gatewayNodes)
+
+			if got := h.policySyncState(tt.policy, g); got != tt.want {
+				t.Errorf("policySyncState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
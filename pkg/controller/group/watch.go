@@ -0,0 +1,105 @@
+package group
+
+import (
+	"slices"
+
+	egressv1alpha1 "github.com/cnrancher/cilium-egress-operator/pkg/apis/egress.cilium.pandaria.io/v1alpha1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/elector"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	leaseHandlerName  = "cilium-egress-operator-group-lease"
+	nodeHandlerName   = "cilium-egress-operator-group-node"
+	policyHandlerName = "cilium-egress-operator-group-policy"
+)
+
+// syncLease enqueues the groups following the lease when the lease holder
+// changes.
+func (h *handler) syncLease(_ string, lease *coordinationv1.Lease) (*coordinationv1.Lease, error) {
+	if lease == nil || lease.DeletionTimestamp != nil {
+		return lease, nil
+	}
+	h.enqueueGroups(func(group *egressv1alpha1.EgressGatewayGroup) bool {
+		source := group.Spec.Source
+		if source.Type != egressv1alpha1.SourceTypeLease || source.Lease == nil {
+			return false
+		}
+		namespace := source.Lease.Namespace
+		if namespace == "" {
+			namespace = h.namespace
+		}
+		return source.Lease.Name == lease.Name && namespace == lease.Namespace
+	})
+	return lease, nil
+}
+
+// syncNode re-evaluates the groups when the readiness, taints, maintenance
+// state, IP or labels of the node change, only the groups the node is a
+// preferred, candidate or current gateway node of are enqueued.
+func (h *handler) syncNode(key string, node *corev1.Node) (*corev1.Node, error) {
+	deleted := node == nil || node.DeletionTimestamp != nil
+	// The gateway is recorded by the node hostname, which is only known from
+	// the last observed node once the node is deleted.
+	hostname := key
+	if node != nil {
+		hostname = utils.NodeHostname(node)
+	} else if last, ok := h.nodes.Delete(key); ok && last.Hostname != "" {
+		hostname = last.Hostname
+	}
+	// The node heartbeats do not affect the gateway election.
+	if !deleted && !h.nodes.Observe(node) {
+		return node, nil
+	}
+	h.enqueueGroups(func(group *egressv1alpha1.EgressGatewayGroup) bool {
+		if g, ok := gateway.Lookup(group.Name); ok && hostname != "" {
+			if hostname == g.LeaderNode() || slices.Contains(g.GatewayNodes(), hostname) {
+				return true
+			}
+		}
+		if deleted {
+			return false
+		}
+		opts, err := electorOptions(group)
+		if err != nil {
+			return false
+		}
+		e := elector.New(h.nodeCache, nil, opts)
+		return e.PreferredNode(node.Name) || e.CandidateNode(node)
+	})
+	return node, nil
+}
+
+// syncPolicy refreshes the status of the group the policy is bound to, and
+// the groups still reporting the policy in status.
+func (h *handler) syncPolicy(key string, p policy.Policy) (policy.Policy, error) {
+	h.enqueueGroups(func(group *egressv1alpha1.EgressGatewayGroup) bool {
+		if policy.Bound(p, group.Name) {
+			return true
+		}
+		return slices.ContainsFunc(group.Status.Policies, func(s egressv1alpha1.PolicyStatus) bool {
+			return s.Name == key
+		})
+	})
+	return p, nil
+}
+
+func (h *handler) enqueueGroups(filter func(*egressv1alpha1.EgressGatewayGroup) bool) {
+	groups, err := h.groupCache.List(labels.Everything())
+	if err != nil {
+		logrus.Warnf("Failed to list EgressGatewayGroup from cache: %v", err)
+		return
+	}
+	for _, group := range groups {
+		if group.DeletionTimestamp != nil || !utils.ManagedByInstance(group.Annotations) || !filter(group) {
+			continue
+		}
+		h.groupEnqueue(group.Name)
+	}
+}
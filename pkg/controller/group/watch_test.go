@@ -0,0 +1,150 @@
+package group
+
+import (
+	"slices"
+	"testing"
+
+	egressv1alpha1 "github.com/cnrancher/cilium-egress-operator/pkg/apis/egress.cilium.pandaria.io/v1alpha1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// electionGroup returns the elected group of the candidate nodes with the
+// gateway label value.
+func electionGroup(t *testing.T, name, value string) *egressv1alpha1.EgressGatewayGroup {
+	t.Helper()
+	t.Cleanup(func() { gateway.Delete(name) })
+	return &egressv1alpha1.EgressGatewayGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: egressv1alpha1.EgressGatewayGroupSpec{
+			Source: egressv1alpha1.GatewaySource{Type: egressv1alpha1.SourceTypeElection},
+			CandidateNodeSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"gateway": value},
+			},
+		},
+	}
+}
+
+// hostnameNode sets the hostname label of the node different from the node
+// name.
+func hostnameNode(node *corev1.Node, hostname string) *corev1.Node {
+	node.Labels[utils.HostnameLabelKey] = hostname
+	return node
+}
+
+func TestSyncNode(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		// node is nil for the deleted node.
+		node *corev1.Node
+		// observed is the node observed before the deletion.
+		observed *corev1.Node
+		want     []string
+	}{
+		{
+			name: "candidate node of a group",
+			key:  "node-1",
+			node: readyNode("node-1", "10.0.0.1", map[string]string{"gateway": "a"}),
+			want: []string{"group-a"},
+		},
+		{
+			name: "not a candidate node",
+			key:  "node-5",
+			node: readyNode("node-5", "10.0.0.5", nil),
+		},
+		{
+			name: "leader node of a group",
+			key:  "node-2",
+			node: readyNode("node-2", "10.0.0.2", nil),
+			want: []string{"group-b"},
+		},
+		{
+			name: "preferred node of a group",
+			key:  "node-4",
+			node: readyNode("node-4", "10.0.0.4", nil),
+			want: []string{"group-b"},
+		},
+		{
+			name: "deleted gateway node",
+			key:  "node-3",
+			want: []string{"group-b"},
+		},
+		{
+			name: "deleted node",
+			key:  "node-5",
+		},
+		{
+			name:     "deleted gateway node with the hostname differs from the node name",
+			key:      "ip-10-0-0-3",
+			observed: hostnameNode(readyNode("ip-10-0-0-3", "10.0.0.3", nil), "node-3"),
+			want:     []string{"group-b"},
+		},
+		{
+			name: "deleted node named like a gateway hostname",
+			key:  "node-2",
+			// The node named node-2 selected by hostname node-6 is observed.
+			observed: hostnameNode(readyNode("node-2", "10.0.0.6", nil), "node-6"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groupA := electionGroup(t, "group-a", "a")
+			groupB := electionGroup(t, "group-b", "b")
+			groupB.Spec.Failback = &egressv1alpha1.Failback{
+				Mode:           egressv1alpha1.FailbackModeImmediate,
+				PreferredNodes: []string{"node-4"},
+			}
+			// The group of another operator instance is never enqueued.
+			other := electionGroup(t, "group-other", "a")
+			other.Annotations = map[string]string{utils.InstanceAnnotation: "other"}
+			h := newTestHandler(t, Options{}, []*egressv1alpha1.EgressGatewayGroup{groupA, groupB, other})
+			g := gateway.For(groupB.Name)
+			g.SetLeaderNode("10.0.0.2", "node-2", false)
+			g.SetGatewayNodes([]string{"node-2", "node-3"})
+
+			if tt.observed != nil {
+				if _, err := h.syncNode(tt.observed.Name, tt.observed); err != nil {
+					t.Fatalf("syncNode() error = %v", err)
+				}
+				h.enqueued = nil
+			}
+			if _, err := h.syncNode(tt.key, tt.node); err != nil {
+				t.Fatalf("syncNode() error = %v", err)
+			}
+			if !slices.Equal(h.enqueued, tt.want) {
+				t.Errorf("enqueued groups = %v, want %v", h.enqueued, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncNodeHeartbeat(t *testing.T) {
+	group := electionGroup(t, "group-a", "a")
+	h := newTestHandler(t, Options{}, []*egressv1alpha1.EgressGatewayGroup{group})
+	node := readyNode("node-1", "10.0.0.1", map[string]string{"gateway": "a"})
+	if _, err := h.syncNode(node.Name, node); err != nil {
+		t.Fatalf("syncNode() error = %v", err)
+	}
+	h.enqueued = nil
+
+	node = node.DeepCopy()
+	node.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+	if _, err := h.syncNode(node.Name, node); err != nil {
+		t.Fatalf("syncNode() error = %v", err)
+	}
+	if len(h.enqueued) != 0 {
+		t.Errorf("heartbeat enqueued groups %v, want none", h.enqueued)
+	}
+
+	node = node.DeepCopy()
+	node.Spec.Unschedulable = true
+	if _, err := h.syncNode(node.Name, node); err != nil {
+		t.Fatalf("syncNode() error = %v", err)
+	}
+	if want := []string{"group-a"}; !slices.Equal(h.enqueued, want) {
+		t.Errorf("cordon enqueued groups %v, want %v", h.enqueued, want)
+	}
+}
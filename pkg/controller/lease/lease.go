@@ -3,51 +3,164 @@ package lease
 import (
 	"context"
 	"fmt"
-	"net"
+	"sync/atomic"
+	"time"
 
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/state"
 	"github.com/cnrancher/cilium-egress-operator/pkg/controller/wrangler"
-	ciliumcontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/cilium.io/v2"
+	"github.com/cnrancher/cilium-egress-operator/pkg/elector"
 	coordinationcontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/coordination.k8s.io/v1"
 	corecontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/core/v1"
 	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
 	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
 	"github.com/sirupsen/logrus"
 	coordinationv1 "k8s.io/api/coordination/v1"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 const (
 	handlerName = "cilium-egress-operator-lease"
 
-	providedNodeIPAnnotationkey = "alpha.kubernetes.io/provided-node-ip"
-	hostnameLabelKey            = "kubernetes.io/hostname"
-
-	kubeVIPLeaseName      = "plndr-svcs-lock"
+	// DefaultLeaseName is the default name of the KubeVIP lease.
+	DefaultLeaseName      = "plndr-svcs-lock"
 	kubeVIPLeaseNamespace = "kube-system"
 )
 
+// options is the current lease handler options, replaced on the config
+// reload.
+var options atomic.Pointer[Options]
+
+type Options struct {
+	// LeaseName is the name of the KubeVIP lease in the kube-system
+	// namespace, DefaultLeaseName is used if empty.
+	LeaseName string
+	// Elector is the gateway elector options of the default gateway group.
+	Elector elector.Options
+}
+
 type handler struct {
 	nodeCache  corecontroller.NodeCache
 	leaseCache coordinationcontroller.LeaseCache
-	cegpCache  ciliumcontroller.CiliumEgressGatewayPolicyCache
 
-	cegpEnqueue func(string)
+	leaseEnqueueAfter func(string, string, time.Duration)
+
+	state *state.Store
+	// nodes records the last observed nodes to resolve the hostname of the
+	// deleted nodes.
+	nodes *elector.NodeTracker
+}
+
+func newHandler(wctx *wrangler.Context) *handler {
+	return &handler{
+		nodeCache:  wctx.Core.Node().Cache(),
+		leaseCache: wctx.Coordination.Lease().Cache(),
+
+		leaseEnqueueAfter: wctx.Coordination.Lease().EnqueueAfter,
+
+		state: state.NewStore(wctx),
+		nodes: elector.NewNodeTracker(),
+	}
+}
+
+func setOptions(opts Options) {
+	if opts.LeaseName == "" {
+		opts.LeaseName = DefaultLeaseName
+	}
+	options.Store(&opts)
+}
+
+// currentOptions returns the current lease handler options.
+func currentOptions() Options {
+	if opts := options.Load(); opts != nil {
+		return *opts
+	}
+	return Options{LeaseName: DefaultLeaseName}
 }
 
 func Register(
 	ctx context.Context,
 	wctx *wrangler.Context,
+	opts Options,
 ) {
-	h := &handler{
-		nodeCache:  wctx.Core.Node().Cache(),
-		leaseCache: wctx.Coordination.Lease().Cache(),
-		cegpCache:  wctx.Cilium.CiliumEgressGatewayPolicy().Cache(),
+	logrus.Debugf("Lease Handler Options: %v", utils.DebugPrint(opts))
+	setOptions(opts)
+	h := newHandler(wctx)
+	wctx.Coordination.Lease().OnChange(ctx, handlerName, h.handleError(h.sync))
+	wctx.Core.Node().OnChange(ctx, nodeHandlerName, h.syncNode)
+}
 
-		cegpEnqueue: wctx.Cilium.CiliumEgressGatewayPolicy().Enqueue,
+// Bootstrap reads the KubeVIP lease and node objects from the informer cache
+// and populates the gateway store before the policy handlers start.
+// It waits until the leader node is determined or the timeout is reached.
+func Bootstrap(
+	ctx context.Context,
+	wctx *wrangler.Context,
+	timeout time.Duration,
+) {
+	h := newHandler(wctx)
+	start := time.Now()
+	// The informers are started by StartHandler after the bootstrap, sync the
+	// caches first to read the lease and node objects.
+	if err := wctx.SyncCaches(ctx); err != nil {
+		setLeaderKnown()
+		logrus.Errorf("Failed to sync caches before determining the gateway leader node: %v", err)
+		return
+	}
+	err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(_ context.Context) (bool, error) {
+		lease, err := h.leaseCache.Get(kubeVIPLeaseNamespace, leaseName())
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				logrus.Debugf("Waiting for lease [%v/%v] to be created",
+					kubeVIPLeaseNamespace, leaseName())
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to get lease from cache: %w", err)
+		}
+		if _, err := h.updateLeaderNode(lease); err != nil {
+			logrus.WithFields(fieldsLease(lease)).Warnf("Failed to determine leader node: %v", err)
+			return false, nil
+		}
+		return gateway.LeaderNode() != "", nil
+	})
+	metrics.BootstrapDuration.Set(time.Since(start).Seconds())
+	setLeaderKnown()
+	if err != nil {
+		logrus.Errorf("Unable to determine the gateway leader node in %v: %v, "+
+			"policies will be reconciled after the lease [%v/%v] is updated",
+			timeout, err, kubeVIPLeaseNamespace, leaseName())
+		return
 	}
+	logrus.Infof("Gateway leader node [%v] IP [%v] determined in %v",
+		gateway.LeaderNode(), gateway.LeaderNodeIP(), time.Since(start).Round(time.Millisecond))
+}
 
-	wctx.Coordination.Lease().OnChange(ctx, handlerName, h.handleError(h.sync))
+// Evaluate updates the default gateway group by the KubeVIP lease from the
+// informer cache without persisting the state or enqueueing the policies,
+// used by the standby operator pods to keep the gateway state warm.
+func Evaluate(wctx *wrangler.Context) error {
+	h := newHandler(wctx)
+	lease, err := h.leaseCache.Get(kubeVIPLeaseNamespace, leaseName())
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get lease from cache: %w", err)
+	}
+	_, err = h.elector().Evaluate(utils.Value(lease.Spec.HolderIdentity),
+		"KubeVIP Leader Node", fieldsLease(lease))
+	setLeaderKnown()
+	return err
+}
+
+// Reload replaces the lease handler options and re-evaluates the gateway node
+// of the KubeVIP lease.
+func Reload(wctx *wrangler.Context, opts Options) {
+	logrus.Debugf("Reload Lease Handler Options: %v", utils.DebugPrint(opts))
+	setOptions(opts)
+	wctx.Coordination.Lease().Enqueue(kubeVIPLeaseNamespace, leaseName())
 }
 
 func (h *handler) handleError(
@@ -64,73 +177,64 @@ func (h *handler) handleError(
 }
 
 func (h *handler) sync(_ string, lease *coordinationv1.Lease) (*coordinationv1.Lease, error) {
-	if lease == nil || lease.DeletionTimestamp != nil || lease.Name != kubeVIPLeaseName || lease.Namespace != kubeVIPLeaseNamespace {
-		return lease, nil
-	}
-	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+	if lease == nil || lease.DeletionTimestamp != nil || lease.Name != leaseName() || lease.Namespace != kubeVIPLeaseNamespace {
 		return lease, nil
 	}
-	nodeName := *lease.Spec.HolderIdentity
-	if gateway.LeaderNode() == nodeName {
-		return lease, nil
-	}
-
-	node, err := h.nodeCache.Get(nodeName)
+	changed, err := h.updateLeaderNode(lease)
 	if err != nil {
-		return lease, fmt.Errorf("failed to get node from cache: %w", err)
+		return lease, err
 	}
-	ip := nodeIP(node)
-	hostname := nodeHostname(node)
-	if ip == "" || hostname == "" {
-		logrus.WithFields(fieldsLease(lease)).Warnf("Failed to get IP/hostname from node %q", nodeName)
+	if !changed {
 		return lease, nil
 	}
-	logrus.WithFields(fieldsLease(lease)).Infof("Node [%v] IP [%v] is KubeVIP Leader Node", nodeName, ip)
-	gateway.SetLeaderNode(ip, hostname)
-
 	if err := h.enqueueAllPolicies(); err != nil {
 		return lease, err
 	}
 	return lease, nil
 }
 
-func (h *handler) enqueueAllPolicies() error {
-	policies, err := h.cegpCache.List(labels.Everything())
-	if err != nil {
-		return fmt.Errorf("failed to list CiliumEgressgatewayPolicy from cache: %w", err)
+// updateLeaderNode updates the default gateway group by the lease holder
+// node, returns true if the leader node changed.
+func (h *handler) updateLeaderNode(lease *coordinationv1.Lease) (bool, error) {
+	result, err := h.elector().Elect(utils.Value(lease.Spec.HolderIdentity),
+		"KubeVIP Leader Node", fieldsLease(lease))
+	if result.Requeue > 0 {
+		h.leaseEnqueueAfter(lease.Namespace, lease.Name, result.Requeue)
 	}
-	if len(policies) == 0 {
-		return nil
+	// The leader node may be restored from the persisted state or cleared
+	// without a change reported, the gauge follows the group state.
+	setLeaderKnown()
+	if err != nil || !result.Changed {
+		return false, err
 	}
-	for _, p := range policies {
-		if p == nil || p.DeletionTimestamp != nil || len(p.Annotations) == 0 {
-			continue
-		}
-		if p.Annotations[utils.WatchAnnotationPrefix] != utils.WatchAnnotationValue {
-			continue
-		}
-		h.cegpEnqueue(p.Name)
+	if err := h.state.Save(); err != nil {
+		logrus.WithFields(fieldsLease(lease)).Warnf("Failed to persist gateway state: %v", err)
 	}
-
-	return nil
+	return true, nil
 }
 
-func nodeIP(node *corev1.Node) string {
-	if node == nil || len(node.Annotations) == 0 {
-		return ""
-	}
-	ip := node.Annotations[providedNodeIPAnnotationkey]
-	if net.ParseIP(ip) == nil {
-		return ""
+// setLeaderKnown reports whether the leader node of the default gateway
+// group is known.
+func setLeaderKnown() {
+	if gateway.LeaderNode() != "" {
+		metrics.GatewayLeaderKnown.Set(1)
+		return
 	}
-	return ip
+	metrics.GatewayLeaderKnown.Set(0)
 }
 
-func nodeHostname(node *corev1.Node) string {
-	if node == nil || len(node.Labels) == 0 {
-		return ""
-	}
-	return node.Labels[hostnameLabelKey]
+// elector returns the gateway elector of the default gateway group with the
+// current options.
+func (h *handler) elector() *elector.Elector {
+	return elector.New(h.nodeCache, gateway.Default(), currentOptions().Elector)
+}
+
+func leaseName() string {
+	return currentOptions().LeaseName
+}
+
+func (h *handler) enqueueAllPolicies() error {
+	return policy.EnqueueGroup(gateway.DefaultGroup)
 }
 
 func fieldsLease(lease *coordinationv1.Lease) logrus.Fields {
@@ -138,7 +242,7 @@ func fieldsLease(lease *coordinationv1.Lease) logrus.Fields {
 		return logrus.Fields{}
 	}
 	return logrus.Fields{
-		"Lease": fmt.Sprintf("%v", lease.Name),
-		"Node":  utils.Value(lease.Spec.HolderIdentity),
+		utils.FieldLease:  lease.Name,
+		utils.FieldHolder: utils.Value(lease.Spec.HolderIdentity),
 	}
 }
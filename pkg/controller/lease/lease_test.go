@@ -0,0 +1,260 @@
+package lease
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/state"
+	"github.com/cnrancher/cilium-egress-operator/pkg/elector"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/fake"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetLevel(logrus.PanicLevel)
+	os.Exit(m.Run())
+}
+
+func readyNode(name, ip string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{utils.HostnameLabelKey: name},
+			Annotations: map[string]string{utils.ProvidedNodeIPAnnotationKey: ip},
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{
+				Type:               corev1.NodeReady,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Hour)),
+			}},
+		},
+	}
+}
+
+func kubeVIPLease(holder string) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DefaultLeaseName,
+			Namespace: kubeVIPLeaseNamespace,
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity: &holder,
+		},
+	}
+}
+
+// newTestHandler returns the lease handler of the nodes, the gateway state
+// is persisted into the returned ConfigMap client.
+func newTestHandler(t *testing.T, nodes ...*corev1.Node) (*handler, *fake.ConfigMapClient) {
+	t.Helper()
+	setOptions(Options{})
+	t.Cleanup(func() { options.Store(nil) })
+	resetGateway(t)
+
+	client := &fake.ConfigMapClient{}
+	return &handler{
+		nodeCache:         fake.NewNodeCache(nodes...),
+		leaseCache:        fake.NewCache[*coordinationv1.Lease](coordinationv1.Resource("leases")),
+		leaseEnqueueAfter: func(string, string, time.Duration) {},
+		state:             state.New(client, "kube-system", "gateway-state"),
+		nodes:             elector.NewNodeTracker(),
+	}, client
+}
+
+// resetGateway clears the default gateway group state of the test.
+func resetGateway(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		gateway.Restore(gateway.State{LastTransitionTime: time.Now()})
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestSyncLeaderKnown(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []*corev1.Node
+		// restored is the leader node restored before the sync.
+		restored string
+		// known is the gauge value before the sync.
+		known       float64
+		holder      string
+		wantLeader  string
+		wantKnown   float64
+		wantPersist bool
+	}{
+		{
+			name:        "leader elected",
+			nodes:       []*corev1.Node{readyNode("node-1", "10.0.0.1")},
+			holder:      "node-1",
+			wantLeader:  "node-1",
+			wantKnown:   1,
+			wantPersist: true,
+		},
+		{
+			name:       "restored leader unchanged",
+			nodes:      []*corev1.Node{readyNode("node-1", "10.0.0.1")},
+			restored:   "node-1",
+			holder:     "node-1",
+			wantLeader: "node-1",
+			wantKnown:  1,
+		},
+		{
+			name:        "deleted leader cleared",
+			restored:    "node-2",
+			known:       1,
+			holder:      "node-2",
+			wantKnown:   0,
+			wantPersist: true,
+		},
+		{
+			name:      "no holder",
+			known:     1,
+			wantKnown: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, client := newTestHandler(t, tt.nodes...)
+			if tt.restored != "" {
+				gateway.Restore(gateway.State{
+					LeaderNode:         tt.restored,
+					LeaderNodeIP:       "10.0.0.1",
+					LastTransitionTime: time.Now(),
+				})
+			}
+			metrics.GatewayLeaderKnown.Set(tt.known)
+
+			if _, err := h.sync("", kubeVIPLease(tt.holder)); err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+			if got := gateway.LeaderNode(); got != tt.wantLeader {
+				t.Errorf("leader node = %q, want %q", got, tt.wantLeader)
+			}
+			if got := testutil.ToFloat64(metrics.GatewayLeaderKnown); got != tt.wantKnown {
+				t.Errorf("gateway leader known = %v, want %v", got, tt.wantKnown)
+			}
+			if persisted := client.ConfigMap != nil; persisted != tt.wantPersist {
+				t.Errorf("gateway state persisted = %v, want %v", persisted, tt.wantPersist)
+			}
+		})
+	}
+}
+
+func TestSyncNodeDeleted(t *testing.T) {
+	// The node name differs from the hostname recorded as the gateway node.
+	node := readyNode("ip-10-0-0-1", "10.0.0.1")
+	node.Labels[utils.HostnameLabelKey] = "node-1"
+
+	tests := []struct {
+		name         string
+		key          string
+		observed     bool
+		gatewayNodes []string
+		want         bool
+	}{
+		{name: "observed leader node", key: "ip-10-0-0-1", observed: true, want: true},
+		{name: "unobserved leader node", key: "ip-10-0-0-1"},
+		{name: "other node", key: "ip-10-0-0-2", observed: true},
+		{
+			name:         "observed gateway node",
+			key:          "ip-10-0-0-1",
+			observed:     true,
+			gatewayNodes: []string{"node-2", "node-1"},
+			want:         true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler(t)
+			leader := "node-1"
+			if tt.gatewayNodes != nil {
+				leader = tt.gatewayNodes[0]
+			}
+			gateway.Restore(gateway.State{
+				LeaderNode:         leader,
+				LeaderNodeIP:       "10.0.0.100",
+				GatewayNodes:       tt.gatewayNodes,
+				LastTransitionTime: time.Now(),
+			})
+			var enqueued int
+			h.leaseEnqueueAfter = func(string, string, time.Duration) { enqueued++ }
+			if tt.observed {
+				if _, err := h.syncNode(node.Name, node); err != nil {
+					t.Fatalf("syncNode() error = %v", err)
+				}
+			}
+			enqueued = 0
+
+			if _, err := h.syncNode(tt.key, nil); err != nil {
+				t.Fatalf("syncNode() error = %v", err)
+			}
+			if got := enqueued > 0; got != tt.want {
+				t.Errorf("lease enqueued = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncNodeUpdated(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(n *corev1.Node)
+		want   bool
+	}{
+		{
+			name: "heartbeat",
+			update: func(n *corev1.Node) {
+				n.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+			},
+		},
+		{
+			name: "not ready",
+			update: func(n *corev1.Node) {
+				n.Status.Conditions[0].Status = corev1.ConditionFalse
+			},
+			want: true,
+		},
+		{
+			name: "maintenance",
+			update: func(n *corev1.Node) {
+				n.Annotations[utils.MaintenanceAnnotation] = utils.MaintenanceAnnotationValue
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler(t)
+			var enqueued int
+			h.leaseEnqueueAfter = func(string, string, time.Duration) { enqueued++ }
+			node := readyNode("node-1", "10.0.0.1")
+			if _, err := h.syncNode(node.Name, node); err != nil {
+				t.Fatalf("syncNode() error = %v", err)
+			}
+			if enqueued != 1 {
+				t.Errorf("lease enqueued %v times on the first sync, want 1", enqueued)
+			}
+			enqueued = 0
+
+			node = node.DeepCopy()
+			tt.update(node)
+			if _, err := h.syncNode(node.Name, node); err != nil {
+				t.Fatalf("syncNode() error = %v", err)
+			}
+			if got := enqueued > 0; got != tt.want {
+				t.Errorf("lease enqueued = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
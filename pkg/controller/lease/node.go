@@ -0,0 +1,52 @@
+package lease
+
+import (
+	"slices"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	nodeHandlerName = "cilium-egress-operator-lease-node"
+)
+
+// syncNode re-evaluates the gateway node when the readiness, taints,
+// maintenance state, IP or labels of the preferred, candidate or current
+// gateway node change, or the gateway node is deleted.
+func (h *handler) syncNode(key string, node *corev1.Node) (*corev1.Node, error) {
+	if node == nil || node.DeletionTimestamp != nil {
+		// The gateway is recorded by the node hostname, which is only known
+		// from the last observed node once the node is deleted.
+		hostname := key
+		if node != nil {
+			hostname = utils.NodeHostname(node)
+		} else if last, ok := h.nodes.Delete(key); ok && last.Hostname != "" {
+			hostname = last.Hostname
+		}
+		if gatewayNode(hostname) {
+			h.leaseEnqueueAfter(kubeVIPLeaseNamespace, leaseName(), 0)
+		}
+		return node, nil
+	}
+	// The node heartbeats do not affect the gateway election.
+	if !h.nodes.Observe(node) {
+		return node, nil
+	}
+	if !h.elector().PreferredNode(node.Name) && !h.elector().CandidateNode(node) &&
+		!gatewayNode(utils.NodeHostname(node)) {
+		return node, nil
+	}
+	h.leaseEnqueueAfter(kubeVIPLeaseNamespace, leaseName(), 0)
+	return node, nil
+}
+
+// gatewayNode returns true if the hostname is the leader node or one of the
+// gateway nodes of the default gateway group.
+func gatewayNode(hostname string) bool {
+	if hostname == "" {
+		return false
+	}
+	return hostname == gateway.LeaderNode() || slices.Contains(gateway.Default().GatewayNodes(), hostname)
+}
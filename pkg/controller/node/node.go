@@ -0,0 +1,175 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/wrangler"
+	"github.com/cnrancher/cilium-egress-operator/pkg/elector"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	handlerName = "cilium-egress-operator-node"
+)
+
+// health is the node fields affecting the gateway health.
+type health struct {
+	hostname  string
+	ready     bool
+	taints    string
+	ip        string
+	addresses string
+}
+
+func (s health) String() string {
+	return fmt.Sprintf("ready [%v] taints [%v] IP [%v] addresses [%v]",
+		s.ready, s.taints, s.ip, s.addresses)
+}
+
+type handler struct {
+	// nodes records the last observed health of the nodes.
+	nodes   map[string]health
+	nodesMu sync.Mutex
+}
+
+// Register registers the node handler which enqueues the monitored policies
+// selecting the node when its readiness, taints or IP changes, so the
+// policies are reconciled without waiting for the lease changes.
+func Register(
+	ctx context.Context,
+	wctx *wrangler.Context,
+) {
+	h := &handler{
+		nodes: make(map[string]health),
+	}
+	wctx.Core.Node().OnChange(ctx, handlerName, h.handleError(h.sync))
+}
+
+func (h *handler) handleError(
+	sync func(string, *corev1.Node) (*corev1.Node, error),
+) func(string, *corev1.Node) (*corev1.Node, error) {
+	return func(s string, node *corev1.Node) (*corev1.Node, error) {
+		nodeSynced, err := sync(s, node)
+		if err != nil {
+			logrus.WithFields(fieldsNode(node)).Error(err)
+			return node, err
+		}
+		return nodeSynced, nil
+	}
+}
+
+func (h *handler) sync(key string, node *corev1.Node) (*corev1.Node, error) {
+	if node == nil || node.DeletionTimestamp != nil {
+		h.nodesMu.Lock()
+		last, ok := h.nodes[key]
+		delete(h.nodes, key)
+		h.nodesMu.Unlock()
+		if !ok {
+			return node, nil
+		}
+		// Move the policies selecting the deleted node.
+		logrus.WithFields(logrus.Fields{utils.FieldNode: key}).Infof("Node deleted")
+		if err := policy.EnqueueGatewayNode(last.hostname); err != nil {
+			return node, err
+		}
+		return node, nil
+	}
+
+	current := nodeHealth(node)
+	h.nodesMu.Lock()
+	last, ok := h.nodes[node.Name]
+	h.nodesMu.Unlock()
+	if ok && last == current {
+		return node, nil
+	}
+	if ok {
+		logrus.WithFields(fieldsNode(node)).
+			Infof("Node health changed from %v to %v", last, current)
+	}
+	if err := policy.EnqueueGatewayNode(utils.NodeHostname(node)); err != nil {
+		return node, err
+	}
+
+	h.nodesMu.Lock()
+	h.nodes[node.Name] = current
+	h.nodesMu.Unlock()
+	return node, nil
+}
+
+// CheckPolicies reports the monitored policies selecting the gateway nodes
+// not existing anymore, and enqueues them to be moved to the current gateway.
+// It is called on startup as the node deletion may not be observed.
+func CheckPolicies(ctx context.Context, wctx *wrangler.Context) error {
+	if err := wctx.SyncCaches(ctx); err != nil {
+		return fmt.Errorf("failed to sync caches: %w", err)
+	}
+	nodeCache := wctx.Core.Node().Cache()
+	for _, kind := range policy.Kinds() {
+		policies, err := policy.ListMonitored(kind)
+		if err != nil {
+			return err
+		}
+		for _, p := range policies {
+			hostnames := p.Hostnames()
+			if hostname := p.Hostname(); hostname != "" {
+				hostnames = []string{hostname}
+			}
+			for _, hostname := range hostnames {
+				nodes, err := nodeCache.List(labels.SelectorFromSet(labels.Set{
+					utils.HostnameLabelKey: hostname,
+				}))
+				if err != nil {
+					return fmt.Errorf("failed to list nodes from cache: %w", err)
+				}
+				if len(nodes) > 0 {
+					continue
+				}
+				logrus.WithFields(logrus.Fields{utils.FieldKind: kind.Kind(), utils.FieldPolicy: p.GetName()}).
+					Warnf("Policy selects gateway node [%v] not existing anymore", hostname)
+				kind.Enqueue(p.GetName())
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func nodeHealth(node *corev1.Node) health {
+	ready, _ := elector.NodeReady(node)
+	taints := make([]string, 0, len(node.Spec.Taints))
+	for _, t := range node.Spec.Taints {
+		taints = append(taints, fmt.Sprintf("%v:%v", t.Key, t.Effect))
+	}
+	slices.Sort(taints)
+	addresses := make([]string, 0, len(node.Status.Addresses))
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeInternalIP || addr.Type == corev1.NodeExternalIP {
+			addresses = append(addresses, addr.Address)
+		}
+	}
+	slices.Sort(addresses)
+	return health{
+		hostname:  utils.NodeHostname(node),
+		ready:     ready,
+		taints:    strings.Join(taints, ","),
+		ip:        utils.NodeIP(node),
+		addresses: strings.Join(addresses, ","),
+	}
+}
+
+func fieldsNode(node *corev1.Node) logrus.Fields {
+	if node == nil {
+		return logrus.Fields{}
+	}
+	return logrus.Fields{
+		utils.FieldNode: node.Name,
+	}
+}
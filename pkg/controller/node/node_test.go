@@ -0,0 +1,176 @@
+package node
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/cnrancher/cilium-egress-operator/pkg/policy"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testKind is the policy kind registered for the tests, a policy named
+// after the gateway node hostname selects the node.
+var testKind = &fakeKind{}
+
+func TestMain(m *testing.M) {
+	logrus.SetLevel(logrus.PanicLevel)
+	policy.Register(testKind)
+	os.Exit(m.Run())
+}
+
+// fakeKind records the enqueued policies.
+type fakeKind struct {
+	enqueued []string
+}
+
+func (k *fakeKind) Kind() string {
+	return policy.CiliumKind
+}
+
+func (k *fakeKind) Enqueue(name string) {
+	k.enqueued = append(k.enqueued, name)
+}
+
+func (k *fakeKind) ByIndex(indexName, key string) ([]policy.Policy, error) {
+	if indexName != policy.IndexGatewayNode {
+		return nil, nil
+	}
+	return []policy.Policy{policy.NewCiliumPolicy(&ciliumv2.CiliumEgressGatewayPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: key},
+	})}, nil
+}
+
+func (k *fakeKind) OnChange(context.Context, string, func(string, policy.Policy) (policy.Policy, error)) {
+}
+
+func (k *fakeKind) EnqueueAfter(name string, _ time.Duration) {
+	k.Enqueue(name)
+}
+
+func (k *fakeKind) Get(name string) (policy.Policy, error) {
+	return nil, apierrors.NewNotFound(ciliumv2.Resource("ciliumegressgatewaypolicies"), name)
+}
+
+func (k *fakeKind) Update(p policy.Policy) (policy.Policy, error) {
+	return p, nil
+}
+
+func newNode(name, hostname, ip string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{utils.HostnameLabelKey: hostname},
+			Annotations: map[string]string{utils.ProvidedNodeIPAnnotationKey: ip},
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{
+				Type:   corev1.NodeReady,
+				Status: corev1.ConditionTrue,
+			}},
+		},
+	}
+}
+
+func TestSync(t *testing.T) {
+	heartbeat := func(n *corev1.Node) {
+		n.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+	}
+	tests := []struct {
+		name   string
+		update func(n *corev1.Node)
+		want   []string
+	}{
+		{
+			name:   "heartbeat",
+			update: heartbeat,
+		},
+		{
+			name: "not ready",
+			update: func(n *corev1.Node) {
+				n.Status.Conditions[0].Status = corev1.ConditionFalse
+			},
+			want: []string{"node-1"},
+		},
+		{
+			name: "tainted",
+			update: func(n *corev1.Node) {
+				n.Spec.Taints = []corev1.Taint{{Key: corev1.TaintNodeUnreachable, Effect: corev1.TaintEffectNoExecute}}
+			},
+			want: []string{"node-1"},
+		},
+		{
+			name: "IP changed",
+			update: func(n *corev1.Node) {
+				n.Annotations[utils.ProvidedNodeIPAnnotationKey] = "10.0.0.11"
+			},
+			want: []string{"node-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{nodes: make(map[string]health)}
+			node := newNode("ip-10-0-0-1", "node-1", "10.0.0.1")
+			if _, err := h.sync(node.Name, node); err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+			if want := []string{"node-1"}; !slices.Equal(testKind.enqueued, want) {
+				t.Errorf("first sync enqueued %v, want %v", testKind.enqueued, want)
+			}
+			testKind.enqueued = nil
+
+			node = node.DeepCopy()
+			tt.update(node)
+			if _, err := h.sync(node.Name, node); err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+			if !slices.Equal(testKind.enqueued, tt.want) {
+				t.Errorf("enqueued policies = %v, want %v", testKind.enqueued, tt.want)
+			}
+			testKind.enqueued = nil
+		})
+	}
+}
+
+func TestSyncDeleted(t *testing.T) {
+	tests := []struct {
+		name     string
+		observed bool
+		want     []string
+	}{
+		// The policies select the deleted node by the last observed hostname
+		// instead of the node name.
+		{name: "observed node", observed: true, want: []string{"node-1"}},
+		{name: "unobserved node"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{nodes: make(map[string]health)}
+			node := newNode("ip-10-0-0-1", "node-1", "10.0.0.1")
+			if tt.observed {
+				if _, err := h.sync(node.Name, node); err != nil {
+					t.Fatalf("sync() error = %v", err)
+				}
+			}
+			testKind.enqueued = nil
+
+			if _, err := h.sync(node.Name, nil); err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+			if !slices.Equal(testKind.enqueued, tt.want) {
+				t.Errorf("enqueued policies = %v, want %v", testKind.enqueued, tt.want)
+			}
+			if len(h.nodes) != 0 {
+				t.Errorf("deleted node still recorded: %v", h.nodes)
+			}
+			testKind.enqueued = nil
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package standby
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/group"
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/lease"
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/wrangler"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// DefaultInterval is the default interval of the standby gateway evaluation.
+const DefaultInterval = time.Second * 10
+
+// leader is true after the pod acquires the leadership.
+var leader atomic.Bool
+
+type Options struct {
+	// GatewayGroups evaluates the EgressGatewayGroups as well.
+	GatewayGroups bool
+	// Interval is the interval to evaluate the gateway state.
+	Interval time.Duration
+}
+
+// Run keeps the gateway store of the standby operator pod warm by evaluating
+// the gateway nodes from the informer caches periodically. It is read-only,
+// the state is not persisted and the policies are not updated, the
+// evaluation stops when the context is canceled or the pod becomes leader.
+func Run(ctx context.Context, wctx *wrangler.Context, opts Options) error {
+	logrus.Debugf("Standby Options: %v", utils.DebugPrint(opts))
+	if err := wctx.SyncCaches(ctx); err != nil {
+		return err
+	}
+	logrus.Infof("Pod [%v] is standby, evaluating gateway state every %v",
+		utils.Hostname(), opts.Interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if leader.Load() {
+			return
+		}
+		evaluate(wctx, opts)
+	}, opts.Interval)
+	return nil
+}
+
+func evaluate(wctx *wrangler.Context, opts Options) {
+	if err := lease.Evaluate(wctx); err != nil {
+		logrus.Debugf("Failed to evaluate gateway state: %v", err)
+	}
+	if !opts.GatewayGroups {
+		return
+	}
+	if err := group.Evaluate(wctx); err != nil {
+		logrus.Debugf("Failed to evaluate gateway group states: %v", err)
+	}
+}
+
+// SetLeader marks the pod as leader, the gateway state is then maintained by
+// the handlers.
+func SetLeader(isLeader bool) {
+	leader.Store(isLeader)
+}
+
+// view is the gateway state of the operator pod served by the debug endpoint.
+type view struct {
+	Pod    string                   `json:"pod"`
+	Leader bool                     `json:"leader"`
+	Groups map[string]gateway.State `json:"groups"`
+}
+
+// Handler returns the HTTP handler serving the gateway state of the operator
+// pod, used for comparing the standby view with the leader.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		v := view{
+			Pod:    utils.Hostname(),
+			Leader: leader.Load(),
+			Groups: gateway.Snapshots(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			logrus.Warnf("Failed to write gateway state: %v", err)
+		}
+	})
+}
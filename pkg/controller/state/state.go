@@ -0,0 +1,147 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/wrangler"
+	corecontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/core/v1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	configMapName = "cilium-egress-operator-state"
+	gatewayKey    = "gateway"
+	groupsKey     = "groups"
+
+	managedByLabelKey   = "app.kubernetes.io/managed-by"
+	managedByLabelValue = "cilium-egress-operator"
+)
+
+// Store persists the gateway state into a ConfigMap owned by the operator,
+// so the state can be reloaded after the operator failover.
+type Store struct {
+	configMapClient corecontroller.ConfigMapClient
+	namespace       string
+	name            string
+}
+
+func NewStore(wctx *wrangler.Context) *Store {
+	name := configMapName
+	if id := utils.InstanceID(); id != "" {
+		name = configMapName + "-" + id
+	}
+	return New(wctx.Core.ConfigMap(), wctx.Namespace, name)
+}
+
+// New returns the store persisting the gateway state into the ConfigMap.
+func New(client corecontroller.ConfigMapClient, namespace, name string) *Store {
+	return &Store{
+		configMapClient: client,
+		namespace:       namespace,
+		name:            name,
+	}
+}
+
+// Load reads the persisted gateway states and restores them into the gateway
+// store, the newer states evaluated by the standby pod are kept.
+func (s *Store) Load() error {
+	cm, err := s.configMapClient.Get(s.namespace, s.name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			logrus.Infof("Gateway state ConfigMap [%v/%v] not found, skip reloading",
+				s.namespace, s.name)
+			return nil
+		}
+		return fmt.Errorf("failed to get ConfigMap %q: %w", s.name, err)
+	}
+	if data := cm.Data[gatewayKey]; data != "" {
+		var state gateway.State
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			return fmt.Errorf("failed to decode gateway state from ConfigMap %q: %w", s.name, err)
+		}
+		if !gateway.Restore(state) {
+			current := gateway.Snapshot()
+			logrus.Infof("Keep the evaluated gateway state: leader node [%v] IP [%v], last transition [%v] "+
+				"is newer than the persisted leader node [%v]",
+				current.LeaderNode, current.LeaderNodeIP, current.LastTransitionTime, state.LeaderNode)
+		} else {
+			logrus.Infof("Reloaded gateway state: leader node [%v] IP [%v], previous node [%v] IP [%v], last transition [%v]",
+				state.LeaderNode, state.LeaderNodeIP, state.PreviousNode, state.PreviousNodeIP, state.LastTransitionTime)
+		}
+	}
+	if data := cm.Data[groupsKey]; data != "" {
+		var states map[string]gateway.State
+		if err := json.Unmarshal([]byte(data), &states); err != nil {
+			return fmt.Errorf("failed to decode gateway group states from ConfigMap %q: %w", s.name, err)
+		}
+		delete(states, gateway.DefaultGroup)
+		restored := gateway.RestoreGroups(states)
+		for name, state := range states {
+			if !slices.Contains(restored, name) {
+				logrus.Infof("Keep the evaluated gateway group [%v] state, newer than the persisted leader node [%v]",
+					name, state.LeaderNode)
+				continue
+			}
+			logrus.Infof("Reloaded gateway group [%v] state: leader node [%v] IP [%v]",
+				name, state.LeaderNode, state.LeaderNodeIP)
+		}
+	}
+	return nil
+}
+
+// Save writes the current gateway states into the ConfigMap.
+func (s *Store) Save() error {
+	states := gateway.Snapshots()
+	b, err := json.Marshal(states[gateway.DefaultGroup])
+	if err != nil {
+		return fmt.Errorf("failed to encode gateway state: %w", err)
+	}
+	delete(states, gateway.DefaultGroup)
+	gb, err := json.Marshal(states)
+	if err != nil {
+		return fmt.Errorf("failed to encode gateway group states: %w", err)
+	}
+	data := map[string]string{
+		gatewayKey: string(b),
+		groupsKey:  string(gb),
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := s.configMapClient.Get(s.namespace, s.name, metav1.GetOptions{})
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to get ConfigMap %q: %w", s.name, err)
+			}
+			_, err = s.configMapClient.Create(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      s.name,
+					Namespace: s.namespace,
+					Labels: map[string]string{
+						managedByLabelKey: managedByLabelValue,
+					},
+				},
+				Data: data,
+			})
+			return err
+		}
+		if cm.Data[gatewayKey] == data[gatewayKey] && cm.Data[groupsKey] == data[groupsKey] {
+			return nil
+		}
+		cm = cm.DeepCopy()
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		maps.Copy(cm.Data, data)
+		_, err = s.configMapClient.Update(cm)
+		return err
+	})
+}
@@ -0,0 +1,159 @@
+package state
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/fake"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestStore(t *testing.T, cm *corev1.ConfigMap) (*Store, *fake.ConfigMapClient) {
+	t.Helper()
+	logrus.SetLevel(logrus.PanicLevel)
+	client := &fake.ConfigMapClient{ConfigMap: cm}
+	return New(client, "kube-system", configMapName), client
+}
+
+// resetGateway clears the gateway states of the test.
+func resetGateway(t *testing.T, groups ...string) {
+	t.Helper()
+	reset := func() {
+		gateway.Restore(gateway.State{LastTransitionTime: time.Now()})
+		gateway.RestoreGroups(nil)
+		for _, name := range groups {
+			gateway.Delete(name)
+		}
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestSaveLoad(t *testing.T) {
+	resetGateway(t, "group-a")
+	transition := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := gateway.State{
+		LeaderNode:         "node-2",
+		LeaderNodeIP:       "10.0.0.2",
+		PreviousNode:       "node-1",
+		PreviousNodeIP:     "10.0.0.1",
+		LastTransitionTime: transition,
+		Planned:            true,
+		GatewayNodes:       []string{"node-2", "node-3"},
+	}
+	wantGroup := gateway.State{
+		LeaderNode:         "node-4",
+		LeaderNodeIP:       "10.0.0.4",
+		LastTransitionTime: transition,
+	}
+	gateway.Restore(want)
+	gateway.For("group-a").Restore(wantGroup)
+
+	s, client := newTestStore(t, nil)
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if client.ConfigMap == nil {
+		t.Fatal("Save() did not create the ConfigMap")
+	}
+	if got := client.ConfigMap.Labels[managedByLabelKey]; got != managedByLabelValue {
+		t.Errorf("ConfigMap label %v = %q, want %q", managedByLabelKey, got, managedByLabelValue)
+	}
+	// Saving the unchanged state does not update the ConfigMap.
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if client.Updates != 0 {
+		t.Errorf("Save() of the unchanged state updated the ConfigMap %v times", client.Updates)
+	}
+
+	// Reload the persisted state as a new leader without gateway state.
+	resetGateway(t, "group-a")
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := gateway.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded gateway state = %+v, want %+v", got, want)
+	}
+	if got := gateway.For("group-a").Snapshot(); !reflect.DeepEqual(got, wantGroup) {
+		t.Errorf("reloaded group state = %+v, want %+v", got, wantGroup)
+	}
+
+	gateway.SetLeaderNode("10.0.0.3", "node-3", false)
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if client.Updates != 1 {
+		t.Errorf("Save() of the changed state updated the ConfigMap %v times, want 1", client.Updates)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	persisted := `{"leaderNode":"node-1","leaderNodeIP":"10.0.0.1","lastTransitionTime":"2026-01-02T03:04:05Z"}`
+
+	tests := []struct {
+		name    string
+		data    map[string]string
+		current func()
+		wantErr bool
+		// wantLeader is the leader node of the default group after loading.
+		wantLeader string
+	}{
+		{
+			name: "ConfigMap not found",
+		},
+		{
+			name: "empty data",
+			data: map[string]string{},
+		},
+		{
+			name:       "persisted state",
+			data:       map[string]string{gatewayKey: persisted},
+			wantLeader: "node-1",
+		},
+		{
+			name: "newer evaluated state",
+			data: map[string]string{gatewayKey: persisted},
+			current: func() {
+				gateway.SetLeaderNode("10.0.0.2", "node-2", false)
+			},
+			wantLeader: "node-2",
+		},
+		{
+			name:    "invalid gateway state",
+			data:    map[string]string{gatewayKey: "{"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid group states",
+			data:    map[string]string{groupsKey: "[]"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetGateway(t)
+			if tt.current != nil {
+				tt.current()
+			}
+			var cm *corev1.ConfigMap
+			if tt.data != nil {
+				cm = &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: configMapName, Namespace: "kube-system"},
+					Data:       tt.data,
+				}
+			}
+			s, _ := newTestStore(t, cm)
+			if err := s.Load(); (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := gateway.LeaderNode(); got != tt.wantLeader {
+				t.Errorf("leader node = %q, want %q", got, tt.wantLeader)
+			}
+		})
+	}
+}
@@ -3,37 +3,91 @@ package wrangler
 import (
 	"context"
 	"fmt"
+	"os"
 	"sync"
+	"time"
 
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
 	"github.com/rancher/lasso/pkg/controller"
+	"github.com/rancher/wrangler/v3/pkg/generic"
 	"github.com/rancher/wrangler/v3/pkg/leader"
 	"github.com/rancher/wrangler/v3/pkg/start"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/cilium.io"
-	ciliumv2 "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/cilium.io/v2"
+	ciliumcontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/cilium.io/v2"
 	"github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/coordination.k8s.io"
 	coordinationv1 "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/coordination.k8s.io/v1"
 	"github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/core"
 	corecontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/core/v1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/egress.cilium.pandaria.io"
+	egresscontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/egress.cilium.pandaria.io/v1alpha1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
 )
 
 const (
-	controllerName      = "cilium-egress-operator"
-	controllerNamespace = "kube-system"
+	controllerName = "cilium-egress-operator"
+	// DefaultNamespace is the default namespace of the leader election lock
+	// and the operator owned resources.
+	DefaultNamespace = "kube-system"
+	// leaseNamespace is the namespace of the watched gateway leases
+	// (e.g. kube-vip).
+	leaseNamespace = "kube-system"
+
+	// The leader election timing env keys read by the wrangler leader package.
+	devModeEnvKey       = "CATTLE_DEV_MODE"
+	leaseDurationEnvKey = "CATTLE_ELECTION_LEASE_DURATION"
+	renewDeadlineEnvKey = "CATTLE_ELECTION_RENEW_DEADLINE"
+	retryPeriodEnvKey   = "CATTLE_ELECTION_RETRY_PERIOD"
+
+	// The leader election timings of the wrangler leader package used if
+	// the env is not set, the dev mode extends the lease to 45h.
+	defaultLeaseDuration     = time.Second * 45
+	defaultRenewDeadline     = time.Second * 30
+	defaultRetryPeriod       = time.Second * 2
+	developmentLeaseDuration = time.Hour * 45
+	developmentRenewDeadline = time.Hour * 30
 )
 
+// Options is the wrangler context options.
+type Options struct {
+	// Namespace is the namespace of the leader election lock and the operator
+	// owned resources, DefaultNamespace is used if empty.
+	Namespace string
+	// LeaseDuration, RenewDeadline and RetryPeriod are the leader election
+	// timings of the operator pods, the wrangler defaults are used if 0.
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
 type Context struct {
 	RESTConfig        *rest.Config
 	Kubernetes        kubernetes.Interface
 	ControllerFactory controller.SharedControllerFactory
+	// Namespace is the namespace for the leader election lock and the
+	// operator owned resources.
+	Namespace string
+	// LeaseNamespace is the namespace of the gateway leases watched by the
+	// operator.
+	LeaseNamespace string
 
 	Core         corecontroller.Interface
 	Coordination coordinationv1.Interface
-	Cilium       ciliumv2.Interface
+	Cilium       ciliumcontroller.Interface
+	Egress       egresscontroller.Interface
+	// Dynamic is the factory of the unstructured controllers for the
+	// resources without the generated types.
+	Dynamic *generic.Factory
+
+	Recorder record.EventRecorder
 
 	leadership *leader.Manager
 	starters   []start.Starter
@@ -41,12 +95,26 @@ type Context struct {
 	controllerLock sync.Mutex
 }
 
-func NewContext(restCfg *rest.Config) (*Context, error) {
+// NewContext builds the wrangler context, the leader election lock is named
+// by the operator instance ID so multiple instances can run in a cluster.
+func NewContext(restCfg *rest.Config, opts Options) (*Context, error) {
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultNamespace
+	}
+	if err := setElectionEnv(opts); err != nil {
+		return nil, err
+	}
+
+	// Use a stable user agent so the operator updates are recorded in the
+	// managedFields with a known field manager name.
+	restCfg = rest.CopyConfig(restCfg)
+	restCfg.UserAgent = fmt.Sprintf("%v/%v", utils.FieldManager, utils.Version)
+
 	core, err := core.NewFactoryFromConfig(restCfg)
 	if err != nil {
 		return nil, fmt.Errorf("core factory: %w", err)
 	}
-	coordination, err := coordination.NewFactoryFromConfigWithNamespace(restCfg, controllerNamespace)
+	coordination, err := coordination.NewFactoryFromConfigWithNamespace(restCfg, leaseNamespace)
 	if err != nil {
 		return nil, fmt.Errorf("coordination.k8s.io factory: %w", err)
 	}
@@ -56,6 +124,16 @@ func NewContext(restCfg *rest.Config) (*Context, error) {
 		return nil, fmt.Errorf("cilium factory: %w", err)
 	}
 
+	egress, err := egress.NewFactoryFromConfig(restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("egress factory: %w", err)
+	}
+
+	dynamic, err := generic.NewFactoryFromConfigWithOptions(restCfg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dynamic factory: %w", err)
+	}
+
 	controllerFactory, err := controller.NewSharedControllerFactoryFromConfig(restCfg, runtime.NewScheme())
 	if err != nil {
 		return nil, fmt.Errorf("failed to build shared controller factory: %w", err)
@@ -65,24 +143,118 @@ func NewContext(restCfg *rest.Config) (*Context, error) {
 	if err != nil {
 		return nil, fmt.Errorf("kubernetes.NewForConfig: %w", err)
 	}
-	leadership := leader.NewManager(controllerNamespace, controllerName, k8s)
+	recorder, err := newRecorder(k8s)
+	if err != nil {
+		return nil, err
+	}
+	leadership := leader.NewManager(opts.Namespace, LeaderElectionName(), k8s)
 	c := &Context{
 		RESTConfig:        restCfg,
 		Kubernetes:        k8s,
 		ControllerFactory: controllerFactory,
+		Namespace:         opts.Namespace,
+		LeaseNamespace:    leaseNamespace,
 
 		Core:         core.Core().V1(),
 		Coordination: coordination.Coordination().V1(),
 		Cilium:       cilium.Cilium().V2(),
+		Egress:       egress.Egress().V1alpha1(),
+		Dynamic:      dynamic,
+
+		Recorder: recorder,
 
 		leadership: leadership,
 	}
 	c.starters = append(c.starters,
-		core, coordination, cilium)
+		core, coordination, cilium, egress, dynamic)
 
 	return c, nil
 }
 
+// setElectionEnv passes the leader election timings to the wrangler leader
+// package, which reads them from the env.
+func setElectionEnv(opts Options) error {
+	for key, d := range map[string]time.Duration{
+		leaseDurationEnvKey: opts.LeaseDuration,
+		renewDeadlineEnvKey: opts.RenewDeadline,
+		retryPeriodEnvKey:   opts.RetryPeriod,
+	} {
+		if d <= 0 {
+			continue
+		}
+		if err := os.Setenv(key, d.String()); err != nil {
+			return fmt.Errorf("failed to set env %v: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// ValidateElection checks the leader election timings the wrangler leader
+// package uses with the options, which override the env and the defaults,
+// requires leaseDuration > renewDeadline > retryPeriod*1.2.
+func ValidateElection(opts Options) error {
+	if opts.LeaseDuration < 0 || opts.RenewDeadline < 0 || opts.RetryPeriod < 0 {
+		return fmt.Errorf("lease duration %v, renew deadline %v and retry period %v must not be negative",
+			opts.LeaseDuration, opts.RenewDeadline, opts.RetryPeriod)
+	}
+	leaseDuration, renewDeadline, retryPeriod := defaultLeaseDuration, defaultRenewDeadline, defaultRetryPeriod
+	if os.Getenv(devModeEnvKey) != "" {
+		leaseDuration, renewDeadline = developmentLeaseDuration, developmentRenewDeadline
+	}
+	for _, t := range []struct {
+		key    string
+		option time.Duration
+		value  *time.Duration
+	}{
+		{leaseDurationEnvKey, opts.LeaseDuration, &leaseDuration},
+		{renewDeadlineEnvKey, opts.RenewDeadline, &renewDeadline},
+		{retryPeriodEnvKey, opts.RetryPeriod, &retryPeriod},
+	} {
+		if t.option > 0 {
+			*t.value = t.option
+			continue
+		}
+		if env := os.Getenv(t.key); env != "" {
+			d, err := time.ParseDuration(env)
+			if err != nil {
+				return fmt.Errorf("env %v value %q is not a valid duration: %w", t.key, env, err)
+			}
+			*t.value = d
+		}
+	}
+	if leaseDuration <= renewDeadline || renewDeadline <= time.Duration(float64(retryPeriod)*1.2) {
+		return fmt.Errorf("lease duration %v, renew deadline %v and retry period %v should be "+
+			"leaseDuration > renewDeadline > retryPeriod*1.2", leaseDuration, renewDeadline, retryPeriod)
+	}
+	return nil
+}
+
+// LeaderElectionName returns the leader election lock name of the operator
+// instance.
+func LeaderElectionName() string {
+	if id := utils.InstanceID(); id != "" {
+		return controllerName + "-" + id
+	}
+	return controllerName
+}
+
+func newRecorder(k8s kubernetes.Interface) (record.EventRecorder, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, fmt.Errorf("failed to add client-go scheme: %w", err)
+	}
+	if err := ciliumv2.AddToScheme(scheme); err != nil {
+		return nil, fmt.Errorf("failed to add cilium scheme: %w", err)
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: k8s.CoreV1().Events(""),
+	})
+	return broadcaster.NewRecorder(scheme, corev1.EventSource{
+		Component: controllerName,
+	}), nil
+}
+
 func (c *Context) OnLeader(f func(ctx context.Context) error) {
 	c.leadership.OnLeader(f)
 }
@@ -115,6 +287,16 @@ func (c *Context) Run(ctx context.Context) {
 	logrus.Infof("Waiting for pod becomes leader")
 }
 
+// SyncCaches starts the informer caches of the requested resources and waits
+// for them to be synced without starting the handlers, used by the
+// components running on all operator pods.
+func (c *Context) SyncCaches(ctx context.Context) error {
+	c.controllerLock.Lock()
+	defer c.controllerLock.Unlock()
+
+	return start.Sync(ctx, c.starters...)
+}
+
 func (c *Context) StartHandler(ctx context.Context, worker int) error {
 	c.controllerLock.Lock()
 	defer c.controllerLock.Unlock()
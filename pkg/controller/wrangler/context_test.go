@@ -0,0 +1,105 @@
+package wrangler
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// clearElectionEnv unsets the leader election env for the test, the env is
+// restored on cleanup.
+func clearElectionEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range []string{devModeEnvKey, leaseDurationEnvKey, renewDeadlineEnvKey, retryPeriodEnvKey} {
+		t.Setenv(key, "")
+	}
+}
+
+func TestSetElectionEnv(t *testing.T) {
+	clearElectionEnv(t)
+	t.Setenv(retryPeriodEnvKey, "3s")
+
+	opts := Options{LeaseDuration: time.Minute, RenewDeadline: time.Second * 40}
+	if err := setElectionEnv(opts); err != nil {
+		t.Fatalf("setElectionEnv() error = %v", err)
+	}
+	for key, want := range map[string]string{
+		leaseDurationEnvKey: "1m0s",
+		renewDeadlineEnvKey: "40s",
+		// The unset option keeps the env.
+		retryPeriodEnvKey: "3s",
+	} {
+		if got := os.Getenv(key); got != want {
+			t.Errorf("env %v = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestValidateElection(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		opts    Options
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name: "options",
+			opts: Options{LeaseDuration: time.Second * 15, RenewDeadline: time.Second * 10, RetryPeriod: time.Second},
+		},
+		{
+			name:    "negative option",
+			opts:    Options{RetryPeriod: -time.Second},
+			wantErr: true,
+		},
+		{
+			name:    "renew deadline over the default lease duration",
+			opts:    Options{RenewDeadline: time.Minute},
+			wantErr: true,
+		},
+		{
+			name: "renew deadline under the env lease duration",
+			env:  map[string]string{leaseDurationEnvKey: "2m"},
+			opts: Options{RenewDeadline: time.Minute},
+		},
+		{
+			name: "lease duration under the dev mode renew deadline",
+			env:  map[string]string{devModeEnvKey: "true"},
+			opts: Options{LeaseDuration: time.Minute},
+			// The dev mode renew deadline is 30h.
+			wantErr: true,
+		},
+		{
+			name: "dev mode defaults",
+			env:  map[string]string{devModeEnvKey: "true"},
+		},
+		{
+			name: "options override the env",
+			env:  map[string]string{retryPeriodEnvKey: "1m"},
+			opts: Options{RetryPeriod: time.Second},
+		},
+		{
+			name:    "env retry period over the renew deadline",
+			env:     map[string]string{retryPeriodEnvKey: "1m"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid env",
+			env:     map[string]string{leaseDurationEnvKey: "forever"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearElectionEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if err := ValidateElection(tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateElection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
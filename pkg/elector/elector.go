@@ -0,0 +1,319 @@
+package elector
+
+import (
+	"fmt"
+	"time"
+
+	corecontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/core/v1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type Options struct {
+	// MinDwell is the minimum time a gateway node must hold before
+	// policies move to another node again.
+	MinDwell time.Duration
+	// StabilizationDelay is the time a new source holder must keep the
+	// gateway before policies follow it.
+	StabilizationDelay time.Duration
+	// Failback is the preferred node failback policy.
+	Failback FailbackOptions
+	// CandidateSelector selects the nodes that can be the gateway node when
+	// the desired node is in maintenance.
+	CandidateSelector labels.Selector
+	// GatewayCount is the number of the gateway nodes selected by the
+	// policies, only the leader node is selected if not greater than 1.
+	GatewayCount int
+}
+
+func (o *Options) candidateSelector() labels.Selector {
+	if o.CandidateSelector == nil {
+		return labels.Everything()
+	}
+	return o.CandidateSelector
+}
+
+// Elector selects the gateway node of a gateway group from the node desired
+// by the gateway source, applying the failback, maintenance and failover
+// damping policies.
+type Elector struct {
+	nodeCache corecontroller.NodeCache
+	group     *gateway.Group
+	opts      Options
+	// evaluate skips the metrics of the election on the standby pods.
+	evaluate bool
+}
+
+// Result is the result of the gateway node election.
+type Result struct {
+	// Changed is true if the gateway node of the group changed.
+	Changed bool
+	// Requeue is the time to re-evaluate the election, 0 if not needed.
+	Requeue time.Duration
+}
+
+func (r *Result) requeueAfter(d time.Duration) {
+	if d > 0 && (r.Requeue == 0 || d < r.Requeue) {
+		r.Requeue = d
+	}
+}
+
+func New(nodeCache corecontroller.NodeCache, group *gateway.Group, opts Options) *Elector {
+	return &Elector{
+		nodeCache: nodeCache,
+		group:     group,
+		opts:      opts,
+	}
+}
+
+// CandidateNode returns true if the node can be selected as the gateway node.
+func (e *Elector) CandidateNode(node *corev1.Node) bool {
+	if node == nil || node.DeletionTimestamp != nil {
+		return false
+	}
+	return e.opts.candidateSelector().Matches(labels.Set(node.Labels))
+}
+
+// PreferredNode returns true if the node is a preferred failback node.
+func (e *Elector) PreferredNode(name string) bool {
+	return e.opts.Failback.preferred(name)
+}
+
+// CandidateNodes lists the candidate nodes of the gateway group.
+func (e *Elector) CandidateNodes() ([]*corev1.Node, error) {
+	nodes, err := e.nodeCache.List(e.opts.candidateSelector())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes from cache: %w", err)
+	}
+	return nodes, nil
+}
+
+// Elect updates the gateway node of the group by the holder node desired by
+// the gateway source, or by the preferred failback node, and refreshes the
+// gateway nodes of multi-gateway policies.
+func (e *Elector) Elect(holder, reason string, fields logrus.Fields) (Result, error) {
+	result, err := e.electLeader(holder, reason, fields)
+	if err != nil {
+		return result, err
+	}
+	changed, err := e.updateGatewayNodes(fields)
+	if err != nil {
+		return result, err
+	}
+	result.Changed = result.Changed || changed
+	return result, nil
+}
+
+// Evaluate elects the gateway node like Elect without updating the metrics,
+// used by the standby operator pods.
+func (e *Elector) Evaluate(holder, reason string, fields logrus.Fields) (Result, error) {
+	ee := *e
+	ee.evaluate = true
+	return ee.Elect(holder, reason, fields)
+}
+
+func (e *Elector) electLeader(holder, reason string, fields logrus.Fields) (Result, error) {
+	var result Result
+	nodeName := holder
+	// Planned moves are not caused by the node failure, the policies can be
+	// moved after the connection drain period.
+	planned := false
+	preferredNode, requeue := e.failbackNode()
+	result.requeueAfter(requeue)
+	if preferredNode != "" {
+		nodeName = preferredNode
+		reason = "preferred failback node"
+		planned = true
+	}
+	if nodeName == "" {
+		// Without the source holder, only re-elect when the current gateway
+		// node is deleted.
+		leader := e.group.LeaderNode()
+		if leader == "" {
+			return result, nil
+		}
+		exists, err := e.hostnameExists(leader)
+		if err != nil || exists {
+			return result, err
+		}
+		nodeName = leader
+	}
+
+	node, err := e.nodeCache.Get(nodeName)
+	deleted := apierrors.IsNotFound(err)
+	if err != nil && !deleted {
+		return result, fmt.Errorf("failed to get node from cache: %w", err)
+	}
+	// The node failure and maintenance moves are not delayed by the
+	// failover damping.
+	immediate := false
+	failed := deleted || NodeFailed(node)
+	maintenance := !deleted && NodeInMaintenance(node)
+	if failed || maintenance {
+		state := "in maintenance"
+		switch {
+		case deleted:
+			state = "deleted"
+		case failed:
+			state = "not ready"
+		}
+		fallback, err := e.FallbackNode(nodeName)
+		if err != nil {
+			return result, err
+		}
+		switch {
+		case fallback == "" && deleted:
+			// The leader is kept unless its own node is deleted, the
+			// deleted holder may be another node.
+			leader := e.group.LeaderNode()
+			exists, err := e.hostnameExists(leader)
+			if err != nil {
+				return result, err
+			}
+			if leader != "" && !exists {
+				logrus.WithFields(fields).
+					WithFields(logrus.Fields{utils.FieldNode: leader, utils.FieldReason: state}).
+					Warnf("Gateway node [%v] is deleted and no other healthy candidate node available, clear the gateway", leader)
+				e.group.ClearLeaderNode()
+				result.Changed = true
+			}
+			return result, nil
+		case fallback == "":
+			logrus.WithFields(fields).
+				WithFields(logrus.Fields{utils.FieldNode: nodeName, utils.FieldReason: state}).
+				Warnf("Node [%v] is %v but no other healthy candidate node available", nodeName, state)
+		default:
+			if fallback != nodeName {
+				logrus.WithFields(fields).
+					Debugf("Node [%v] is %v, use node [%v] instead", nodeName, state, fallback)
+			}
+			nodeName = fallback
+			if failed {
+				reason = "gateway node during node failure"
+				planned = false
+			} else {
+				reason = "gateway node during maintenance"
+				planned = true
+			}
+			immediate = true
+			if node, err = e.nodeCache.Get(nodeName); err != nil {
+				return result, fmt.Errorf("failed to get node from cache: %w", err)
+			}
+		}
+	}
+	ip := utils.NodeIP(node)
+	hostname := utils.NodeHostname(node)
+	// The leader is recorded by the node hostname, which may differ from the
+	// node name.
+	if hostname != "" && e.group.LeaderNode() == hostname {
+		e.group.ResetCandidate()
+		// The leader node IP may change (e.g. DHCP renewal) while the node
+		// keeps the gateway.
+		if oldIP := e.group.LeaderNodeIP(); e.group.SetLeaderNodeIP(ip) {
+			logrus.WithFields(fields).
+				WithFields(logrus.Fields{
+					utils.FieldNode:   nodeName,
+					utils.FieldOldIP:  oldIP,
+					utils.FieldNewIP:  ip,
+					utils.FieldReason: "node IP changed",
+				}).
+				Infof("Gateway node [%v] IP changed from [%v] to [%v]", nodeName, oldIP, ip)
+			result.Changed = true
+		}
+		return result, nil
+	}
+	if ip == "" || hostname == "" {
+		logrus.WithFields(fields).Warnf("Failed to get IP/hostname from node %q", nodeName)
+		return result, nil
+	}
+	if delay := e.dampingDelay(hostname); delay > 0 && !immediate {
+		// The move is counted and logged once per deferred node, the
+		// requeues until the damping delay passes are logged at debug level.
+		first := !e.evaluate && e.group.Damp(hostname)
+		entry := logrus.WithFields(fields).
+			WithFields(logrus.Fields{utils.FieldNode: hostname, utils.FieldReason: "failover damping"})
+		logf := entry.Debugf
+		if first {
+			logf = entry.Infof
+			metrics.GatewayTransitionsDamped.Inc()
+		}
+		logf("Defer moving gateway from [%v] to [%v] for %v by failover damping",
+			e.group.LeaderNode(), hostname, delay.Round(time.Second))
+		result.requeueAfter(delay)
+		return result, nil
+	}
+	logrus.WithFields(fields).
+		WithFields(logrus.Fields{
+			utils.FieldNode:   nodeName,
+			utils.FieldOldIP:  e.group.LeaderNodeIP(),
+			utils.FieldNewIP:  ip,
+			utils.FieldReason: reason,
+		}).
+		Infof("Node [%v] IP [%v] is %v", nodeName, ip, reason)
+	e.group.SetLeaderNode(ip, hostname, planned)
+	if !e.evaluate {
+		metrics.GatewayTransitions.Inc()
+	}
+	result.Changed = true
+	return result, nil
+}
+
+// hostnameExists returns true if a node with the hostname label exists in the
+// cache, the gateway nodes are recorded by hostname instead of node name.
+func (e *Elector) hostnameExists(hostname string) (bool, error) {
+	if hostname == "" {
+		return false, nil
+	}
+	nodes, err := e.nodeCache.List(labels.SelectorFromSet(labels.Set{
+		utils.HostnameLabelKey: hostname,
+	}))
+	if err != nil {
+		return false, fmt.Errorf("failed to list nodes from cache: %w", err)
+	}
+	return len(nodes) > 0, nil
+}
+
+// dampingDelay returns the remaining time to wait before moving the gateway
+// to the new node, returns 0 if the gateway can be moved immediately.
+func (e *Elector) dampingDelay(hostname string) time.Duration {
+	st := e.group.Snapshot()
+	if st.LeaderNode == "" {
+		// Always follow the source holder if no gateway is selected.
+		return 0
+	}
+
+	now := time.Now()
+	var delay time.Duration
+	if e.opts.MinDwell > 0 {
+		if d := st.LastTransitionTime.Add(e.opts.MinDwell).Sub(now); d > delay {
+			delay = d
+		}
+	}
+	if e.opts.StabilizationDelay > 0 {
+		since := e.group.ObserveCandidate(hostname)
+		if d := since.Add(e.opts.StabilizationDelay).Sub(now); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// NodeReady returns whether the node is ready and the time since it
+// became ready.
+func NodeReady(node *corev1.Node) (bool, time.Time) {
+	if node == nil {
+		return false, time.Time{}
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type != corev1.NodeReady {
+			continue
+		}
+		return c.Status == corev1.ConditionTrue, c.LastTransitionTime.Time
+	}
+	return false, time.Time{}
+}
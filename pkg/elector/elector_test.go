@@ -0,0 +1,323 @@
+package elector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/fake"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/metrics"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// readyNode returns a ready node with the hostname label and the provided
+// node IP, ready since the given time.
+func readyNode(name, ip string, since time.Time) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{utils.HostnameLabelKey: name},
+			Annotations: map[string]string{utils.ProvidedNodeIPAnnotationKey: ip},
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{
+				Type:               corev1.NodeReady,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(since),
+			}},
+		},
+	}
+}
+
+// hostnameNode sets the hostname label of the node different from the node
+// name.
+func hostnameNode(node *corev1.Node, hostname string) *corev1.Node {
+	node.Labels[utils.HostnameLabelKey] = hostname
+	return node
+}
+
+func notReady(node *corev1.Node) *corev1.Node {
+	node.Status.Conditions[0].Status = corev1.ConditionFalse
+	return node
+}
+
+func cordoned(node *corev1.Node) *corev1.Node {
+	node.Spec.Unschedulable = true
+	return node
+}
+
+func newGroup(t *testing.T, state gateway.State) *gateway.Group {
+	t.Helper()
+	g := gateway.For(t.Name())
+	t.Cleanup(func() { gateway.Delete(t.Name()) })
+	g.Restore(state)
+	return g
+}
+
+// electTest is a gateway election case of a group with the given state.
+type electTest struct {
+	name        string
+	nodes       []*corev1.Node
+	opts        Options
+	state       gateway.State
+	holder      string
+	wantLeader  string
+	wantChanged bool
+	wantPlanned bool
+	wantRequeue bool
+}
+
+func runElectTests(t *testing.T, tests []electTest) {
+	t.Helper()
+	logrus.SetLevel(logrus.PanicLevel)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newGroup(t, tt.state)
+			e := New(fake.NewNodeCache(tt.nodes...), g, tt.opts)
+			result, err := e.Elect(tt.holder, "test", logrus.Fields{})
+			if err != nil {
+				t.Fatalf("Elect() error = %v", err)
+			}
+			if result.Changed != tt.wantChanged {
+				t.Errorf("Elect() changed = %v, want %v", result.Changed, tt.wantChanged)
+			}
+			if (result.Requeue > 0) != tt.wantRequeue {
+				t.Errorf("Elect() requeue = %v, want requeue %v", result.Requeue, tt.wantRequeue)
+			}
+			if got := g.LeaderNode(); got != tt.wantLeader {
+				t.Errorf("LeaderNode() = %q, want %q", got, tt.wantLeader)
+			}
+			if tt.wantChanged && tt.wantLeader != tt.state.LeaderNode {
+				if got := g.Planned(); got != tt.wantPlanned {
+					t.Errorf("Planned() = %v, want %v", got, tt.wantPlanned)
+				}
+			}
+		})
+	}
+}
+
+func TestElect(t *testing.T) {
+	longAgo := time.Now().Add(-time.Hour)
+
+	runElectTests(t, []electTest{
+		{
+			name:        "first holder",
+			nodes:       []*corev1.Node{readyNode("node-1", "10.0.0.1", longAgo)},
+			holder:      "node-1",
+			wantLeader:  "node-1",
+			wantChanged: true,
+		},
+		{
+			name: "same holder",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", longAgo),
+			},
+			state:      gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: longAgo},
+			holder:     "node-1",
+			wantLeader: "node-1",
+		},
+		{
+			name: "leader node IP changed",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.11", longAgo),
+			},
+			state:       gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: longAgo},
+			holder:      "node-1",
+			wantLeader:  "node-1",
+			wantChanged: true,
+		},
+		{
+			name: "holder changed without damping",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", longAgo),
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			state:       gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: time.Now()},
+			holder:      "node-2",
+			wantLeader:  "node-2",
+			wantChanged: true,
+		},
+		{
+			name: "min dwell defers the move",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", longAgo),
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			opts:        Options{MinDwell: time.Minute},
+			state:       gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: time.Now()},
+			holder:      "node-2",
+			wantLeader:  "node-1",
+			wantRequeue: true,
+		},
+		{
+			name: "min dwell passed",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", longAgo),
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			opts:        Options{MinDwell: time.Minute},
+			state:       gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: longAgo},
+			holder:      "node-2",
+			wantLeader:  "node-2",
+			wantChanged: true,
+		},
+		{
+			name: "stabilization delay defers the move",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", longAgo),
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			opts:        Options{StabilizationDelay: time.Minute},
+			state:       gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: longAgo},
+			holder:      "node-2",
+			wantLeader:  "node-1",
+			wantRequeue: true,
+		},
+		{
+			name: "failed holder moves immediately",
+			nodes: []*corev1.Node{
+				notReady(readyNode("node-1", "10.0.0.1", longAgo)),
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			opts:        Options{MinDwell: time.Minute},
+			state:       gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: time.Now()},
+			holder:      "node-1",
+			wantLeader:  "node-2",
+			wantChanged: true,
+		},
+		{
+			name: "deleted gateway without holder is cleared",
+			nodes: []*corev1.Node{
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			opts: Options{
+				CandidateSelector: labels.SelectorFromSet(labels.Set{"gateway": "true"}),
+			},
+			state:       gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: longAgo},
+			wantChanged: true,
+		},
+		{
+			name: "leader hostname differs from the node name",
+			nodes: []*corev1.Node{
+				hostnameNode(readyNode("ip-10-0-0-1", "10.0.0.1", longAgo), "node-1"),
+			},
+			state:      gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: longAgo},
+			wantLeader: "node-1",
+		},
+		{
+			name: "same holder with the hostname differs from the node name",
+			nodes: []*corev1.Node{
+				hostnameNode(readyNode("ip-10-0-0-1", "10.0.0.1", longAgo), "node-1"),
+			},
+			state:      gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: longAgo},
+			holder:     "ip-10-0-0-1",
+			wantLeader: "node-1",
+		},
+		{
+			name: "deleted holder is not the leader node",
+			nodes: []*corev1.Node{
+				hostnameNode(notReady(readyNode("ip-10-0-0-1", "10.0.0.1", longAgo)), "node-1"),
+			},
+			state:      gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: longAgo},
+			holder:     "ip-10-0-0-2",
+			wantLeader: "node-1",
+		},
+	})
+}
+
+func TestElectDampedMetrics(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+	longAgo := time.Now().Add(-time.Hour)
+	nodes := fake.NewNodeCache(
+		readyNode("node-1", "10.0.0.1", longAgo),
+		readyNode("node-2", "10.0.0.2", longAgo),
+		readyNode("node-3", "10.0.0.3", longAgo),
+	)
+	opts := Options{MinDwell: time.Minute}
+
+	tests := []struct {
+		name            string
+		evaluate        bool
+		holders         []string
+		wantDamped      float64
+		wantTransitions float64
+	}{
+		{
+			name:       "counted once per deferred node",
+			holders:    []string{"node-2", "node-2", "node-2"},
+			wantDamped: 1,
+		},
+		{
+			name:       "counted again for another node",
+			holders:    []string{"node-2", "node-3", "node-3"},
+			wantDamped: 2,
+		},
+		{
+			name:     "not counted on evaluation",
+			evaluate: true,
+			holders:  []string{"node-2", "node-3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newGroup(t, gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: time.Now()})
+			e := New(nodes, g, opts)
+			damped := testutil.ToFloat64(metrics.GatewayTransitionsDamped)
+			transitions := testutil.ToFloat64(metrics.GatewayTransitions)
+			for _, holder := range tt.holders {
+				elect := e.Elect
+				if tt.evaluate {
+					elect = e.Evaluate
+				}
+				if _, err := elect(holder, "test", logrus.Fields{}); err != nil {
+					t.Fatalf("Elect() error = %v", err)
+				}
+			}
+			if got := testutil.ToFloat64(metrics.GatewayTransitionsDamped) - damped; got != tt.wantDamped {
+				t.Errorf("damped transitions = %v, want %v", got, tt.wantDamped)
+			}
+			if got := testutil.ToFloat64(metrics.GatewayTransitions) - transitions; got != tt.wantTransitions {
+				t.Errorf("transitions = %v, want %v", got, tt.wantTransitions)
+			}
+		})
+	}
+}
+
+func TestEvaluateTransitionMetrics(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+	nodes := fake.NewNodeCache(readyNode("node-1", "10.0.0.1", time.Now().Add(-time.Hour)))
+
+	tests := []struct {
+		name     string
+		evaluate bool
+		want     float64
+	}{
+		{name: "elect", want: 1},
+		{name: "evaluate", evaluate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newGroup(t, gateway.State{})
+			e := New(nodes, g, Options{})
+			elect := e.Elect
+			if tt.evaluate {
+				elect = e.Evaluate
+			}
+			before := testutil.ToFloat64(metrics.GatewayTransitions)
+			if _, err := elect("node-1", "test", logrus.Fields{}); err != nil {
+				t.Fatalf("Elect() error = %v", err)
+			}
+			if got := g.LeaderNode(); got != "node-1" {
+				t.Errorf("LeaderNode() = %q, want %q", got, "node-1")
+			}
+			if got := testutil.ToFloat64(metrics.GatewayTransitions) - before; got != tt.want {
+				t.Errorf("transitions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
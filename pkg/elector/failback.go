@@ -0,0 +1,76 @@
+package elector
+
+import (
+	"slices"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// FailbackModeNever keeps following the gateway source holder.
+	FailbackModeNever = "never"
+	// FailbackModeImmediate moves the gateway back to the preferred node
+	// once it becomes ready.
+	FailbackModeImmediate = "immediate"
+	// FailbackModeDelayed moves the gateway back to the preferred node
+	// after it keeps ready for the failback delay.
+	FailbackModeDelayed = "delayed"
+)
+
+type FailbackOptions struct {
+	Mode           string
+	Delay          time.Duration
+	PreferredNodes []string
+}
+
+func ValidFailbackMode(mode string) bool {
+	switch mode {
+	case FailbackModeNever, FailbackModeImmediate, FailbackModeDelayed:
+		return true
+	}
+	return false
+}
+
+func (o *FailbackOptions) enabled() bool {
+	return o.Mode != "" && o.Mode != FailbackModeNever && len(o.PreferredNodes) > 0
+}
+
+func (o *FailbackOptions) preferred(nodeName string) bool {
+	return o.enabled() && slices.Contains(o.PreferredNodes, nodeName)
+}
+
+// failbackNode returns the preferred node the gateway should fail back to,
+// returns an empty string if the gateway should follow the source holder.
+// The returned duration is the time to re-evaluate the delayed failback.
+func (e *Elector) failbackNode() (string, time.Duration) {
+	if !e.opts.Failback.enabled() {
+		return "", 0
+	}
+
+	now := time.Now()
+	var requeue time.Duration
+	for _, name := range e.opts.Failback.PreferredNodes {
+		node, err := e.nodeCache.Get(name)
+		if err != nil {
+			logrus.Debugf("Skip preferred node [%v]: %v", name, err)
+			continue
+		}
+		ready, since := NodeReady(node)
+		if !ready {
+			continue
+		}
+		// The node already holding the gateway does not need to wait.
+		if e.opts.Failback.Mode == FailbackModeDelayed && utils.NodeHostname(node) != e.group.LeaderNode() {
+			if d := since.Add(e.opts.Failback.Delay).Sub(now); d > 0 {
+				if requeue == 0 || d < requeue {
+					requeue = d
+				}
+				continue
+			}
+		}
+		return name, requeue
+	}
+	return "", requeue
+}
@@ -0,0 +1,94 @@
+package elector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestElectFailback(t *testing.T) {
+	longAgo := time.Now().Add(-time.Hour)
+
+	runElectTests(t, []electTest{
+		{
+			name: "immediate failback",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", time.Now()),
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			opts: Options{
+				Failback: FailbackOptions{Mode: FailbackModeImmediate, PreferredNodes: []string{"node-1"}},
+			},
+			state:       gateway.State{LeaderNode: "node-2", LeaderNodeIP: "10.0.0.2", LastTransitionTime: longAgo},
+			holder:      "node-2",
+			wantLeader:  "node-1",
+			wantChanged: true,
+			wantPlanned: true,
+		},
+		{
+			name: "delayed failback waits for the preferred node",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", time.Now()),
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			opts: Options{
+				Failback: FailbackOptions{
+					Mode:           FailbackModeDelayed,
+					Delay:          time.Minute,
+					PreferredNodes: []string{"node-1"},
+				},
+			},
+			state:       gateway.State{LeaderNode: "node-2", LeaderNodeIP: "10.0.0.2", LastTransitionTime: longAgo},
+			holder:      "node-2",
+			wantLeader:  "node-2",
+			wantRequeue: true,
+		},
+		{
+			name: "delayed failback after the delay",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", longAgo),
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			opts: Options{
+				Failback: FailbackOptions{
+					Mode:           FailbackModeDelayed,
+					Delay:          time.Minute,
+					PreferredNodes: []string{"node-1"},
+				},
+			},
+			state:       gateway.State{LeaderNode: "node-2", LeaderNodeIP: "10.0.0.2", LastTransitionTime: longAgo},
+			holder:      "node-2",
+			wantLeader:  "node-1",
+			wantChanged: true,
+			wantPlanned: true,
+		},
+		{
+			name: "failback never follows the holder",
+			nodes: []*corev1.Node{
+				readyNode("node-1", "10.0.0.1", longAgo),
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			opts: Options{
+				Failback: FailbackOptions{Mode: FailbackModeNever, PreferredNodes: []string{"node-1"}},
+			},
+			state:      gateway.State{LeaderNode: "node-2", LeaderNodeIP: "10.0.0.2", LastTransitionTime: longAgo},
+			holder:     "node-2",
+			wantLeader: "node-2",
+		},
+		{
+			name: "preferred node in maintenance is skipped",
+			nodes: []*corev1.Node{
+				cordoned(readyNode("node-1", "10.0.0.1", longAgo)),
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			opts: Options{
+				Failback: FailbackOptions{Mode: FailbackModeImmediate, PreferredNodes: []string{"node-1"}},
+			},
+			state:      gateway.State{LeaderNode: "node-2", LeaderNodeIP: "10.0.0.2", LastTransitionTime: longAgo},
+			holder:     "node-2",
+			wantLeader: "node-2",
+		},
+	})
+}
@@ -0,0 +1,78 @@
+package elector
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// failureTaints are the taints added by the node lifecycle controller or the
+// cluster admin when the node fails.
+var failureTaints = []string{
+	corev1.TaintNodeNotReady,
+	corev1.TaintNodeUnreachable,
+	corev1.TaintNodeOutOfService,
+}
+
+// NodeInMaintenance returns true if the node is cordoned or annotated with
+// the maintenance annotation.
+func NodeInMaintenance(node *corev1.Node) bool {
+	if node == nil {
+		return false
+	}
+	if node.Spec.Unschedulable {
+		return true
+	}
+	return node.Annotations[utils.MaintenanceAnnotation] == utils.MaintenanceAnnotationValue
+}
+
+// NodeFailed returns true if the node is not ready, or tainted as not-ready,
+// unreachable or out-of-service.
+func NodeFailed(node *corev1.Node) bool {
+	if ready, _ := NodeReady(node); !ready {
+		return true
+	}
+	return slices.ContainsFunc(node.Spec.Taints, func(t corev1.Taint) bool {
+		return slices.Contains(failureTaints, t.Key)
+	})
+}
+
+// HealthyNode returns true if the node is ready, not in maintenance and has
+// the IP and hostname available.
+func HealthyNode(node *corev1.Node) bool {
+	if NodeFailed(node) {
+		return false
+	}
+	if NodeInMaintenance(node) {
+		return false
+	}
+	return utils.NodeIP(node) != "" && utils.NodeHostname(node) != ""
+}
+
+// FallbackNode returns a healthy candidate node other than the excluded one.
+// The current gateway node is preferred to avoid moving policies again,
+// otherwise the first node sorted by name is returned.
+func (e *Elector) FallbackNode(exclude string) (string, error) {
+	nodes, err := e.nodeCache.List(e.opts.candidateSelector())
+	if err != nil {
+		return "", fmt.Errorf("failed to list nodes from cache: %w", err)
+	}
+	nodes = slices.DeleteFunc(nodes, func(n *corev1.Node) bool {
+		return n.Name == exclude || !e.CandidateNode(n) || !HealthyNode(n)
+	})
+	if len(nodes) == 0 {
+		return "", nil
+	}
+	for _, n := range nodes {
+		if utils.NodeHostname(n) == e.group.LeaderNode() {
+			return n.Name, nil
+		}
+	}
+	slices.SortFunc(nodes, func(a, b *corev1.Node) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return nodes[0].Name, nil
+}
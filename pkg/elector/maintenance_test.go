@@ -0,0 +1,56 @@
+package elector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestElectMaintenance(t *testing.T) {
+	longAgo := time.Now().Add(-time.Hour)
+
+	runElectTests(t, []electTest{
+		{
+			name: "maintenance is a planned move",
+			nodes: []*corev1.Node{
+				cordoned(readyNode("node-1", "10.0.0.1", longAgo)),
+				readyNode("node-2", "10.0.0.2", longAgo),
+				readyNode("node-3", "10.0.0.3", longAgo),
+			},
+			opts:        Options{MinDwell: time.Minute},
+			state:       gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: time.Now()},
+			holder:      "node-1",
+			wantLeader:  "node-2",
+			wantChanged: true,
+			wantPlanned: true,
+		},
+		{
+			name: "maintenance keeps the current healthy gateway",
+			nodes: []*corev1.Node{
+				cordoned(readyNode("node-1", "10.0.0.1", longAgo)),
+				readyNode("node-2", "10.0.0.2", longAgo),
+				readyNode("node-3", "10.0.0.3", longAgo),
+			},
+			state:      gateway.State{LeaderNode: "node-3", LeaderNodeIP: "10.0.0.3", LastTransitionTime: longAgo},
+			holder:     "node-1",
+			wantLeader: "node-3",
+		},
+		{
+			name: "maintenance without other candidate keeps the node",
+			nodes: []*corev1.Node{
+				cordoned(readyNode("node-1", "10.0.0.1", longAgo)),
+				readyNode("node-2", "10.0.0.2", longAgo),
+			},
+			opts: Options{
+				CandidateSelector: labels.SelectorFromSet(labels.Set{utils.HostnameLabelKey: "node-1"}),
+			},
+			state:      gateway.State{LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: longAgo},
+			holder:     "node-1",
+			wantLeader: "node-1",
+		},
+	})
+}
@@ -0,0 +1,79 @@
+package elector
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// gatewayNodes returns the ordered gateway node hostnames of multi-gateway
+// policies: the leader node first, then the healthy candidate nodes already
+// selected to avoid moving policies again, then the preferred nodes and the
+// other healthy candidate nodes sorted by name.
+func (e *Elector) gatewayNodes() ([]string, error) {
+	leader := e.group.LeaderNode()
+	if e.opts.GatewayCount <= 1 || leader == "" {
+		return nil, nil
+	}
+	nodes, err := e.nodeCache.List(e.opts.candidateSelector())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes from cache: %w", err)
+	}
+	nodes = slices.DeleteFunc(nodes, func(n *corev1.Node) bool {
+		return !e.CandidateNode(n) || !HealthyNode(n) || utils.NodeHostname(n) == leader
+	})
+	current := e.group.GatewayNodes()
+	rank := func(n *corev1.Node) (int, int) {
+		if i := slices.Index(current, utils.NodeHostname(n)); i >= 0 {
+			return 0, i
+		}
+		if e.opts.Failback.preferred(n.Name) {
+			return 1, slices.Index(e.opts.Failback.PreferredNodes, n.Name)
+		}
+		return 2, 0
+	}
+	slices.SortFunc(nodes, func(a, b *corev1.Node) int {
+		ta, ia := rank(a)
+		tb, ib := rank(b)
+		if ta != tb {
+			return ta - tb
+		}
+		if ia != ib {
+			return ia - ib
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	hostnames := []string{leader}
+	for _, n := range nodes {
+		if len(hostnames) >= e.opts.GatewayCount {
+			break
+		}
+		hostnames = append(hostnames, utils.NodeHostname(n))
+	}
+	return hostnames, nil
+}
+
+// updateGatewayNodes refreshes the gateway nodes of the group, returns true
+// if the gateway nodes changed.
+func (e *Elector) updateGatewayNodes(fields logrus.Fields) (bool, error) {
+	hostnames, err := e.gatewayNodes()
+	if err != nil {
+		return false, err
+	}
+	if !e.group.SetGatewayNodes(hostnames) {
+		return false, nil
+	}
+	if len(hostnames) > 0 {
+		logrus.WithFields(fields).Infof("Gateway nodes are %v", hostnames)
+	}
+	if len(hostnames) > 0 && len(hostnames) < e.opts.GatewayCount {
+		logrus.WithFields(fields).
+			Warnf("Only %d of %d gateway nodes are healthy", len(hostnames), e.opts.GatewayCount)
+	}
+	return true, nil
+}
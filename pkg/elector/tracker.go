@@ -0,0 +1,76 @@
+package elector
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NodeState is the node fields affecting the gateway election.
+type NodeState struct {
+	Hostname    string
+	IP          string
+	Ready       bool
+	Taints      string
+	Maintenance bool
+	Labels      string
+}
+
+// NewNodeState returns the election state of the node.
+func NewNodeState(node *corev1.Node) NodeState {
+	ready, _ := NodeReady(node)
+	taints := make([]string, 0, len(node.Spec.Taints))
+	for _, t := range node.Spec.Taints {
+		taints = append(taints, fmt.Sprintf("%v:%v", t.Key, t.Effect))
+	}
+	slices.Sort(taints)
+	return NodeState{
+		Hostname:    utils.NodeHostname(node),
+		IP:          utils.NodeIP(node),
+		Ready:       ready,
+		Taints:      strings.Join(taints, ","),
+		Maintenance: NodeInMaintenance(node),
+		Labels:      labels.Set(node.Labels).String(),
+	}
+}
+
+// NodeTracker records the last observed election state of the nodes, so the
+// node handlers can resolve the hostname of the deleted nodes.
+type NodeTracker struct {
+	mu    sync.Mutex
+	nodes map[string]NodeState
+}
+
+func NewNodeTracker() *NodeTracker {
+	return &NodeTracker{
+		nodes: make(map[string]NodeState),
+	}
+}
+
+// Observe records the state of the node, returns true if the node is
+// observed for the first time or its state changed.
+func (t *NodeTracker) Observe(node *corev1.Node) bool {
+	current := NewNodeState(node)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last, ok := t.nodes[node.Name]
+	t.nodes[node.Name] = current
+	return !ok || last != current
+}
+
+// Delete removes the node, returns the last observed state of the node.
+func (t *NodeTracker) Delete(name string) (NodeState, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last, ok := t.nodes[name]
+	delete(t.nodes, name)
+	return last, ok
+}
@@ -0,0 +1,99 @@
+package elector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeTrackerObserve(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(n *corev1.Node)
+		want   bool
+	}{
+		{
+			name: "heartbeat",
+			update: func(n *corev1.Node) {
+				n.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+				n.ResourceVersion = "2"
+			},
+		},
+		{
+			name:   "not ready",
+			update: func(n *corev1.Node) { notReady(n) },
+			want:   true,
+		},
+		{
+			name: "tainted",
+			update: func(n *corev1.Node) {
+				n.Spec.Taints = []corev1.Taint{{Key: corev1.TaintNodeUnreachable, Effect: corev1.TaintEffectNoExecute}}
+			},
+			want: true,
+		},
+		{
+			name: "maintenance annotation",
+			update: func(n *corev1.Node) {
+				n.Annotations[utils.MaintenanceAnnotation] = utils.MaintenanceAnnotationValue
+			},
+			want: true,
+		},
+		{
+			name:   "cordoned",
+			update: func(n *corev1.Node) { cordoned(n) },
+			want:   true,
+		},
+		{
+			name: "node IP changed",
+			update: func(n *corev1.Node) {
+				n.Annotations[utils.ProvidedNodeIPAnnotationKey] = "10.0.0.11"
+			},
+			want: true,
+		},
+		{
+			name: "candidate label added",
+			update: func(n *corev1.Node) {
+				n.Labels["gateway"] = "true"
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewNodeTracker()
+			node := readyNode("node-1", "10.0.0.1", time.Now().Add(-time.Hour))
+			if !tracker.Observe(node) {
+				t.Fatal("Observe() of the new node = false, want true")
+			}
+			node = node.DeepCopy()
+			tt.update(node)
+			if got := tracker.Observe(node); got != tt.want {
+				t.Errorf("Observe() = %v, want %v", got, tt.want)
+			}
+			if tracker.Observe(node) {
+				t.Error("Observe() of the unchanged node = true, want false")
+			}
+		})
+	}
+}
+
+func TestNodeTrackerDelete(t *testing.T) {
+	tracker := NewNodeTracker()
+	node := readyNode("ip-10-0-0-1", "10.0.0.1", time.Now())
+	node.Labels[utils.HostnameLabelKey] = "node-1"
+	tracker.Observe(node)
+
+	last, ok := tracker.Delete(node.Name)
+	if !ok || last.Hostname != "node-1" {
+		t.Errorf("Delete() = %+v, %v, want hostname node-1", last, ok)
+	}
+	if _, ok := tracker.Delete(node.Name); ok {
+		t.Error("Delete() of the deleted node = true, want false")
+	}
+	if !tracker.Observe(node) {
+		t.Error("Observe() of the re-created node = false, want true")
+	}
+}
@@ -31,6 +31,7 @@ func init() {
 }
 
 type Interface interface {
+	ConfigMap() ConfigMapController
 	Node() NodeController
 	Pod() PodController
 	Secret() SecretController
@@ -46,6 +47,10 @@ type version struct {
 	controllerFactory controller.SharedControllerFactory
 }
 
+func (v *version) ConfigMap() ConfigMapController {
+	return generic.NewController[*v1.ConfigMap, *v1.ConfigMapList](schema.GroupVersionKind{Group: "", Version: "v1", Kind: "ConfigMap"}, "configmaps", true, v.controllerFactory)
+}
+
 func (v *version) Node() NodeController {
 	return generic.NewNonNamespacedController[*v1.Node, *v1.NodeList](schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Node"}, "nodes", v.controllerFactory)
 }
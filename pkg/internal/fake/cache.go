@@ -0,0 +1,149 @@
+// Package fake provides the in-memory informer caches used by the handler
+// tests in place of the wrangler generated caches.
+package fake
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Cache is the informer cache of the namespaced objects backed by a map,
+// the indexers are evaluated on each GetByIndex call.
+type Cache[T generic.RuntimeMetaObject] struct {
+	resource schema.GroupResource
+
+	mu       sync.RWMutex
+	objects  map[string]T
+	indexers map[string]generic.Indexer[T]
+}
+
+// NewCache returns the cache of the resource with the objects.
+func NewCache[T generic.RuntimeMetaObject](resource schema.GroupResource, objs ...T) *Cache[T] {
+	c := &Cache[T]{
+		resource: resource,
+		objects:  make(map[string]T, len(objs)),
+		indexers: make(map[string]generic.Indexer[T]),
+	}
+	c.Add(objs...)
+	return c
+}
+
+func key(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// Add adds or replaces the objects in the cache.
+func (c *Cache[T]) Add(objs ...T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, obj := range objs {
+		c.objects[key(obj.GetNamespace(), obj.GetName())] = obj
+	}
+}
+
+// Delete removes the object from the cache.
+func (c *Cache[T]) Delete(namespace, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.objects, key(namespace, name))
+}
+
+func (c *Cache[T]) Get(namespace, name string) (T, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	obj, ok := c.objects[key(namespace, name)]
+	if !ok {
+		return obj, apierrors.NewNotFound(c.resource, name)
+	}
+	return obj, nil
+}
+
+func (c *Cache[T]) List(namespace string, selector labels.Selector) ([]T, error) {
+	return c.list(func(obj T) bool {
+		return (namespace == "" || obj.GetNamespace() == namespace) &&
+			selector.Matches(labels.Set(obj.GetLabels()))
+	})
+}
+
+func (c *Cache[T]) AddIndexer(indexName string, indexer generic.Indexer[T]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.indexers[indexName] = indexer
+}
+
+func (c *Cache[T]) GetByIndex(indexName, indexKey string) ([]T, error) {
+	c.mu.RLock()
+	indexer, ok := c.indexers[indexName]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	var err error
+	objs, _ := c.list(func(obj T) bool {
+		keys, indexErr := indexer(obj)
+		if indexErr != nil {
+			err = indexErr
+		}
+		return slices.Contains(keys, indexKey)
+	})
+	return objs, err
+}
+
+// list returns the objects matched by the filter sorted by namespace and
+// name, as the tests compare the results in order.
+func (c *Cache[T]) list(filter func(T) bool) ([]T, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var objs []T
+	for _, obj := range c.objects {
+		if filter(obj) {
+			objs = append(objs, obj)
+		}
+	}
+	slices.SortFunc(objs, func(a, b T) int {
+		return strings.Compare(key(a.GetNamespace(), a.GetName()), key(b.GetNamespace(), b.GetName()))
+	})
+	return objs, nil
+}
+
+// NonNamespacedCache is the informer cache of the cluster scoped objects
+// backed by a map.
+type NonNamespacedCache[T generic.RuntimeMetaObject] struct {
+	*Cache[T]
+}
+
+// NewNonNamespacedCache returns the cache of the cluster scoped resource
+// with the objects.
+func NewNonNamespacedCache[T generic.RuntimeMetaObject](resource schema.GroupResource, objs ...T) *NonNamespacedCache[T] {
+	return &NonNamespacedCache[T]{NewCache(resource, objs...)}
+}
+
+// Delete removes the object from the cache.
+func (c *NonNamespacedCache[T]) Delete(name string) {
+	c.Cache.Delete("", name)
+}
+
+func (c *NonNamespacedCache[T]) Get(name string) (T, error) {
+	return c.Cache.Get("", name)
+}
+
+func (c *NonNamespacedCache[T]) List(selector labels.Selector) ([]T, error) {
+	return c.Cache.List("", selector)
+}
+
+// NewNodeCache returns the node cache with the nodes.
+func NewNodeCache(nodes ...*corev1.Node) *NonNamespacedCache[*corev1.Node] {
+	return NewNonNamespacedCache(corev1.Resource("nodes"), nodes...)
+}
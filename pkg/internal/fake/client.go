@@ -0,0 +1,39 @@
+package fake
+
+import (
+	corecontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConfigMapClient stores a single ConfigMap, used by the gateway state
+// store. The methods not used by the store panic.
+type ConfigMapClient struct {
+	corecontroller.ConfigMapClient
+
+	ConfigMap *corev1.ConfigMap
+	// Updates is the number of the ConfigMap updates.
+	Updates int
+}
+
+func (c *ConfigMapClient) Get(_, name string, _ metav1.GetOptions) (*corev1.ConfigMap, error) {
+	if c.ConfigMap == nil {
+		return nil, apierrors.NewNotFound(corev1.Resource("configmaps"), name)
+	}
+	return c.ConfigMap.DeepCopy(), nil
+}
+
+func (c *ConfigMapClient) Create(cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	if c.ConfigMap != nil {
+		return nil, apierrors.NewAlreadyExists(corev1.Resource("configmaps"), cm.Name)
+	}
+	c.ConfigMap = cm.DeepCopy()
+	return cm, nil
+}
+
+func (c *ConfigMapClient) Update(cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	c.ConfigMap = cm.DeepCopy()
+	c.Updates++
+	return cm, nil
+}
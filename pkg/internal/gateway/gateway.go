@@ -1,53 +1,432 @@
 package gateway
 
 import (
+	"maps"
+	"slices"
 	"sync"
+	"time"
 )
 
+// DefaultGroup is the name of the gateway group configured by the operator
+// flags, which manages the policies without the group annotation.
+const DefaultGroup = ""
+
+const maxTransitions = 10
+
+// State is the snapshot of the gateway store.
+type State struct {
+	LeaderNode         string    `json:"leaderNode,omitempty"`
+	LeaderNodeIP       string    `json:"leaderNodeIP,omitempty"`
+	PreviousNode       string    `json:"previousNode,omitempty"`
+	PreviousNodeIP     string    `json:"previousNodeIP,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime,omitempty"`
+	// Planned is true if the last transition is a planned move (maintenance
+	// or failback) rather than a node failure.
+	Planned bool `json:"planned,omitempty"`
+	// GatewayNodes is the ordered gateway node hostnames of multi-gateway
+	// policies, leader first, empty if the policies select the leader only.
+	GatewayNodes []string `json:"gatewayNodes,omitempty"`
+}
+
+// Group stores the gateway node of a gateway group.
+type Group struct {
+	name  string
+	state State
+
+	// candidateNode is the node waiting for the stabilization delay before
+	// becoming the leader node.
+	candidateNode  string
+	candidateSince time.Time
+	// dampedNode is the node whose move is deferred by the failover damping.
+	dampedNode string
+
+	// transitions records the recent gateway node transition times.
+	transitions []time.Time
+
+	// egressIPToNodeIP overrides the operator option to set the policy
+	// egressIP to the gateway node IP, nil follows the operator option.
+	egressIPToNodeIP *bool
+
+	mu *sync.RWMutex
+}
+
 type store struct {
-	leaderNode   string
-	leaderNodeIP string
+	groups map[string]*Group
+	// restored is the persisted states of the gateway groups not created
+	// yet, consumed when the group is created by the group controller.
+	restored map[string]State
 
 	mu *sync.RWMutex
 }
 
 var s = store{
+	groups: map[string]*Group{
+		DefaultGroup: newGroup(DefaultGroup),
+	},
 	mu: new(sync.RWMutex),
 }
 
-func (s *store) getLeaderNode() string {
+func newGroup(name string) *Group {
+	return &Group{
+		name: name,
+		mu:   new(sync.RWMutex),
+	}
+}
+
+func (s *store) getGroup(name string) (*Group, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return s.leaderNode
+	g, ok := s.groups[name]
+	return g, ok
 }
 
-func (s *store) getLeaderNodeIP() string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *store) getOrCreateGroup(name string) *Group {
+	if g, ok := s.getGroup(name); ok {
+		return g
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	return s.leaderNodeIP
+	if g, ok := s.groups[name]; ok {
+		return g
+	}
+	g := newGroup(name)
+	if state, ok := s.restored[name]; ok {
+		g.state = state
+		delete(s.restored, name)
+	}
+	s.groups[name] = g
+	return g
 }
 
-func (s *store) setLeaderNode(ip, hostname string) {
+func (s *store) restoreGroups(states map[string]State) []string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	var names []string
+	s.restored = make(map[string]State, len(states))
+	for name, state := range states {
+		if g, ok := s.groups[name]; ok {
+			if g.Restore(state) {
+				names = append(names, name)
+			}
+			continue
+		}
+		s.restored[name] = state
+		names = append(names, name)
+	}
+	return names
+}
+
+func (s *store) deleteGroup(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name == DefaultGroup {
+		return
+	}
+	delete(s.groups, name)
+}
+
+func (s *store) snapshots() map[string]State {
+	s.mu.RLock()
+	groups := maps.Clone(s.groups)
+	// Keep the restored states of the groups not created yet.
+	states := maps.Clone(s.restored)
+	s.mu.RUnlock()
+
+	if states == nil {
+		states = make(map[string]State, len(groups))
+	}
+	for name, g := range groups {
+		states[name] = g.Snapshot()
+	}
+	return states
+}
+
+// Name returns the gateway group name, the default group name is empty.
+func (g *Group) Name() string {
+	return g.name
+}
+
+func (g *Group) LeaderNode() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.state.LeaderNode
+}
+
+func (g *Group) LeaderNodeIP() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.state.LeaderNodeIP
+}
+
+func (g *Group) SetLeaderNode(ip, hostname string, planned bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	if ip == "" || hostname == "" {
 		return
 	}
-	s.leaderNode = hostname
-	s.leaderNodeIP = ip
+	if g.state.LeaderNode == hostname && g.state.LeaderNodeIP == ip {
+		return
+	}
+	if g.state.LeaderNode != "" {
+		g.state.PreviousNode = g.state.LeaderNode
+		g.state.PreviousNodeIP = g.state.LeaderNodeIP
+	}
+	g.state.LeaderNode = hostname
+	g.state.LeaderNodeIP = ip
+	g.state.LastTransitionTime = time.Now()
+	g.state.Planned = planned
+	g.transitions = append(g.transitions, g.state.LastTransitionTime)
+	if len(g.transitions) > maxTransitions {
+		g.transitions = g.transitions[len(g.transitions)-maxTransitions:]
+	}
+	g.candidateNode = ""
+	g.candidateSince = time.Time{}
+	g.dampedNode = ""
+}
+
+// ClearLeaderNode clears the leader node when it is deleted and no other node
+// can be the gateway, the cleared node is kept as the previous node.
+func (g *Group) ClearLeaderNode() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.state.LeaderNode == "" {
+		return
+	}
+	g.state.PreviousNode = g.state.LeaderNode
+	g.state.PreviousNodeIP = g.state.LeaderNodeIP
+	g.state.LeaderNode = ""
+	g.state.LeaderNodeIP = ""
+	g.state.GatewayNodes = nil
+	g.state.LastTransitionTime = time.Now()
+	g.state.Planned = false
+	g.candidateNode = ""
+	g.candidateSince = time.Time{}
+	g.dampedNode = ""
+}
+
+// SetLeaderNodeIP updates the IP of the current leader node when the node IP
+// changes, it is not counted as a gateway transition. Returns true if the IP
+// changed.
+func (g *Group) SetLeaderNodeIP(ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ip == "" || g.state.LeaderNode == "" || g.state.LeaderNodeIP == ip {
+		return false
+	}
+	g.state.LeaderNodeIP = ip
+	return true
+}
+
+// GatewayNodes returns the ordered gateway node hostnames of multi-gateway
+// policies, returns nil if the policies select the leader node only.
+func (g *Group) GatewayNodes() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return slices.Clone(g.state.GatewayNodes)
+}
+
+// SetGatewayNodes updates the ordered gateway node hostnames, returns true if
+// the gateway nodes changed.
+func (g *Group) SetGatewayNodes(hostnames []string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if slices.Equal(g.state.GatewayNodes, hostnames) {
+		return false
+	}
+	g.state.GatewayNodes = slices.Clone(hostnames)
+	return true
+}
+
+// Transitions returns the number of the gateway node transitions since the
+// given time, up to the latest 10 transitions are recorded.
+func (g *Group) Transitions(since time.Time) int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	n := 0
+	for _, t := range g.transitions {
+		if t.After(since) {
+			n++
+		}
+	}
+	return n
+}
+
+// Planned returns true if the last gateway transition is a planned move.
+func (g *Group) Planned() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.state.Planned
+}
+
+// ObserveCandidate records the node which is going to be the new leader node,
+// returns the time when the candidate node was first observed.
+func (g *Group) ObserveCandidate(hostname string) time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.candidateNode != hostname {
+		g.candidateNode = hostname
+		g.candidateSince = time.Now()
+	}
+	return g.candidateSince
+}
+
+// Damp records the node whose move is deferred by the failover damping,
+// returns true if the move to the node is deferred for the first time.
+func (g *Group) Damp(hostname string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.dampedNode == hostname {
+		return false
+	}
+	g.dampedNode = hostname
+	return true
+}
+
+// ResetCandidate clears the observed candidate node.
+func (g *Group) ResetCandidate() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.candidateNode = ""
+	g.candidateSince = time.Time{}
+	g.dampedNode = ""
+}
+
+// Snapshot returns a copy of the current gateway state.
+func (g *Group) Snapshot() State {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	st := g.state
+	st.GatewayNodes = slices.Clone(st.GatewayNodes)
+	return st
+}
+
+// Restore overrides the gateway state, used for reloading the persisted
+// state when the operator acquires the leadership. The state evaluated by the
+// standby pod is kept if it has a different leader node which transitioned
+// after the persisted state, returns false if the state is not restored.
+func (g *Group) Restore(state State) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.state.LeaderNode != "" && g.state.LeaderNode != state.LeaderNode &&
+		g.state.LastTransitionTime.After(state.LastTransitionTime) {
+		return false
+	}
+	g.state = state
+	return true
+}
+
+// SetEgressIPToNodeIP overrides whether to set the egressIP of the group
+// policies to the gateway node IP, nil follows the operator option. Returns
+// true if the override changed.
+func (g *Group) SetEgressIPToNodeIP(enabled *bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	changed := (g.egressIPToNodeIP == nil) != (enabled == nil) ||
+		(enabled != nil && *g.egressIPToNodeIP != *enabled)
+	g.egressIPToNodeIP = enabled
+	return changed
+}
+
+// EgressIPToNodeIP returns whether to set the egressIP of the group policies
+// to the gateway node IP, returns defaultValue if not overridden.
+func (g *Group) EgressIPToNodeIP(defaultValue bool) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.egressIPToNodeIP == nil {
+		return defaultValue
+	}
+	return *g.egressIPToNodeIP
+}
+
+// Default returns the default gateway group.
+func Default() *Group {
+	g, _ := s.getGroup(DefaultGroup)
+	return g
+}
+
+// For returns the gateway group by name, creates it if not exist.
+func For(name string) *Group {
+	return s.getOrCreateGroup(name)
+}
+
+// Lookup returns the gateway group by name.
+func Lookup(name string) (*Group, bool) {
+	return s.getGroup(name)
+}
+
+// Delete removes the gateway group, the default group cannot be removed.
+func Delete(name string) {
+	s.deleteGroup(name)
+}
+
+// RestoreGroups overrides the states of the gateway groups, the states of the
+// groups not created yet are applied when they are created. Returns the names
+// of the restored groups, see Group.Restore.
+func RestoreGroups(states map[string]State) []string {
+	return s.restoreGroups(states)
+}
+
+// Snapshots returns the states of all gateway groups keyed by group name.
+func Snapshots() map[string]State {
+	return s.snapshots()
 }
 
 func LeaderNode() string {
-	return s.getLeaderNode()
+	return Default().LeaderNode()
 }
 
 func LeaderNodeIP() string {
-	return s.getLeaderNodeIP()
+	return Default().LeaderNodeIP()
+}
+
+func SetLeaderNode(ip, hostname string, planned bool) {
+	Default().SetLeaderNode(ip, hostname, planned)
+}
+
+// Planned returns true if the last gateway transition is a planned move.
+func Planned() bool {
+	return Default().Planned()
+}
+
+// ObserveCandidate records the node which is going to be the new leader node,
+// returns the time when the candidate node was first observed.
+func ObserveCandidate(hostname string) time.Time {
+	return Default().ObserveCandidate(hostname)
+}
+
+// ResetCandidate clears the observed candidate node.
+func ResetCandidate() {
+	Default().ResetCandidate()
+}
+
+// Snapshot returns a copy of the current gateway state.
+func Snapshot() State {
+	return Default().Snapshot()
 }
 
-func SetLeaderNode(ip, hostname string) {
-	s.setLeaderNode(ip, hostname)
+// Restore overrides the gateway state of the default group, see
+// Group.Restore.
+func Restore(state State) bool {
+	return Default().Restore(state)
 }
@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newStore() *store {
+	return &store{
+		groups: map[string]*Group{
+			DefaultGroup: newGroup(DefaultGroup),
+		},
+		mu: new(sync.RWMutex),
+	}
+}
+
+func TestRestore(t *testing.T) {
+	persisted := State{
+		LeaderNode:         "node-1",
+		LeaderNodeIP:       "10.0.0.1",
+		LastTransitionTime: time.Now().Add(-time.Minute),
+	}
+
+	tests := []struct {
+		name    string
+		current func(g *Group)
+		want    bool
+		// wantLeader is the leader node after restoring.
+		wantLeader string
+	}{
+		{
+			name:       "no current state",
+			current:    func(*Group) {},
+			want:       true,
+			wantLeader: "node-1",
+		},
+		{
+			name: "same leader evaluated later",
+			current: func(g *Group) {
+				g.SetLeaderNode("10.0.0.1", "node-1", false)
+			},
+			want:       true,
+			wantLeader: "node-1",
+		},
+		{
+			name: "different leader evaluated later",
+			current: func(g *Group) {
+				g.SetLeaderNode("10.0.0.2", "node-2", false)
+			},
+			wantLeader: "node-2",
+		},
+		{
+			name: "different leader before the persisted transition",
+			current: func(g *Group) {
+				g.state = State{
+					LeaderNode:         "node-2",
+					LeaderNodeIP:       "10.0.0.2",
+					LastTransitionTime: persisted.LastTransitionTime.Add(-time.Minute),
+				}
+			},
+			want:       true,
+			wantLeader: "node-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newGroup(t.Name())
+			tt.current(g)
+			if got := g.Restore(persisted); got != tt.want {
+				t.Errorf("Restore() = %v, want %v", got, tt.want)
+			}
+			if got := g.LeaderNode(); got != tt.wantLeader {
+				t.Errorf("leader node = %q, want %q", got, tt.wantLeader)
+			}
+		})
+	}
+}
+
+func TestRestoreGroups(t *testing.T) {
+	s := newStore()
+	earlier := time.Now().Add(-time.Minute)
+	s.getOrCreateGroup("evaluated").SetLeaderNode("10.0.0.2", "node-2", false)
+	s.getOrCreateGroup("reloaded")
+
+	states := map[string]State{
+		"evaluated": {LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: earlier},
+		"reloaded":  {LeaderNode: "node-1", LeaderNodeIP: "10.0.0.1", LastTransitionTime: earlier},
+		"pending":   {LeaderNode: "node-3", LeaderNodeIP: "10.0.0.3", LastTransitionTime: earlier},
+	}
+	restored := s.restoreGroups(states)
+	slices.Sort(restored)
+	if want := []string{"pending", "reloaded"}; !slices.Equal(restored, want) {
+		t.Errorf("restoreGroups() = %v, want %v", restored, want)
+	}
+
+	// The states of the groups not created yet are kept in the snapshots and
+	// applied on creation.
+	snapshots := s.snapshots()
+	for name, want := range map[string]string{
+		DefaultGroup: "",
+		"evaluated":  "node-2",
+		"reloaded":   "node-1",
+		"pending":    "node-3",
+	} {
+		if got := snapshots[name].LeaderNode; got != want {
+			t.Errorf("snapshot of group %q leader node = %q, want %q", name, got, want)
+		}
+	}
+	if got := s.getOrCreateGroup("pending").Snapshot(); !reflect.DeepEqual(got, states["pending"]) {
+		t.Errorf("created group state = %+v, want %+v", got, states["pending"])
+	}
+	if _, ok := s.snapshots()["pending"]; !ok {
+		t.Error("created group missing from the snapshots")
+	}
+	if len(s.restored) != 0 {
+		t.Errorf("restored states not consumed: %v", s.restored)
+	}
+}
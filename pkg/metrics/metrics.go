@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "cilium_egress_operator"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	// GatewayLeaderKnown reports whether the gateway leader node is known by
+	// the operator (1) or not (0).
+	GatewayLeaderKnown = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "gateway_leader_known",
+		Help:      "Whether the egress gateway leader node is determined (1) or not (0).",
+	})
+
+	// GatewayTransitions counts the gateway leader node changes.
+	GatewayTransitions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gateway_transitions_total",
+		Help:      "Number of egress gateway leader node changes.",
+	})
+
+	// GatewayTransitionsDamped counts the gateway leader node changes
+	// deferred by the failover damping, counted once per deferred node.
+	GatewayTransitionsDamped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gateway_transitions_damped_total",
+		Help:      "Number of egress gateway leader node changes deferred by the failover damping.",
+	})
+
+	// PolicyPendingMoves reports the number of policies waiting for the
+	// drain period before moving to the new gateway node.
+	PolicyPendingMoves = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "policy_pending_moves",
+		Help:      "Number of policies waiting for the drain period before moving to the new gateway node.",
+	})
+
+	// PolicyDrifts counts the detected policy drifts by type: failover is
+	// counted when a policy written by the operator is moved to the new
+	// gateway, manual-edit when another manager overwrote the operator write.
+	PolicyDrifts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "policy_drifts_total",
+		Help:      "Number of detected policy egressGateway drifts by type.",
+	}, []string{"type"})
+
+	// PolicyEgressIPConflicts reports the number of policies refused to be
+	// updated because of the egressIP conflict.
+	PolicyEgressIPConflicts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "policy_egress_ip_conflicts",
+		Help:      "Number of policies whose egressIP conflicts with other policies or nodes.",
+	})
+
+	// PolicyEgressIPConflictsDetected counts the detected egressIP conflicts
+	// by type (policy, node).
+	PolicyEgressIPConflictsDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "policy_egress_ip_conflicts_total",
+		Help:      "Number of detected policy egressIP conflicts by type.",
+	}, []string{"type"})
+
+	// PolicyHostnameUnmatchable reports the number of policies refused to be
+	// updated because the nodeSelector excludes the desired gateway node.
+	PolicyHostnameUnmatchable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "policy_hostname_unmatchable",
+		Help:      "Number of policies whose nodeSelector cannot match the desired gateway node.",
+	})
+
+	// PolicyHostnameUnmatchableDetected counts the detected policies whose
+	// nodeSelector excludes the desired gateway node.
+	PolicyHostnameUnmatchableDetected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "policy_hostname_unmatchable_total",
+		Help:      "Number of detected policies whose nodeSelector cannot match the desired gateway node.",
+	})
+
+	// ConfigReloads counts the config file reloads by result
+	// (success, failure).
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of config file reloads by result.",
+	}, []string{"result"})
+
+	// ConfigLastReloadSuccessful reports whether the last config file reload
+	// succeeded (1) or was rejected (0).
+	ConfigLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last config file reload succeeded (1) or was rejected (0).",
+	})
+
+	// BootstrapDuration records the time spent on determining the gateway
+	// leader node when the operator starts.
+	BootstrapDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bootstrap_duration_seconds",
+		Help:      "Time spent on determining the egress gateway leader node on startup.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		GatewayLeaderKnown,
+		GatewayTransitions,
+		GatewayTransitionsDamped,
+		PolicyPendingMoves,
+		PolicyDrifts,
+		PolicyEgressIPConflicts,
+		PolicyEgressIPConflictsDetected,
+		PolicyHostnameUnmatchable,
+		PolicyHostnameUnmatchableDetected,
+		ConfigReloads,
+		ConfigLastReloadSuccessful,
+		BootstrapDuration,
+	)
+}
+
+// Handler returns the HTTP handler to serve the operator metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,181 @@
+package policy
+
+import (
+	"context"
+	"time"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/wrangler"
+	ciliumcontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/cilium.io/v2"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	CiliumKind = "CiliumEgressGatewayPolicy"
+)
+
+type ciliumPolicy struct {
+	*ciliumv2.CiliumEgressGatewayPolicy
+}
+
+// NewCiliumPolicy wraps the CiliumEgressGatewayPolicy object as a Policy.
+func NewCiliumPolicy(obj *ciliumv2.CiliumEgressGatewayPolicy) Policy {
+	return &ciliumPolicy{obj}
+}
+
+func (p *ciliumPolicy) Object() runtime.Object {
+	return p.CiliumEgressGatewayPolicy
+}
+
+func (p *ciliumPolicy) Copy() Policy {
+	return &ciliumPolicy{p.DeepCopy()}
+}
+
+func (p *ciliumPolicy) HasGateway() bool {
+	return p.Spec.EgressGateway != nil
+}
+
+func (p *ciliumPolicy) EgressIP() string {
+	return utils.PolicyIP(p.CiliumEgressGatewayPolicy)
+}
+
+func (p *ciliumPolicy) Hostname() string {
+	return utils.PolicyHostname(p.CiliumEgressGatewayPolicy)
+}
+
+func (p *ciliumPolicy) SetEgressIP(ip string) {
+	if p.Spec.EgressGateway == nil {
+		return
+	}
+	p.Spec.EgressGateway.EgressIP = ip
+}
+
+func (p *ciliumPolicy) SetHostname(hostname string) {
+	if p.Spec.EgressGateway == nil {
+		return
+	}
+	if p.Spec.EgressGateway.NodeSelector == nil {
+		p.Spec.EgressGateway.NodeSelector = &slimv1.LabelSelector{}
+	}
+	if p.Spec.EgressGateway.NodeSelector.MatchLabels == nil {
+		p.Spec.EgressGateway.NodeSelector.MatchLabels = make(map[string]slimv1.MatchLabelsValue)
+	}
+	p.Spec.EgressGateway.NodeSelector.MatchLabels[utils.HostnameLabelKey] = hostname
+	// The gateway nodes selected while the group had multiple gateways
+	// would exclude the single gateway node.
+	utils.RemoveSelectorHostnames(p.Spec.EgressGateway.NodeSelector, managedHostnames(p))
+	setManagedHostnames(p, nil)
+}
+
+func (p *ciliumPolicy) CheckHostnameMatchable(hostname string) error {
+	if p.Spec.EgressGateway == nil {
+		return nil
+	}
+	return utils.CheckHostnameMatchable(
+		utils.UserSelector(p.Spec.EgressGateway.NodeSelector, managedHostnames(p)), hostname)
+}
+
+func (p *ciliumPolicy) Hostnames() []string {
+	if p.Spec.EgressGateway == nil {
+		return nil
+	}
+	return utils.SelectorHostnames(p.Spec.EgressGateway.NodeSelector, managedHostnames(p))
+}
+
+func (p *ciliumPolicy) SetHostnames(hostnames []string) {
+	if p.Spec.EgressGateway == nil {
+		return
+	}
+	if p.Spec.EgressGateway.NodeSelector == nil {
+		p.Spec.EgressGateway.NodeSelector = &slimv1.LabelSelector{}
+	}
+	utils.SetSelectorHostnames(p.Spec.EgressGateway.NodeSelector, managedHostnames(p), hostnames)
+	setManagedHostnames(p, hostnames)
+}
+
+func (p *ciliumPolicy) CheckHostnamesMatchable(hostnames []string) error {
+	if p.Spec.EgressGateway == nil {
+		return nil
+	}
+	return utils.CheckHostnamesMatchable(p.Spec.EgressGateway.NodeSelector, managedHostnames(p), hostnames)
+}
+
+func (p *ciliumPolicy) GatewayField() string {
+	return "egressGateway"
+}
+
+type ciliumKind struct {
+	controller ciliumcontroller.CiliumEgressGatewayPolicyController
+}
+
+// NewCiliumKind returns the CiliumEgressGatewayPolicy kind, the policy cache
+// indexers are added before the informer starts.
+func NewCiliumKind(wctx *wrangler.Context) Kind {
+	k := &ciliumKind{
+		controller: wctx.Cilium.CiliumEgressGatewayPolicy(),
+	}
+	for name, indexer := range indexers() {
+		fn := indexFunc(indexer)
+		k.controller.Cache().AddIndexer(name, func(obj *ciliumv2.CiliumEgressGatewayPolicy) ([]string, error) {
+			return fn(&ciliumPolicy{obj})
+		})
+	}
+	return k
+}
+
+func (k *ciliumKind) Kind() string {
+	return CiliumKind
+}
+
+func (k *ciliumKind) OnChange(ctx context.Context, name string, sync func(string, Policy) (Policy, error)) {
+	k.controller.OnChange(ctx, name, func(key string, obj *ciliumv2.CiliumEgressGatewayPolicy) (*ciliumv2.CiliumEgressGatewayPolicy, error) {
+		var p Policy
+		if obj != nil {
+			p = &ciliumPolicy{obj}
+		}
+		synced, err := sync(key, p)
+		if synced == nil {
+			return obj, err
+		}
+		return synced.(*ciliumPolicy).CiliumEgressGatewayPolicy, err
+	})
+}
+
+func (k *ciliumKind) Enqueue(name string) {
+	k.controller.Enqueue(name)
+}
+
+func (k *ciliumKind) EnqueueAfter(name string, duration time.Duration) {
+	k.controller.EnqueueAfter(name, duration)
+}
+
+func (k *ciliumKind) Get(name string) (Policy, error) {
+	obj, err := k.controller.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &ciliumPolicy{obj}, nil
+}
+
+func (k *ciliumKind) ByIndex(indexName, key string) ([]Policy, error) {
+	objs, err := k.controller.Cache().GetByIndex(indexName, key)
+	if err != nil {
+		return nil, err
+	}
+	policies := make([]Policy, 0, len(objs))
+	for _, obj := range objs {
+		policies = append(policies, &ciliumPolicy{obj})
+	}
+	return policies, nil
+}
+
+func (k *ciliumKind) Update(p Policy) (Policy, error) {
+	obj, err := k.controller.Update(p.(*ciliumPolicy).CiliumEgressGatewayPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return &ciliumPolicy{obj}, nil
+}
@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"fmt"
+)
+
+const (
+	// IndexMonitored indexes the monitored policies by the "true" key.
+	IndexMonitored = "egress.cilium.pandaria.io/monitored"
+	// IndexGroup indexes the monitored policies by the gateway group name,
+	// the policies of the default gateway group are indexed by an empty key.
+	IndexGroup = "egress.cilium.pandaria.io/group"
+	// IndexGatewayNode indexes the monitored policies by the gateway node
+	// hostnames selected by the policy nodeSelector.
+	IndexGatewayNode = "egress.cilium.pandaria.io/gateway-node"
+	// IndexEgressIP indexes the monitored policies by the current egressIP.
+	IndexEgressIP = "egress.cilium.pandaria.io/egress-ip"
+
+	monitoredKey = "true"
+)
+
+// indexers returns the cache indexers added to every policy kind, only the
+// monitored policies not being deleted are indexed.
+func indexers() map[string]func(Policy) []string {
+	return map[string]func(Policy) []string{
+		IndexMonitored: func(p Policy) []string {
+			return []string{monitoredKey}
+		},
+		IndexGroup: func(p Policy) []string {
+			return []string{Group(p)}
+		},
+		IndexGatewayNode: func(p Policy) []string {
+			if hostname := p.Hostname(); hostname != "" {
+				return []string{hostname}
+			}
+			return p.Hostnames()
+		},
+		IndexEgressIP: func(p Policy) []string {
+			if ip := p.EgressIP(); ip != "" {
+				return []string{ip}
+			}
+			return nil
+		},
+	}
+}
+
+// indexFunc wraps the policy indexer for the monitored policies.
+func indexFunc(indexer func(Policy) []string) func(Policy) ([]string, error) {
+	return func(p Policy) ([]string, error) {
+		if p == nil || p.GetDeletionTimestamp() != nil || !Monitored(p) {
+			return nil, nil
+		}
+		return indexer(p), nil
+	}
+}
+
+// ListMonitored lists the monitored policies of the kind from the cache.
+func ListMonitored(kind Kind) ([]Policy, error) {
+	policies, err := kind.ByIndex(IndexMonitored, monitoredKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list monitored %v from cache: %w", kind.Kind(), err)
+	}
+	return policies, nil
+}
+
+// ListGroup lists the monitored policies of the kind bound to the gateway
+// group from the cache.
+func ListGroup(kind Kind, group string) ([]Policy, error) {
+	policies, err := kind.ByIndex(IndexGroup, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %v of group [%v] from cache: %w", kind.Kind(), group, err)
+	}
+	return policies, nil
+}
+
+// ListGatewayNode lists the monitored policies of the kind selecting the
+// gateway node hostname from the cache.
+func ListGatewayNode(kind Kind, hostname string) ([]Policy, error) {
+	policies, err := kind.ByIndex(IndexGatewayNode, hostname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %v on node [%v] from cache: %w", kind.Kind(), hostname, err)
+	}
+	return policies, nil
+}
+
+// ListEgressIP lists the monitored policies of the kind with the egressIP
+// from the cache.
+func ListEgressIP(kind Kind, ip string) ([]Policy, error) {
+	policies, err := kind.ByIndex(IndexEgressIP, ip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %v with egressIP [%v] from cache: %w", kind.Kind(), ip, err)
+	}
+	return policies, nil
+}
+
+// EnqueueGroup enqueues the monitored policies of all kinds bound to the
+// gateway group.
+func EnqueueGroup(group string) error {
+	for _, kind := range Kinds() {
+		policies, err := ListGroup(kind, group)
+		if err != nil {
+			return err
+		}
+		for _, p := range policies {
+			kind.Enqueue(p.GetName())
+		}
+	}
+	return nil
+}
+
+// EnqueueGatewayNode enqueues the monitored policies of all kinds selecting
+// the gateway node hostname.
+func EnqueueGatewayNode(hostname string) error {
+	if hostname == "" {
+		return nil
+	}
+	for _, kind := range Kinds() {
+		policies, err := ListGatewayNode(kind, hostname)
+		if err != nil {
+			return err
+		}
+		for _, p := range policies {
+			kind.Enqueue(p.GetName())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package policy
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// registerKind registers the policy kind for the test, the registered kinds
+// are restored on cleanup.
+func registerKind(t *testing.T, kind Kind) {
+	t.Helper()
+	kindsMu.Lock()
+	registered := kinds
+	kinds = []Kind{kind}
+	kindsMu.Unlock()
+	t.Cleanup(func() {
+		kindsMu.Lock()
+		kinds = registered
+		kindsMu.Unlock()
+	})
+}
+
+// setInstanceID sets the operator instance ID for the test.
+func setInstanceID(t *testing.T, id string) {
+	t.Helper()
+	utils.SetInstanceID(id)
+	t.Cleanup(func() { utils.SetInstanceID("") })
+}
+
+// annotatedPolicy returns the isovalent policy of the egress groups with the
+// annotations added.
+func annotatedPolicy(name string, annotations map[string]string, groups ...map[string]any) *unstructured.Unstructured {
+	obj := newIsovalentPolicy(name, groups...)
+	merged := obj.GetAnnotations()
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	obj.SetAnnotations(merged)
+	return obj
+}
+
+func TestIndexers(t *testing.T) {
+	node1 := map[string]any{utils.HostnameLabelKey: "node-1"}
+	multi := &isovalentPolicy{newIsovalentPolicy("multi", egressGroup("10.0.0.1", nil))}
+	multi.SetHostnames([]string{"node-1", "node-2"})
+	deleting := newIsovalentPolicy("deleting", egressGroup("10.0.0.1", node1))
+	deleting.SetDeletionTimestamp(&metav1.Time{Time: time.Now()})
+
+	tests := []struct {
+		name     string
+		instance string
+		policy   *unstructured.Unstructured
+		want     map[string][]string
+	}{
+		{
+			name:   "default group",
+			policy: newIsovalentPolicy("policy", egressGroup("10.0.0.1", node1)),
+			want: map[string][]string{
+				IndexMonitored:   {monitoredKey},
+				IndexGroup:       {""},
+				IndexGatewayNode: {"node-1"},
+				IndexEgressIP:    {"10.0.0.1"},
+			},
+		},
+		{
+			name: "gateway group",
+			policy: annotatedPolicy("policy", map[string]string{utils.GroupAnnotation: "group-a"},
+				egressGroup("", node1)),
+			want: map[string][]string{
+				IndexMonitored:   {monitoredKey},
+				IndexGroup:       {"group-a"},
+				IndexGatewayNode: {"node-1"},
+			},
+		},
+		{
+			name:   "multi-gateway nodes",
+			policy: multi.Unstructured,
+			want: map[string][]string{
+				IndexMonitored:   {monitoredKey},
+				IndexGroup:       {""},
+				IndexGatewayNode: {"node-1", "node-2"},
+				IndexEgressIP:    {"10.0.0.1"},
+			},
+		},
+		{
+			name: "not monitored",
+			policy: annotatedPolicy("policy", map[string]string{utils.WatchAnnotationPrefix: "false"},
+				egressGroup("10.0.0.1", node1)),
+		},
+		{
+			name:   "deleting",
+			policy: deleting,
+		},
+		{
+			name:     "policy of another instance",
+			instance: "a",
+			policy: annotatedPolicy("policy", map[string]string{utils.InstanceAnnotation: "b"},
+				egressGroup("10.0.0.1", node1)),
+		},
+		{
+			name:     "policy of the instance",
+			instance: "a",
+			policy: annotatedPolicy("policy", map[string]string{utils.InstanceAnnotation: "a"},
+				egressGroup("10.0.0.1", node1)),
+			want: map[string][]string{
+				IndexMonitored:   {monitoredKey},
+				IndexGroup:       {""},
+				IndexGatewayNode: {"node-1"},
+				IndexEgressIP:    {"10.0.0.1"},
+			},
+		},
+		{
+			name: "policy of an instance ignored by the default instance",
+			policy: annotatedPolicy("policy", map[string]string{utils.InstanceAnnotation: "a"},
+				egressGroup("10.0.0.1", node1)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setInstanceID(t, tt.instance)
+			for name, indexer := range indexers() {
+				got, err := indexFunc(indexer)(&isovalentPolicy{tt.policy})
+				if err != nil {
+					t.Fatalf("index %v error = %v", name, err)
+				}
+				if !slices.Equal(got, tt.want[name]) {
+					t.Errorf("index %v keys = %q, want %q", name, got, tt.want[name])
+				}
+			}
+		})
+	}
+}
+
+func TestEnqueueGroup(t *testing.T) {
+	node1 := map[string]any{utils.HostnameLabelKey: "node-1"}
+	controller := newFakeController(
+		newIsovalentPolicy("default", egressGroup("", node1)),
+		annotatedPolicy("group-a", map[string]string{utils.GroupAnnotation: "group-a"}, egressGroup("", node1)),
+		annotatedPolicy("unmonitored", map[string]string{utils.WatchAnnotationPrefix: "false"}, egressGroup("", node1)),
+	)
+	registerKind(t, newIsovalentKind(controller))
+
+	tests := []struct {
+		name  string
+		group string
+		want  []string
+	}{
+		{name: "default group", want: []string{"default"}},
+		{name: "gateway group", group: "group-a", want: []string{"group-a"}},
+		{name: "group without policies", group: "group-b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller.enqueued = nil
+			if err := EnqueueGroup(tt.group); err != nil {
+				t.Fatalf("EnqueueGroup() error = %v", err)
+			}
+			if !slices.Equal(controller.enqueued, tt.want) {
+				t.Errorf("EnqueueGroup() enqueued = %v, want %v", controller.enqueued, tt.want)
+			}
+		})
+	}
+
+	controller.enqueued = nil
+	if err := EnqueueGatewayNode("node-1"); err != nil {
+		t.Fatalf("EnqueueGatewayNode() error = %v", err)
+	}
+	if want := []string{"default", "group-a"}; !slices.Equal(controller.enqueued, want) {
+		t.Errorf("EnqueueGatewayNode() enqueued = %v, want %v", controller.enqueued, want)
+	}
+}
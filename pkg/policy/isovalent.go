@@ -0,0 +1,312 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/wrangler"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	IsovalentKind = "IsovalentEgressGatewayPolicy"
+
+	isovalentResource = "isovalentegressgatewaypolicies"
+)
+
+var isovalentGVK = schema.GroupVersionKind{
+	Group:   "isovalent.com",
+	Version: "v1",
+	Kind:    IsovalentKind,
+}
+
+// isovalentPolicy is the Isovalent Enterprise egress gateway policy, the
+// gateways are configured in spec.egressGroups. The policy is handled as
+// unstructured to keep the fields unknown by the operator.
+//
+// The egressIP and the gateway nodes are written to every egress group, the
+// egress groups selecting different nodes (e.g. the HA egress groups) are
+// flattened to the same gateway by the first write, only the other fields
+// of the egress groups are kept.
+type isovalentPolicy struct {
+	*unstructured.Unstructured
+}
+
+func (p *isovalentPolicy) Object() runtime.Object {
+	return p.Unstructured
+}
+
+func (p *isovalentPolicy) Copy() Policy {
+	return &isovalentPolicy{p.DeepCopy()}
+}
+
+func (p *isovalentPolicy) egressGroups() []any {
+	groups, _, _ := unstructured.NestedSlice(p.Unstructured.Object, "spec", "egressGroups")
+	return groups
+}
+
+// groupsValue returns the string field shared by all egress groups, returns
+// an empty string if the egress groups have different values.
+func (p *isovalentPolicy) groupsValue(fields ...string) string {
+	var value string
+	for i, g := range p.egressGroups() {
+		m, ok := g.(map[string]any)
+		if !ok {
+			return ""
+		}
+		v, _, _ := unstructured.NestedString(m, fields...)
+		if i == 0 {
+			value = v
+			continue
+		}
+		if v != value {
+			return ""
+		}
+	}
+	return value
+}
+
+// setGroupsValue sets the string field of all egress groups, the different
+// values of the egress groups are overwritten.
+func (p *isovalentPolicy) setGroupsValue(value string, fields ...string) {
+	groups := p.egressGroups()
+	if len(groups) == 0 {
+		return
+	}
+	for i, g := range groups {
+		m, ok := g.(map[string]any)
+		if !ok {
+			continue
+		}
+		_ = unstructured.SetNestedField(m, value, fields...)
+		groups[i] = m
+	}
+	_ = unstructured.SetNestedSlice(p.Unstructured.Object, groups, "spec", "egressGroups")
+}
+
+func (p *isovalentPolicy) HasGateway() bool {
+	return len(p.egressGroups()) > 0
+}
+
+func (p *isovalentPolicy) EgressIP() string {
+	return p.groupsValue("egressIP")
+}
+
+func (p *isovalentPolicy) Hostname() string {
+	return p.groupsValue("nodeSelector", "matchLabels", utils.HostnameLabelKey)
+}
+
+func (p *isovalentPolicy) SetEgressIP(ip string) {
+	p.setGroupsValue(ip, "egressIP")
+}
+
+func (p *isovalentPolicy) SetHostname(hostname string) {
+	managed := managedHostnames(p)
+	p.updateSelectors(func(selector *slimv1.LabelSelector) {
+		if selector.MatchLabels == nil {
+			selector.MatchLabels = make(map[string]slimv1.MatchLabelsValue)
+		}
+		selector.MatchLabels[utils.HostnameLabelKey] = hostname
+		utils.RemoveSelectorHostnames(selector, managed)
+	})
+	setManagedHostnames(p, nil)
+}
+
+// nodeSelectors decodes the nodeSelector of every egress group, a nil
+// selector is returned for the egress groups without nodeSelector.
+func (p *isovalentPolicy) nodeSelectors() ([]*slimv1.LabelSelector, error) {
+	groups := p.egressGroups()
+	selectors := make([]*slimv1.LabelSelector, len(groups))
+	for i, g := range groups {
+		m, ok := g.(map[string]any)
+		if !ok {
+			continue
+		}
+		raw, ok, _ := unstructured.NestedMap(m, "nodeSelector")
+		if !ok {
+			continue
+		}
+		selector := &slimv1.LabelSelector{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, selector); err != nil {
+			return nil, fmt.Errorf("failed to decode spec.egressGroups[%d].nodeSelector: %w", i, err)
+		}
+		selectors[i] = selector
+	}
+	return selectors, nil
+}
+
+func (p *isovalentPolicy) CheckHostnameMatchable(hostname string) error {
+	selectors, err := p.nodeSelectors()
+	if err != nil {
+		return err
+	}
+	managed := managedHostnames(p)
+	for _, selector := range selectors {
+		if err := utils.CheckHostnameMatchable(utils.UserSelector(selector, managed), hostname); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hostnames returns the hostnames shared by all egress groups, returns nil
+// if the egress groups select different hostnames.
+func (p *isovalentPolicy) Hostnames() []string {
+	selectors, err := p.nodeSelectors()
+	if err != nil {
+		return nil
+	}
+	managed := managedHostnames(p)
+	var hostnames []string
+	for i, selector := range selectors {
+		h := utils.SelectorHostnames(selector, managed)
+		if i == 0 {
+			hostnames = h
+			continue
+		}
+		if !slices.Equal(h, hostnames) {
+			return nil
+		}
+	}
+	return hostnames
+}
+
+func (p *isovalentPolicy) SetHostnames(hostnames []string) {
+	managed := managedHostnames(p)
+	p.updateSelectors(func(selector *slimv1.LabelSelector) {
+		utils.SetSelectorHostnames(selector, managed, hostnames)
+	})
+	setManagedHostnames(p, hostnames)
+}
+
+// updateSelectors updates the nodeSelector of every egress group, the
+// egress groups without nodeSelector are updated from an empty selector.
+func (p *isovalentPolicy) updateSelectors(update func(selector *slimv1.LabelSelector)) {
+	selectors, err := p.nodeSelectors()
+	if err != nil {
+		return
+	}
+	groups := p.egressGroups()
+	for i, g := range groups {
+		m, ok := g.(map[string]any)
+		if !ok {
+			continue
+		}
+		selector := selectors[i]
+		if selector == nil {
+			selector = &slimv1.LabelSelector{}
+		}
+		update(selector)
+		raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(selector)
+		if err != nil {
+			continue
+		}
+		m["nodeSelector"] = raw
+		groups[i] = m
+	}
+	_ = unstructured.SetNestedSlice(p.Unstructured.Object, groups, "spec", "egressGroups")
+}
+
+func (p *isovalentPolicy) CheckHostnamesMatchable(hostnames []string) error {
+	selectors, err := p.nodeSelectors()
+	if err != nil {
+		return err
+	}
+	managed := managedHostnames(p)
+	for _, selector := range selectors {
+		if err := utils.CheckHostnamesMatchable(selector, managed, hostnames); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *isovalentPolicy) GatewayField() string {
+	return "egressGroups"
+}
+
+type isovalentKind struct {
+	controller generic.NonNamespacedControllerInterface[*unstructured.Unstructured, *unstructured.UnstructuredList]
+}
+
+// NewIsovalentKind returns the IsovalentEgressGatewayPolicy kind, requires
+// the Isovalent Enterprise CRDs installed.
+func NewIsovalentKind(wctx *wrangler.Context) Kind {
+	return newIsovalentKind(generic.NewNonNamespacedController[*unstructured.Unstructured, *unstructured.UnstructuredList](
+		isovalentGVK, isovalentResource, wctx.Dynamic.ControllerFactory()))
+}
+
+func newIsovalentKind(
+	controller generic.NonNamespacedControllerInterface[*unstructured.Unstructured, *unstructured.UnstructuredList],
+) *isovalentKind {
+	k := &isovalentKind{controller: controller}
+	for name, indexer := range indexers() {
+		fn := indexFunc(indexer)
+		k.controller.Cache().AddIndexer(name, func(obj *unstructured.Unstructured) ([]string, error) {
+			return fn(&isovalentPolicy{obj})
+		})
+	}
+	return k
+}
+
+func (k *isovalentKind) Kind() string {
+	return IsovalentKind
+}
+
+func (k *isovalentKind) OnChange(ctx context.Context, name string, sync func(string, Policy) (Policy, error)) {
+	k.controller.OnChange(ctx, name, func(key string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		var p Policy
+		if obj != nil {
+			p = &isovalentPolicy{obj}
+		}
+		synced, err := sync(key, p)
+		if synced == nil {
+			return obj, err
+		}
+		return synced.(*isovalentPolicy).Unstructured, err
+	})
+}
+
+func (k *isovalentKind) Enqueue(name string) {
+	k.controller.Enqueue(name)
+}
+
+func (k *isovalentKind) EnqueueAfter(name string, duration time.Duration) {
+	k.controller.EnqueueAfter(name, duration)
+}
+
+func (k *isovalentKind) Get(name string) (Policy, error) {
+	obj, err := k.controller.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &isovalentPolicy{obj}, nil
+}
+
+func (k *isovalentKind) ByIndex(indexName, key string) ([]Policy, error) {
+	objs, err := k.controller.Cache().GetByIndex(indexName, key)
+	if err != nil {
+		return nil, err
+	}
+	policies := make([]Policy, 0, len(objs))
+	for _, obj := range objs {
+		policies = append(policies, &isovalentPolicy{obj})
+	}
+	return policies, nil
+}
+
+func (k *isovalentKind) Update(p Policy) (Policy, error) {
+	obj, err := k.controller.Update(p.(*isovalentPolicy).Unstructured)
+	if err != nil {
+		return nil, err
+	}
+	return &isovalentPolicy{obj}, nil
+}
@@ -0,0 +1,241 @@
+package policy
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/fake"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetLevel(logrus.PanicLevel)
+	os.Exit(m.Run())
+}
+
+// fakeController is the IsovalentEgressGatewayPolicy controller backed by
+// the fake cache, the methods not used by the kind panic.
+type fakeController struct {
+	generic.NonNamespacedControllerInterface[*unstructured.Unstructured, *unstructured.UnstructuredList]
+
+	cache    *fake.NonNamespacedCache[*unstructured.Unstructured]
+	enqueued []string
+}
+
+func newFakeController(objs ...*unstructured.Unstructured) *fakeController {
+	resource := schema.GroupResource{Group: isovalentGVK.Group, Resource: isovalentResource}
+	return &fakeController{cache: fake.NewNonNamespacedCache(resource, objs...)}
+}
+
+func (c *fakeController) Get(name string, _ metav1.GetOptions) (*unstructured.Unstructured, error) {
+	obj, err := c.cache.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.DeepCopy(), nil
+}
+
+func (c *fakeController) Update(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	c.cache.Add(obj.DeepCopy())
+	return obj, nil
+}
+
+func (c *fakeController) Enqueue(name string) {
+	c.enqueued = append(c.enqueued, name)
+}
+
+func (c *fakeController) Cache() generic.NonNamespacedCacheInterface[*unstructured.Unstructured] {
+	return c.cache
+}
+
+// egressGroup returns the spec.egressGroups entry with the egressIP and
+// the nodeSelector matchLabels.
+func egressGroup(ip string, matchLabels map[string]any) map[string]any {
+	group := map[string]any{"interface": "eth1"}
+	if ip != "" {
+		group["egressIP"] = ip
+	}
+	if matchLabels != nil {
+		group["nodeSelector"] = map[string]any{"matchLabels": matchLabels}
+	}
+	return group
+}
+
+// newIsovalentPolicy returns the monitored isovalent policy with the egress
+// groups.
+func newIsovalentPolicy(name string, groups ...map[string]any) *unstructured.Unstructured {
+	egressGroups := make([]any, 0, len(groups))
+	for _, g := range groups {
+		egressGroups = append(egressGroups, g)
+	}
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"selectors":    []any{map[string]any{"podSelector": map[string]any{}}},
+			"egressGroups": egressGroups,
+		},
+	}}
+	obj.SetGroupVersionKind(isovalentGVK)
+	obj.SetName(name)
+	obj.SetAnnotations(map[string]string{utils.WatchAnnotationPrefix: utils.WatchAnnotationValue})
+	return obj
+}
+
+func TestIsovalentPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		groups       []map[string]any
+		wantIP       string
+		wantHostname string
+	}{
+		{
+			name:   "no egress groups",
+			groups: nil,
+		},
+		{
+			name: "single egress group",
+			groups: []map[string]any{
+				egressGroup("10.0.0.1", map[string]any{utils.HostnameLabelKey: "node-1"}),
+			},
+			wantIP:       "10.0.0.1",
+			wantHostname: "node-1",
+		},
+		{
+			name: "egress groups of the same gateway",
+			groups: []map[string]any{
+				egressGroup("10.0.0.1", map[string]any{utils.HostnameLabelKey: "node-1"}),
+				egressGroup("10.0.0.1", map[string]any{utils.HostnameLabelKey: "node-1", "zone": "a"}),
+			},
+			wantIP:       "10.0.0.1",
+			wantHostname: "node-1",
+		},
+		{
+			name: "HA egress groups",
+			groups: []map[string]any{
+				egressGroup("10.0.0.1", map[string]any{utils.HostnameLabelKey: "node-1"}),
+				egressGroup("10.0.0.2", map[string]any{utils.HostnameLabelKey: "node-2"}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &isovalentPolicy{newIsovalentPolicy("policy", tt.groups...)}
+			if got := p.HasGateway(); got != (len(tt.groups) > 0) {
+				t.Errorf("HasGateway() = %v, want %v", got, len(tt.groups) > 0)
+			}
+			if got := p.EgressIP(); got != tt.wantIP {
+				t.Errorf("EgressIP() = %q, want %q", got, tt.wantIP)
+			}
+			if got := p.Hostname(); got != tt.wantHostname {
+				t.Errorf("Hostname() = %q, want %q", got, tt.wantHostname)
+			}
+		})
+	}
+}
+
+func TestIsovalentPolicySetGateway(t *testing.T) {
+	// The HA egress groups are flattened to the gateway, the other fields
+	// of the egress groups and the policy are kept.
+	p := &isovalentPolicy{newIsovalentPolicy("policy",
+		egressGroup("10.0.0.1", map[string]any{utils.HostnameLabelKey: "node-1"}),
+		egressGroup("10.0.0.2", map[string]any{utils.HostnameLabelKey: "node-2", "zone": "b"}),
+	)}
+
+	p.SetEgressIP("10.0.0.3")
+	p.SetHostnames([]string{"node-3", "node-4"})
+	if got := p.EgressIP(); got != "10.0.0.3" {
+		t.Errorf("EgressIP() = %q, want %q", got, "10.0.0.3")
+	}
+	if got, want := p.Hostnames(), []string{"node-3", "node-4"}; !slices.Equal(got, want) {
+		t.Errorf("Hostnames() = %v, want %v", got, want)
+	}
+	if got := p.GetAnnotations()[utils.GatewayNodesAnnotation]; got != "node-3,node-4" {
+		t.Errorf("gateway nodes annotation = %q, want %q", got, "node-3,node-4")
+	}
+
+	p.SetHostname("node-5")
+	if got := p.Hostname(); got != "node-5" {
+		t.Errorf("Hostname() = %q, want %q", got, "node-5")
+	}
+	if got := p.Hostnames(); len(got) > 0 {
+		t.Errorf("Hostnames() = %v, want the managed expression removed", got)
+	}
+	if _, ok := p.GetAnnotations()[utils.GatewayNodesAnnotation]; ok {
+		t.Errorf("gateway nodes annotation is kept after SetHostname()")
+	}
+	for i, g := range p.egressGroups() {
+		m := g.(map[string]any)
+		if m["interface"] != "eth1" {
+			t.Errorf("egressGroups[%d].interface = %v, want eth1", i, m["interface"])
+		}
+	}
+	zone, _, _ := unstructured.NestedString(p.egressGroups()[1].(map[string]any), "nodeSelector", "matchLabels", "zone")
+	if zone != "b" {
+		t.Errorf("egressGroups[1] zone label = %q, want %q", zone, "b")
+	}
+	if _, ok, _ := unstructured.NestedSlice(p.Unstructured.Object, "spec", "selectors"); !ok {
+		t.Errorf("spec.selectors is removed")
+	}
+}
+
+func TestIsovalentKind(t *testing.T) {
+	cached := newIsovalentPolicy("policy",
+		egressGroup("10.0.0.1", map[string]any{utils.HostnameLabelKey: "node-1"}))
+	unmonitored := newIsovalentPolicy("unmonitored",
+		egressGroup("10.0.0.1", map[string]any{utils.HostnameLabelKey: "node-1"}))
+	unmonitored.SetAnnotations(nil)
+	controller := newFakeController(cached, unmonitored)
+	k := newIsovalentKind(controller)
+
+	policies, err := k.ByIndex(IndexGatewayNode, "node-1")
+	if err != nil {
+		t.Fatalf("ByIndex() error = %v", err)
+	}
+	if len(policies) != 1 || policies[0].GetName() != "policy" {
+		t.Errorf("ByIndex() = %v, want the monitored policy", policies)
+	}
+
+	// The latest object written by another client after the cached one.
+	latest := cached.DeepCopy()
+	latest.SetLabels(map[string]string{"app": "egress"})
+	controller.cache.Add(latest)
+
+	p, err := k.Get("policy")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	// Only the operator managed fields are merged into the latest object.
+	pp := p.Copy()
+	pp.SetEgressIP("10.0.0.2")
+	pp.SetHostname("node-2")
+	if _, err := k.Update(pp); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if p.EgressIP() != "10.0.0.1" {
+		t.Errorf("Copy() shares the object, egressIP = %q", p.EgressIP())
+	}
+
+	got, err := k.Get("policy")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.EgressIP() != "10.0.0.2" || got.Hostname() != "node-2" {
+		t.Errorf("updated gateway = %q %q, want %q %q", got.EgressIP(), got.Hostname(), "10.0.0.2", "node-2")
+	}
+	if got.GetLabels()["app"] != "egress" {
+		t.Errorf("updated labels = %v, want the latest labels kept", got.GetLabels())
+	}
+	if policies, _ := k.ByIndex(IndexGatewayNode, "node-2"); len(policies) != 1 {
+		t.Errorf("ByIndex() after update = %v, want the updated policy", policies)
+	}
+
+	k.Enqueue("policy")
+	if !slices.Equal(controller.enqueued, []string{"policy"}) {
+		t.Errorf("enqueued = %v, want [policy]", controller.enqueued)
+	}
+}
@@ -0,0 +1,153 @@
+package policy
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Policy is the egress gateway policy managed by the operator, implemented
+// by the supported egress policy kinds.
+type Policy interface {
+	metav1.Object
+
+	// Object returns the underlying object, used for recording events.
+	Object() runtime.Object
+	// Copy returns a deep copy of the policy.
+	Copy() Policy
+
+	// HasGateway returns true if the policy has the gateway configured.
+	HasGateway() bool
+	// EgressIP returns the egressIP of the policy gateway.
+	EgressIP() string
+	// Hostname returns the gateway node hostname in the policy nodeSelector.
+	Hostname() string
+	// SetEgressIP sets the egressIP of the policy gateway.
+	SetEgressIP(ip string)
+	// SetHostname sets the hostname label in the policy nodeSelector and
+	// removes the operator managed hostname In matchExpression, other
+	// matchLabels and matchExpressions are kept as-is.
+	SetHostname(hostname string)
+	// CheckHostnameMatchable ensures the policy nodeSelector does not
+	// exclude the hostname.
+	CheckHostnameMatchable(hostname string) error
+	// Hostnames returns the gateway node hostnames in the operator managed
+	// hostname In matchExpression of the policy nodeSelector.
+	Hostnames() []string
+	// SetHostnames selects the gateway nodes by the operator managed
+	// hostname In matchExpression and removes the hostname label, other
+	// matchLabels and matchExpressions are kept as-is. The hostnames are
+	// recorded in the GatewayNodesAnnotation to tell the managed
+	// matchExpression from the ones owned by the user.
+	SetHostnames(hostnames []string)
+	// CheckHostnamesMatchable ensures the policy nodeSelector, except the
+	// operator managed hostname In matchExpression, does not exclude any of
+	// the hostnames.
+	CheckHostnamesMatchable(hostnames []string) error
+	// GatewayField returns the spec field name of the policy gateway, used
+	// for finding the gateway field manager in managedFields.
+	GatewayField() string
+}
+
+// Kind is the egress policy kind managed by the operator.
+type Kind interface {
+	// Kind returns the policy kind name.
+	Kind() string
+	OnChange(ctx context.Context, name string, sync func(string, Policy) (Policy, error))
+	Enqueue(name string)
+	EnqueueAfter(name string, duration time.Duration)
+	// Get gets the latest policy from the API server.
+	Get(name string) (Policy, error)
+	// ByIndex lists the policies from the informer cache by the index key.
+	ByIndex(indexName, key string) ([]Policy, error)
+	Update(p Policy) (Policy, error)
+}
+
+var (
+	kinds   []Kind
+	kindsMu sync.RWMutex
+)
+
+// Register adds the policy kind managed by the operator.
+func Register(kind Kind) {
+	kindsMu.Lock()
+	defer kindsMu.Unlock()
+
+	kinds = append(kinds, kind)
+}
+
+// Kinds returns the registered policy kinds.
+func Kinds() []Kind {
+	kindsMu.RLock()
+	defer kindsMu.RUnlock()
+
+	return kinds
+}
+
+// Monitored returns true if the policy is annotated to be managed by the
+// operator instance.
+func Monitored(p Policy) bool {
+	annotations := p.GetAnnotations()
+	return annotations[utils.WatchAnnotationPrefix] == utils.WatchAnnotationValue &&
+		utils.ManagedByInstance(annotations)
+}
+
+// Group returns the EgressGatewayGroup name the policy is bound to, returns
+// an empty string for the default gateway group.
+func Group(p Policy) string {
+	return p.GetAnnotations()[utils.GroupAnnotation]
+}
+
+// Bound returns true if the policy is monitored and bound to the group.
+func Bound(p Policy, group string) bool {
+	return p != nil && p.GetDeletionTimestamp() == nil && Monitored(p) && Group(p) == group
+}
+
+// Gateway returns the gateway node hostnames selected by the policy
+// nodeSelector joined by comma, used for logs and status.
+func Gateway(p Policy) string {
+	if hostname := p.Hostname(); hostname != "" {
+		return hostname
+	}
+	return strings.Join(p.Hostnames(), ",")
+}
+
+// HostnameSynced returns true if the policy nodeSelector selects the gateway
+// node of the group, or the ordered gateway nodes of multi-gateway groups.
+func HostnameSynced(p Policy, g *gateway.Group) bool {
+	if nodes := g.GatewayNodes(); len(nodes) > 0 {
+		return p.Hostname() == "" && slices.Equal(p.Hostnames(), nodes)
+	}
+	return p.Hostname() == g.LeaderNode() && len(p.Hostnames()) == 0
+}
+
+// managedHostnames returns the gateway node hostnames the operator wrote
+// into the policy nodeSelector.
+func managedHostnames(p Policy) []string {
+	return utils.ManagedHostnames(p.GetAnnotations())
+}
+
+// setManagedHostnames records the gateway node hostnames written into the
+// policy nodeSelector, the annotation is removed for empty hostnames.
+func setManagedHostnames(p Policy, hostnames []string) {
+	annotations := p.GetAnnotations()
+	if len(hostnames) == 0 {
+		if _, ok := annotations[utils.GatewayNodesAnnotation]; ok {
+			delete(annotations, utils.GatewayNodesAnnotation)
+			p.SetAnnotations(annotations)
+		}
+		return
+	}
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[utils.GatewayNodesAnnotation] = strings.Join(hostnames, ",")
+	p.SetAnnotations(annotations)
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	ProvidedNodeIPAnnotationKey = "alpha.kubernetes.io/provided-node-ip"
+	HostnameLabelKey            = "kubernetes.io/hostname"
+)
+
+// NodeIP returns the node IP from the provided-node-ip annotation.
+func NodeIP(node *corev1.Node) string {
+	if node == nil || len(node.Annotations) == 0 {
+		return ""
+	}
+	ip := node.Annotations[ProvidedNodeIPAnnotationKey]
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	return ip
+}
+
+// NodeHostname returns the node hostname label.
+func NodeHostname(node *corev1.Node) string {
+	if node == nil || len(node.Labels) == 0 {
+		return ""
+	}
+	return node.Labels[HostnameLabelKey]
+}
+
+// NodeOwnsIP returns true if the IP is the provided node IP or one of the
+// node status addresses.
+func NodeOwnsIP(node *corev1.Node, ip string) bool {
+	if node == nil || ip == "" {
+		return false
+	}
+	if NodeIP(node) == ip {
+		return true
+	}
+	for _, addr := range node.Status.Addresses {
+		if addr.Address == ip {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+)
+
+var (
+	ErrHostnameUnmatchable = errors.New("policy nodeSelector is unmatchable with the desired hostname")
+)
+
+// PolicyMonitored returns true if the policy is annotated to be managed by
+// the operator instance.
+func PolicyMonitored(p *ciliumv2.CiliumEgressGatewayPolicy) bool {
+	if p == nil || len(p.Annotations) == 0 {
+		return false
+	}
+	return p.Annotations[WatchAnnotationPrefix] == WatchAnnotationValue && ManagedByInstance(p.Annotations)
+}
+
+// PolicyGroup returns the EgressGatewayGroup name the policy is bound to,
+// returns an empty string for the default gateway group.
+func PolicyGroup(p *ciliumv2.CiliumEgressGatewayPolicy) string {
+	if p == nil || len(p.Annotations) == 0 {
+		return ""
+	}
+	return p.Annotations[GroupAnnotation]
+}
+
+func PolicyIP(p *ciliumv2.CiliumEgressGatewayPolicy) string {
+	if p == nil || p.Spec.EgressGateway == nil {
+		return ""
+	}
+	return p.Spec.EgressGateway.EgressIP
+}
+
+func PolicyHostname(p *ciliumv2.CiliumEgressGatewayPolicy) string {
+	if p == nil || p.Spec.EgressGateway == nil || p.Spec.EgressGateway.NodeSelector == nil ||
+		p.Spec.EgressGateway.NodeSelector.MatchLabels == nil {
+		return ""
+	}
+	return p.Spec.EgressGateway.NodeSelector.MatchLabels[HostnameLabelKey]
+}
+
+// CheckHostnameMatchable ensures the matchExpressions of the selector do not
+// exclude the desired hostname, otherwise the policy will not select any node
+// after the hostname label is updated.
+func CheckHostnameMatchable(selector *slimv1.LabelSelector, hostname string) error {
+	if selector == nil || hostname == "" {
+		return nil
+	}
+	for _, e := range selector.MatchExpressions {
+		if e.Key != HostnameLabelKey {
+			continue
+		}
+		switch e.Operator {
+		case slimv1.LabelSelectorOpIn:
+			if !slices.Contains(e.Values, hostname) {
+				return fmt.Errorf("%w: matchExpressions [%v In %v] does not contain [%v]",
+					ErrHostnameUnmatchable, e.Key, e.Values, hostname)
+			}
+		case slimv1.LabelSelectorOpNotIn:
+			if slices.Contains(e.Values, hostname) {
+				return fmt.Errorf("%w: matchExpressions [%v NotIn %v] excludes [%v]",
+					ErrHostnameUnmatchable, e.Key, e.Values, hostname)
+			}
+		case slimv1.LabelSelectorOpDoesNotExist:
+			return fmt.Errorf("%w: matchExpressions [%v DoesNotExist] excludes [%v]",
+				ErrHostnameUnmatchable, e.Key, hostname)
+		}
+	}
+	return nil
+}
+
+// ManagedHostnames returns the gateway node hostnames recorded by the
+// operator in the annotations, see GatewayNodesAnnotation.
+func ManagedHostnames(annotations map[string]string) []string {
+	return SplitList(annotations[GatewayNodesAnnotation])
+}
+
+// managedExpression returns the index of the hostname In matchExpression
+// written by the operator with the managed hostnames, returns -1 if not
+// found.
+func managedExpression(selector *slimv1.LabelSelector, managed []string) int {
+	if selector == nil || len(managed) == 0 {
+		return -1
+	}
+	return slices.IndexFunc(selector.MatchExpressions, func(e slimv1.LabelSelectorRequirement) bool {
+		return e.Key == HostnameLabelKey && e.Operator == slimv1.LabelSelectorOpIn && slices.Equal(e.Values, managed)
+	})
+}
+
+// SelectorHostnames returns the values of the hostname In matchExpression
+// managed by the operator, which selects the gateway nodes of multi-gateway
+// policies.
+func SelectorHostnames(selector *slimv1.LabelSelector, managed []string) []string {
+	i := managedExpression(selector, managed)
+	if i < 0 {
+		return nil
+	}
+	return selector.MatchExpressions[i].Values
+}
+
+// SetSelectorHostnames selects the hostnames by the hostname In
+// matchExpression managed by the operator, the hostname matchLabels and the
+// previously managed matchExpression are removed, other requirements are
+// kept as-is.
+func SetSelectorHostnames(selector *slimv1.LabelSelector, managed, hostnames []string) {
+	if selector == nil {
+		return
+	}
+	delete(selector.MatchLabels, HostnameLabelKey)
+	if len(selector.MatchLabels) == 0 {
+		selector.MatchLabels = nil
+	}
+	RemoveSelectorHostnames(selector, managed)
+	requirement := slimv1.LabelSelectorRequirement{
+		Key:      HostnameLabelKey,
+		Operator: slimv1.LabelSelectorOpIn,
+		Values:   slices.Clone(hostnames),
+	}
+	selector.MatchExpressions = append([]slimv1.LabelSelectorRequirement{requirement}, selector.MatchExpressions...)
+}
+
+// RemoveSelectorHostnames removes the hostname In matchExpression managed by
+// the operator, the matchExpressions owned by the user are kept.
+func RemoveSelectorHostnames(selector *slimv1.LabelSelector, managed []string) {
+	i := managedExpression(selector, managed)
+	if i < 0 {
+		return
+	}
+	selector.MatchExpressions = slices.Delete(slices.Clone(selector.MatchExpressions), i, i+1)
+	if len(selector.MatchExpressions) == 0 {
+		selector.MatchExpressions = nil
+	}
+}
+
+// UserSelector returns a copy of the selector without the hostname In
+// matchExpression managed by the operator, used for checking the desired
+// hostnames against the requirements owned by the user.
+func UserSelector(selector *slimv1.LabelSelector, managed []string) *slimv1.LabelSelector {
+	if selector == nil {
+		return nil
+	}
+	s := selector.DeepCopy()
+	RemoveSelectorHostnames(s, managed)
+	return s
+}
+
+// CheckHostnamesMatchable ensures the matchExpressions of the selector other
+// than the hostname In matchExpression managed by the operator do not
+// exclude any of the desired hostnames.
+func CheckHostnamesMatchable(selector *slimv1.LabelSelector, managed, hostnames []string) error {
+	s := UserSelector(selector, managed)
+	for _, hostname := range hostnames {
+		if err := CheckHostnameMatchable(s, hostname); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+)
+
+func hostnameExpression(op slimv1.LabelSelectorOperator, values ...string) slimv1.LabelSelectorRequirement {
+	return slimv1.LabelSelectorRequirement{
+		Key:      HostnameLabelKey,
+		Operator: op,
+		Values:   values,
+	}
+}
+
+func TestCheckHostnameMatchable(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector *slimv1.LabelSelector
+		hostname string
+		wantErr  bool
+	}{
+		{
+			name:     "nil selector",
+			hostname: "node-1",
+		},
+		{
+			name: "empty hostname",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					hostnameExpression(slimv1.LabelSelectorOpDoesNotExist),
+				},
+			},
+		},
+		{
+			name: "matchLabels only",
+			selector: &slimv1.LabelSelector{
+				MatchLabels: map[string]string{HostnameLabelKey: "node-2"},
+			},
+			hostname: "node-1",
+		},
+		{
+			name: "other key",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{{
+					Key:      "zone",
+					Operator: slimv1.LabelSelectorOpNotIn,
+					Values:   []string{"node-1"},
+				}},
+			},
+			hostname: "node-1",
+		},
+		{
+			name: "In contains hostname",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					hostnameExpression(slimv1.LabelSelectorOpIn, "node-1", "node-2"),
+				},
+			},
+			hostname: "node-1",
+		},
+		{
+			name: "In does not contain hostname",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					hostnameExpression(slimv1.LabelSelectorOpIn, "node-2"),
+				},
+			},
+			hostname: "node-1",
+			wantErr:  true,
+		},
+		{
+			name: "NotIn excludes hostname",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					hostnameExpression(slimv1.LabelSelectorOpNotIn, "node-1"),
+				},
+			},
+			hostname: "node-1",
+			wantErr:  true,
+		},
+		{
+			name: "NotIn other hostname",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					hostnameExpression(slimv1.LabelSelectorOpNotIn, "node-2"),
+				},
+			},
+			hostname: "node-1",
+		},
+		{
+			name: "Exists",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					hostnameExpression(slimv1.LabelSelectorOpExists),
+				},
+			},
+			hostname: "node-1",
+		},
+		{
+			name: "DoesNotExist",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					hostnameExpression(slimv1.LabelSelectorOpDoesNotExist),
+				},
+			},
+			hostname: "node-1",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckHostnameMatchable(tt.selector, tt.hostname)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckHostnameMatchable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrHostnameUnmatchable) {
+				t.Errorf("CheckHostnameMatchable() error = %v, want ErrHostnameUnmatchable", err)
+			}
+		})
+	}
+}
+
+func TestCheckHostnamesMatchable(t *testing.T) {
+	tests := []struct {
+		name      string
+		selector  *slimv1.LabelSelector
+		managed   []string
+		hostnames []string
+		wantErr   bool
+	}{
+		{
+			name:      "nil selector",
+			hostnames: []string{"node-1"},
+		},
+		{
+			name: "managed In expression is ignored",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					hostnameExpression(slimv1.LabelSelectorOpIn, "node-3"),
+				},
+			},
+			managed:   []string{"node-3"},
+			hostnames: []string{"node-1", "node-2"},
+		},
+		{
+			name: "user In expression excludes the hostnames",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					hostnameExpression(slimv1.LabelSelectorOpIn, "node-3"),
+				},
+			},
+			hostnames: []string{"node-1", "node-2"},
+			wantErr:   true,
+		},
+		{
+			name: "user In expression next to the managed one",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					hostnameExpression(slimv1.LabelSelectorOpIn, "node-1"),
+					hostnameExpression(slimv1.LabelSelectorOpIn, "node-1", "node-2", "node-3"),
+				},
+			},
+			managed:   []string{"node-1"},
+			hostnames: []string{"node-1", "node-2"},
+		},
+		{
+			name: "NotIn excludes one of the hostnames",
+			selector: &slimv1.LabelSelector{
+				MatchExpressions: []slimv1.LabelSelectorRequirement{
+					hostnameExpression(slimv1.LabelSelectorOpIn, "node-1", "node-2"),
+					hostnameExpression(slimv1.LabelSelectorOpNotIn, "node-2"),
+				},
+			},
+			managed:   []string{"node-1", "node-2"},
+			hostnames: []string{"node-1", "node-2"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before *slimv1.LabelSelector
+			if tt.selector != nil {
+				before = tt.selector.DeepCopy()
+			}
+			err := CheckHostnamesMatchable(tt.selector, tt.managed, tt.hostnames)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckHostnamesMatchable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.selector != nil && !slices.EqualFunc(before.MatchExpressions, tt.selector.MatchExpressions,
+				func(a, b slimv1.LabelSelectorRequirement) bool {
+					return a.Key == b.Key && a.Operator == b.Operator && slices.Equal(a.Values, b.Values)
+				}) {
+				t.Errorf("CheckHostnamesMatchable() modified the selector: %v", tt.selector.MatchExpressions)
+			}
+		})
+	}
+}
+
+func TestSetSelectorHostnames(t *testing.T) {
+	user := hostnameExpression(slimv1.LabelSelectorOpIn, "node-1", "node-2", "node-3")
+	selector := &slimv1.LabelSelector{
+		MatchLabels: map[string]string{HostnameLabelKey: "node-1"},
+		MatchExpressions: []slimv1.LabelSelectorRequirement{
+			hostnameExpression(slimv1.LabelSelectorOpIn, "node-1"),
+			user,
+		},
+	}
+
+	SetSelectorHostnames(selector, []string{"node-1"}, []string{"node-2", "node-3"})
+	if _, ok := selector.MatchLabels[HostnameLabelKey]; ok {
+		t.Errorf("hostname matchLabels not removed: %v", selector.MatchLabels)
+	}
+	if got := SelectorHostnames(selector, []string{"node-2", "node-3"}); !slices.Equal(got, []string{"node-2", "node-3"}) {
+		t.Errorf("SelectorHostnames() = %v, want [node-2 node-3]", got)
+	}
+	if len(selector.MatchExpressions) != 2 || !slices.Equal(selector.MatchExpressions[1].Values, user.Values) {
+		t.Errorf("user In expression not kept: %v", selector.MatchExpressions)
+	}
+
+	RemoveSelectorHostnames(selector, []string{"node-2", "node-3"})
+	if len(selector.MatchExpressions) != 1 || !slices.Equal(selector.MatchExpressions[0].Values, user.Values) {
+		t.Errorf("RemoveSelectorHostnames() left %v, want the user In expression only", selector.MatchExpressions)
+	}
+	if got := SelectorHostnames(selector, nil); got != nil {
+		t.Errorf("SelectorHostnames() without managed hostnames = %v, want nil", got)
+	}
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"crypto/rand"
 	"encoding/json"
+	"fmt"
 	"io"
 	"math/big"
 	"os"
@@ -14,12 +15,73 @@ import (
 )
 
 const (
+	// FieldManager is the field manager name of the operator.
+	FieldManager = "cilium-egress-operator"
+
 	WatchAnnotationPrefix = "egress.cilium.pandaria.io/monitored"
 	WatchAnnotationValue  = "true"
+
+	// MaintenanceAnnotation marks the node in planned maintenance, the
+	// operator moves policies to another gateway node until it is removed.
+	MaintenanceAnnotation      = "egress.cilium.pandaria.io/maintenance"
+	MaintenanceAnnotationValue = "true"
+
+	// PendingGatewayAnnotation and PendingSinceAnnotation record the planned
+	// gateway move intent of the policy waiting for the drain period.
+	PendingGatewayAnnotation = "egress.cilium.pandaria.io/pending-gateway"
+	PendingSinceAnnotation   = "egress.cilium.pandaria.io/pending-since"
+
+	// GroupAnnotation binds the policy to the EgressGatewayGroup, policies
+	// without the annotation follow the default gateway group.
+	GroupAnnotation = "egress.cilium.pandaria.io/group"
+
+	// InstanceAnnotation assigns the policy or the EgressGatewayGroup to the
+	// operator instance with the same instance ID, the objects without the
+	// annotation are managed by the instance without the instance ID.
+	InstanceAnnotation = "egress.cilium.pandaria.io/instance"
+
+	// GatewayNodesAnnotation records the gateway node hostnames the operator
+	// wrote into the hostname In matchExpression of multi-gateway policies,
+	// the matchExpression with other values is owned by the user.
+	GatewayNodesAnnotation = "egress.cilium.pandaria.io/gateway-nodes"
+)
+
+// The log field names shared by the handlers, kept stable for the log
+// pipelines parsing the JSON logs.
+const (
+	FieldPolicy = "policy"
+	FieldKind   = "kind"
+	FieldGroup  = "group"
+	FieldLease  = "lease"
+	FieldHolder = "holder"
+	FieldNode   = "node"
+	FieldOldIP  = "old_ip"
+	FieldNewIP  = "new_ip"
+	FieldReason = "reason"
+)
+
+const (
+	// LogFormatText is the colored text log format, the warning and error
+	// logs are written to stderr.
+	LogFormatText = "text"
+	// LogFormatJSON writes the logs of all levels as JSON lines to stdout.
+	LogFormatJSON = "json"
+)
+
+const (
+	// DriftModeLenient reports the manual edits of the policy gateway and
+	// reverts them at the next periodic resync.
+	DriftModeLenient = "lenient"
+	// DriftModeStrict reports the manual edits of the policy gateway and
+	// reverts them immediately.
+	DriftModeStrict = "strict"
 )
 
 var (
-	hostname string
+	hostname   string
+	instanceID string
+	// hideTime is the text log option kept for switching the log format.
+	hideTime bool
 )
 
 func init() {
@@ -30,6 +92,24 @@ func init() {
 	}
 }
 
+// SetInstanceID sets the ID of the operator instance, should be called
+// before starting the handlers.
+func SetInstanceID(id string) {
+	instanceID = id
+}
+
+// InstanceID returns the ID of the operator instance, returns an empty string
+// if not set.
+func InstanceID() string {
+	return instanceID
+}
+
+// ManagedByInstance returns true if the object annotations assign the object
+// to the operator instance.
+func ManagedByInstance(annotations map[string]string) bool {
+	return annotations[InstanceAnnotation] == instanceID
+}
+
 func DebugPrint(a any) string {
 	if logrus.GetLevel() >= logrus.DebugLevel {
 		return Print(a)
@@ -50,6 +130,19 @@ func Parse(ref string) (namespace string, name string) {
 	return parts[0], parts[1]
 }
 
+// SplitList splits the comma separated string and removes empty items.
+func SplitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		list = append(list, v)
+	}
+	return list
+}
+
 // Generates a random hexadecimal number.
 func RandomHex(l int) string {
 	chars := []byte("abcdef0123456789")
@@ -85,7 +178,8 @@ func Value[T valueTypes](p *T) T {
 	return *p
 }
 
-func SetupLogrus(hideTime bool) {
+func SetupLogrus(hide bool) {
+	hideTime = hide
 	formatter := &formatter.Formatter{
 		NoColors: false,
 	}
@@ -115,6 +209,32 @@ func SetupLogrus(hideTime bool) {
 	})
 }
 
+// SetLogFormat switches the log format set up by SetupLogrus.
+func SetLogFormat(format string) error {
+	switch format {
+	case LogFormatText:
+		logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+		SetupLogrus(hideTime)
+		return nil
+	case LogFormatJSON:
+		logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+		logrus.SetOutput(os.Stdout)
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q", format)
+}
+
+// ValidLogFormat returns true if the log format is supported.
+func ValidLogFormat(format string) bool {
+	return format == LogFormatText || format == LogFormatJSON
+}
+
+// ValidDriftMode returns true if the policy drift mode is supported.
+func ValidDriftMode(mode string) bool {
+	return mode == DriftModeLenient || mode == DriftModeStrict
+}
+
 func Hostname() string {
 	return hostname
 }
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/STARRY-S/simple-logrus-formatter/pkg/formatter"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSetLogFormat(t *testing.T) {
+	t.Cleanup(func() { SetupLogrus(false) })
+
+	tests := []struct {
+		name     string
+		hideTime bool
+		formats  []string
+		// wantTimestamp is the timestamp format of the text formatter.
+		wantTimestamp string
+	}{
+		{
+			name:    "text",
+			formats: []string{LogFormatText},
+		},
+		{
+			name:          "text without time",
+			hideTime:      true,
+			formats:       []string{LogFormatText},
+			wantTimestamp: "-",
+		},
+		{
+			name:          "back to text without time",
+			hideTime:      true,
+			formats:       []string{LogFormatJSON, LogFormatText},
+			wantTimestamp: "-",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetupLogrus(tt.hideTime)
+			for _, format := range tt.formats {
+				if err := SetLogFormat(format); err != nil {
+					t.Fatalf("SetLogFormat(%q) error = %v", format, err)
+				}
+			}
+			f, ok := logrus.StandardLogger().Formatter.(*formatter.Formatter)
+			if !ok {
+				t.Fatalf("formatter = %T, want the text formatter", logrus.StandardLogger().Formatter)
+			}
+			if f.TimestampFormat != tt.wantTimestamp {
+				t.Errorf("timestamp format = %q, want %q", f.TimestampFormat, tt.wantTimestamp)
+			}
+		})
+	}
+
+	if err := SetLogFormat(LogFormatJSON); err != nil {
+		t.Fatalf("SetLogFormat(json) error = %v", err)
+	}
+	if _, ok := logrus.StandardLogger().Formatter.(*logrus.JSONFormatter); !ok {
+		t.Errorf("formatter = %T, want the JSON formatter", logrus.StandardLogger().Formatter)
+	}
+	if err := SetLogFormat("xml"); err == nil {
+		t.Error("SetLogFormat(xml) returned no error")
+	}
+}
+
+func TestManagedByInstance(t *testing.T) {
+	t.Cleanup(func() { SetInstanceID("") })
+
+	tests := []struct {
+		name        string
+		instance    string
+		annotations map[string]string
+		want        bool
+	}{
+		{name: "default instance", want: true},
+		{
+			name:        "default instance ignores other instances",
+			annotations: map[string]string{InstanceAnnotation: "a"},
+		},
+		{
+			name:        "default instance of the empty annotation",
+			annotations: map[string]string{InstanceAnnotation: ""},
+			want:        true,
+		},
+		{
+			name:        "instance",
+			instance:    "a",
+			annotations: map[string]string{InstanceAnnotation: "a"},
+			want:        true,
+		},
+		{
+			name:        "instance ignores other instances",
+			instance:    "a",
+			annotations: map[string]string{InstanceAnnotation: "b"},
+		},
+		{
+			name:     "instance ignores unannotated objects",
+			instance: "a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetInstanceID(tt.instance)
+			if got := InstanceID(); got != tt.instance {
+				t.Errorf("InstanceID() = %q, want %q", got, tt.instance)
+			}
+			if got := ManagedByInstance(tt.annotations); got != tt.want {
+				t.Errorf("ManagedByInstance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/wrangler"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// LeaderLabel marks the leader operator pod, the webhook Service only selects
+// the labeled pod so the admission requests are handled by the pod holding
+// the current gateway state.
+const LeaderLabel = "egress.cilium.pandaria.io/leader"
+
+// SetLeader adds the leader label to the operator pod, or removes it. The
+// label is removed on startup as it is kept on the pod when the container
+// restarts after losing the leadership.
+func SetLeader(ctx context.Context, wctx *wrangler.Context, leader bool) error {
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		name = utils.Hostname()
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = wctx.Namespace
+	}
+	var value any
+	if leader {
+		value = "true"
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels": map[string]any{
+				LeaderLabel: value,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode pod label patch: %w", err)
+	}
+	_, err = wctx.Kubernetes.CoreV1().Pods(namespace).Patch(ctx, name, types.MergePatchType, patch,
+		metav1.PatchOptions{FieldManager: utils.FieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to update label %v of pod [%v/%v]: %w", LeaderLabel, namespace, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// mutate fills the current gateway node into the monitored policy on
+// creation, so the policy is correct from its first revision.
+func (s *server) mutate(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Kind.Kind != policyKind || req.Operation != admissionv1.Create {
+		return allowed()
+	}
+	p := &ciliumv2.CiliumEgressGatewayPolicy{}
+	if err := json.Unmarshal(req.Object.Raw, p); err != nil {
+		return denied(fmt.Errorf("failed to decode %v: %w", policyKind, err))
+	}
+	if !utils.PolicyMonitored(p) || p.Spec.EgressGateway == nil {
+		return allowed()
+	}
+	g, ok := gateway.Lookup(utils.PolicyGroup(p))
+	if !ok {
+		logrus.WithFields(logrus.Fields{utils.FieldPolicy: p.Name}).
+			Debugf("Gateway group [%v] not found, skip filling policy gateway", utils.PolicyGroup(p))
+		return allowed()
+	}
+	leaderNode, leaderNodeIP := g.LeaderNode(), g.LeaderNodeIP()
+	if leaderNode == "" {
+		logrus.WithFields(logrus.Fields{utils.FieldPolicy: p.Name}).
+			Debugf("Gateway leader node is unknown, skip filling policy gateway")
+		return allowed()
+	}
+
+	patches := s.gatewayPatches(p, g, leaderNodeIP, leaderNode)
+	if len(patches) == 0 {
+		return allowed()
+	}
+	b, err := json.Marshal(patches)
+	if err != nil {
+		return denied(fmt.Errorf("failed to encode patch: %w", err))
+	}
+	logrus.WithFields(logrus.Fields{utils.FieldPolicy: p.Name}).
+		Infof("Fill policy gateway with egressIP [%v] hostname [%v] on creation", leaderNodeIP, leaderNode)
+	patchType := admissionv1.PatchTypeJSONPatch
+	resp := allowed()
+	resp.Patch = b
+	resp.PatchType = &patchType
+	return resp
+}
+
+func (s *server) gatewayPatches(
+	p *ciliumv2.CiliumEgressGatewayPolicy, g *gateway.Group, ip, hostname string,
+) []patchOperation {
+	var patches []patchOperation
+	if g.EgressIPToNodeIP(s.options().SetPolicyEgressIPToNodeIP) && ip != "" && utils.PolicyIP(p) != ip {
+		patches = append(patches, patchOperation{
+			Op:    "add",
+			Path:  "/spec/egressGateway/egressIP",
+			Value: ip,
+		})
+	}
+	if !s.options().SetPolicyNodeSelector {
+		return patches
+	}
+	selector := p.Spec.EgressGateway.NodeSelector
+	if nodes := g.GatewayNodes(); len(nodes) > 0 {
+		return append(patches, s.gatewayNodesPatches(p, nodes)...)
+	}
+	if utils.PolicyHostname(p) == hostname {
+		return patches
+	}
+	if err := utils.CheckHostnameMatchable(selector, hostname); err != nil {
+		logrus.WithFields(logrus.Fields{utils.FieldPolicy: p.Name}).
+			Debugf("Skip filling policy hostname: %v", err)
+		return patches
+	}
+	matchLabels := map[string]string{
+		utils.HostnameLabelKey: hostname,
+	}
+	switch {
+	case selector == nil:
+		patches = append(patches, patchOperation{
+			Op:   "add",
+			Path: "/spec/egressGateway/nodeSelector",
+			Value: map[string]any{
+				"matchLabels": matchLabels,
+			},
+		})
+	case selector.MatchLabels == nil:
+		patches = append(patches, patchOperation{
+			Op:    "add",
+			Path:  "/spec/egressGateway/nodeSelector/matchLabels",
+			Value: matchLabels,
+		})
+	default:
+		patches = append(patches, patchOperation{
+			Op:    "add",
+			Path:  "/spec/egressGateway/nodeSelector/matchLabels/" + escapeJSONPointer(utils.HostnameLabelKey),
+			Value: hostname,
+		})
+	}
+	return patches
+}
+
+// gatewayNodesPatches selects the gateway nodes of multi-gateway groups by
+// the hostname In matchExpression of the policy nodeSelector, and records
+// the hostnames in the annotation marking the matchExpression as managed.
+func (s *server) gatewayNodesPatches(p *ciliumv2.CiliumEgressGatewayPolicy, nodes []string) []patchOperation {
+	selector := p.Spec.EgressGateway.NodeSelector
+	managed := utils.ManagedHostnames(p.Annotations)
+	if utils.PolicyHostname(p) == "" && slices.Equal(utils.SelectorHostnames(selector, managed), nodes) {
+		return nil
+	}
+	if err := utils.CheckHostnamesMatchable(selector, managed, nodes); err != nil {
+		logrus.WithFields(logrus.Fields{utils.FieldPolicy: p.Name}).
+			Debugf("Skip filling policy gateway nodes: %v", err)
+		return nil
+	}
+	if selector == nil {
+		selector = &slimv1.LabelSelector{}
+	} else {
+		selector = selector.DeepCopy()
+	}
+	utils.SetSelectorHostnames(selector, managed, nodes)
+	return []patchOperation{
+		{
+			Op:    "add",
+			Path:  "/spec/egressGateway/nodeSelector",
+			Value: selector,
+		},
+		{
+			Op:    "add",
+			Path:  "/metadata/annotations/" + escapeJSONPointer(utils.GatewayNodesAnnotation),
+			Value: strings.Join(nodes, ","),
+		},
+	}
+}
+
+func escapeJSONPointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
@@ -0,0 +1,256 @@
+package webhook
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+const (
+	pathEgressIP     = "/spec/egressGateway/egressIP"
+	pathNodeSelector = "/spec/egressGateway/nodeSelector"
+	pathMatchLabels  = "/spec/egressGateway/nodeSelector/matchLabels"
+	pathHostname     = "/spec/egressGateway/nodeSelector/matchLabels/kubernetes.io~1hostname"
+	pathGatewayNodes = "/metadata/annotations/egress.cilium.pandaria.io~1gateway-nodes"
+)
+
+func hostnamesSelector(hostnames ...string) *slimv1.LabelSelector {
+	selector := &slimv1.LabelSelector{}
+	utils.SetSelectorHostnames(selector, nil, hostnames)
+	return selector
+}
+
+// managedPolicy returns the monitored policy with the hostnames recorded as
+// the operator managed gateway nodes.
+func managedPolicy(ip string, hostnames ...string) *ciliumv2.CiliumEgressGatewayPolicy {
+	p := monitoredPolicy(ip, hostnamesSelector(hostnames...))
+	p.Annotations[utils.GatewayNodesAnnotation] = strings.Join(hostnames, ",")
+	return p
+}
+
+func TestMutate(t *testing.T) {
+	disabled := false
+	manage := Options{SetPolicyEgressIPToNodeIP: true, SetPolicyNodeSelector: true}
+
+	tests := []struct {
+		name string
+		op   admissionv1.Operation
+		opts Options
+		// policy is the policy of the test gateway group, with the leader
+		// node-1 10.0.0.1 unless noLeader is set.
+		policy       *ciliumv2.CiliumEgressGatewayPolicy
+		group        string
+		noLeader     bool
+		gatewayNodes []string
+		ipToNodeIP   *bool
+		want         []string
+	}{
+		{
+			name:   "update",
+			op:     admissionv1.Update,
+			opts:   manage,
+			policy: monitoredPolicy("", nil),
+		},
+		{
+			name: "not monitored",
+			op:   admissionv1.Create,
+			opts: manage,
+			policy: func() *ciliumv2.CiliumEgressGatewayPolicy {
+				p := monitoredPolicy("", nil)
+				p.Annotations = nil
+				return p
+			}(),
+		},
+		{
+			name:   "gateway group not found",
+			op:     admissionv1.Create,
+			opts:   manage,
+			policy: monitoredPolicy("", nil),
+			group:  "not-found",
+		},
+		{
+			name:     "leader node unknown",
+			op:       admissionv1.Create,
+			opts:     manage,
+			policy:   monitoredPolicy("", nil),
+			noLeader: true,
+		},
+		{
+			name:   "fill nodeSelector",
+			op:     admissionv1.Create,
+			opts:   manage,
+			policy: monitoredPolicy("", nil),
+			want:   []string{pathEgressIP, pathNodeSelector},
+		},
+		{
+			name: "fill matchLabels",
+			op:   admissionv1.Create,
+			opts: manage,
+			policy: monitoredPolicy("", hostnameSelector("", slimv1.LabelSelectorRequirement{
+				Key:      "egress-gateway",
+				Operator: slimv1.LabelSelectorOpExists,
+			})),
+			want: []string{pathEgressIP, pathMatchLabels},
+		},
+		{
+			name: "fill hostname into matchLabels",
+			op:   admissionv1.Create,
+			opts: manage,
+			policy: monitoredPolicy("", &slimv1.LabelSelector{
+				MatchLabels: map[string]slimv1.MatchLabelsValue{"egress-gateway": "true"},
+			}),
+			want: []string{pathEgressIP, pathHostname},
+		},
+		{
+			name:   "replace hostname",
+			op:     admissionv1.Create,
+			opts:   manage,
+			policy: monitoredPolicy("10.0.0.2", hostnameSelector("node-2")),
+			want:   []string{pathEgressIP, pathHostname},
+		},
+		{
+			name:   "already filled",
+			op:     admissionv1.Create,
+			opts:   manage,
+			policy: monitoredPolicy("10.0.0.1", hostnameSelector("node-1")),
+		},
+		{
+			name:   "egressIP only",
+			op:     admissionv1.Create,
+			opts:   Options{SetPolicyEgressIPToNodeIP: true},
+			policy: monitoredPolicy("", nil),
+			want:   []string{pathEgressIP},
+		},
+		{
+			name:       "egressIP disabled by the group",
+			op:         admissionv1.Create,
+			opts:       manage,
+			policy:     monitoredPolicy("10.0.0.100", nil),
+			ipToNodeIP: &disabled,
+			want:       []string{pathNodeSelector},
+		},
+		{
+			name: "unmatchable hostname",
+			op:   admissionv1.Create,
+			opts: manage,
+			policy: monitoredPolicy("", hostnameSelector("", slimv1.LabelSelectorRequirement{
+				Key:      utils.HostnameLabelKey,
+				Operator: slimv1.LabelSelectorOpNotIn,
+				Values:   []string{"node-1"},
+			})),
+			want: []string{pathEgressIP},
+		},
+		{
+			name:         "multi-gateway group",
+			op:           admissionv1.Create,
+			opts:         manage,
+			policy:       monitoredPolicy("", hostnameSelector("node-3")),
+			gatewayNodes: []string{"node-1", "node-2"},
+			want:         []string{pathEgressIP, pathNodeSelector, pathGatewayNodes},
+		},
+		{
+			name:         "multi-gateway group already filled",
+			op:           admissionv1.Create,
+			opts:         manage,
+			policy:       managedPolicy("10.0.0.1", "node-1", "node-2"),
+			gatewayNodes: []string{"node-1", "node-2"},
+		},
+		{
+			name:         "multi-gateway group replaces the managed gateway nodes",
+			op:           admissionv1.Create,
+			opts:         manage,
+			policy:       managedPolicy("10.0.0.1", "node-3"),
+			gatewayNodes: []string{"node-1", "node-2"},
+			want:         []string{pathNodeSelector, pathGatewayNodes},
+		},
+		{
+			name:         "multi-gateway group excluded by the user hostnames",
+			op:           admissionv1.Create,
+			opts:         manage,
+			policy:       monitoredPolicy("", hostnamesSelector("node-3")),
+			gatewayNodes: []string{"node-1", "node-2"},
+			want:         []string{pathEgressIP},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.opts)
+			g := gateway.For(t.Name())
+			t.Cleanup(func() { gateway.Delete(t.Name()) })
+			if !tt.noLeader {
+				g.SetLeaderNode("10.0.0.1", "node-1", false)
+			}
+			g.SetGatewayNodes(tt.gatewayNodes)
+			g.SetEgressIPToNodeIP(tt.ipToNodeIP)
+			group := tt.group
+			if group == "" {
+				group = t.Name()
+			}
+			if tt.policy.Annotations != nil {
+				tt.policy.Annotations[utils.GroupAnnotation] = group
+			}
+
+			resp := s.mutate(admissionRequest(t, policyKind, tt.op, tt.policy))
+			if !resp.Allowed {
+				t.Fatalf("mutate() denied: %v", resp.Result)
+			}
+			var patches []patchOperation
+			if resp.Patch != nil {
+				if err := json.Unmarshal(resp.Patch, &patches); err != nil {
+					t.Fatalf("failed to decode patch: %v", err)
+				}
+			}
+			var paths []string
+			for _, patch := range patches {
+				if patch.Op != "add" {
+					t.Errorf("patch %v op = %q, want add", patch.Path, patch.Op)
+				}
+				if patch.Path == pathEgressIP && patch.Value != "10.0.0.1" {
+					t.Errorf("patch egressIP = %v, want 10.0.0.1", patch.Value)
+				}
+				paths = append(paths, patch.Path)
+			}
+			if !slices.Equal(paths, tt.want) {
+				t.Errorf("mutate() patches = %v, want %v", paths, tt.want)
+			}
+		})
+	}
+}
+
+func TestGatewayNodesPatches(t *testing.T) {
+	s := newTestServer(t, Options{SetPolicyNodeSelector: true})
+	p := monitoredPolicy("", &slimv1.LabelSelector{
+		MatchLabels: map[string]slimv1.MatchLabelsValue{
+			utils.HostnameLabelKey: "node-3",
+			"egress-gateway":       "true",
+		},
+	})
+
+	patches := s.gatewayNodesPatches(p, []string{"node-1", "node-2"})
+	if len(patches) != 2 {
+		t.Fatalf("gatewayNodesPatches() = %v, want the nodeSelector and annotation patches", patches)
+	}
+	selector, ok := patches[0].Value.(*slimv1.LabelSelector)
+	if !ok {
+		t.Fatalf("gatewayNodesPatches() value = %T, want *LabelSelector", patches[0].Value)
+	}
+	if got := utils.SelectorHostnames(selector, []string{"node-1", "node-2"}); !slices.Equal(got, []string{"node-1", "node-2"}) {
+		t.Errorf("selector hostnames = %v, want [node-1 node-2]", got)
+	}
+	if got := selector.MatchLabels["egress-gateway"]; got != "true" {
+		t.Errorf("selector keeps matchLabels egress-gateway = %q, want true", got)
+	}
+	if patches[1].Path != pathGatewayNodes || patches[1].Value != "node-1,node-2" {
+		t.Errorf("annotation patch = %+v, want %v node-1,node-2", patches[1], pathGatewayNodes)
+	}
+	if got := utils.PolicyHostname(p); got != "node-3" {
+		t.Errorf("policy nodeSelector modified, hostname = %q", got)
+	}
+}
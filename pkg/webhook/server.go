@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnrancher/cilium-egress-operator/pkg/controller/wrangler"
+	corecontroller "github.com/cnrancher/cilium-egress-operator/pkg/generated/controllers/core/v1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	ValidatePath = "/validate-cilium-io-v2-ciliumegressgatewaypolicy"
+	MutatePath   = "/mutate-cilium-io-v2-ciliumegressgatewaypolicy"
+
+	certFileName = "tls.crt"
+	keyFileName  = "tls.key"
+
+	maxRequestBodySize = 1 << 20
+)
+
+type Options struct {
+	// Addr is the HTTPS listen address of the webhook server.
+	Addr string
+	// CertDir is the directory containing the tls.crt and tls.key files.
+	CertDir string
+
+	SetPolicyEgressIPToNodeIP bool
+	SetPolicyNodeSelector     bool
+	// CandidateSelector selects the nodes that can be the gateway node.
+	CandidateSelector labels.Selector
+}
+
+type server struct {
+	nodeCache corecontroller.NodeCache
+}
+
+// options is the current webhook options, the policy options are replaced on
+// the config reload.
+var options atomic.Pointer[Options]
+
+type admitFunc func(*admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse
+
+// Run starts the admission webhook server and blocks until the context is
+// canceled.
+func Run(ctx context.Context, wctx *wrangler.Context, opts Options) error {
+	logrus.Debugf("Webhook Server Options: %v", utils.DebugPrint(opts))
+	setOptions(opts)
+	s := &server{
+		nodeCache: wctx.Core.Node().Cache(),
+	}
+
+	if err := wctx.SyncCaches(ctx); err != nil {
+		return fmt.Errorf("failed to sync webhook caches: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ValidatePath, s.serve(s.validate))
+	mux.HandleFunc(MutatePath, s.serve(s.mutate))
+	srv := &http.Server{
+		Addr:              opts.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	logrus.Infof("Webhook server listen on: https://%v", opts.Addr)
+	err := srv.ListenAndServeTLS(
+		filepath.Join(opts.CertDir, certFileName),
+		filepath.Join(opts.CertDir, keyFileName),
+	)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to start webhook server: %w", err)
+	}
+	return nil
+}
+
+func setOptions(opts Options) {
+	if opts.CandidateSelector == nil {
+		opts.CandidateSelector = labels.Everything()
+	}
+	options.Store(&opts)
+}
+
+// Reload replaces the policy options of the webhook server, the listen
+// address and the certificate directory are not changed.
+func Reload(opts Options) {
+	logrus.Debugf("Reload Webhook Server Options: %v", utils.DebugPrint(opts))
+	if current := options.Load(); current != nil {
+		opts.Addr = current.Addr
+		opts.CertDir = current.CertDir
+	}
+	setOptions(opts)
+}
+
+// options returns the current webhook options.
+func (s *server) options() *Options {
+	return options.Load()
+}
+
+func (s *server) serve(admit admitFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodySize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		review := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+			http.Error(w, "invalid AdmissionReview request", http.StatusBadRequest)
+			return
+		}
+
+		resp := admit(review.Request)
+		resp.UID = review.Request.UID
+		review.Response = resp
+		review.Request = nil
+		b, err := json.Marshal(review)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(b); err != nil {
+			logrus.Warnf("Failed to write webhook response: %v", err)
+		}
+	}
+}
+
+func allowed(warnings ...string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
+	}
+}
+
+func denied(err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: err.Error(),
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		},
+	}
+}
@@ -0,0 +1,163 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	policyKind = "CiliumEgressGatewayPolicy"
+)
+
+func (s *server) validate(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Kind.Kind != policyKind || req.Operation == admissionv1.Delete {
+		return allowed()
+	}
+	p := &ciliumv2.CiliumEgressGatewayPolicy{}
+	if err := json.Unmarshal(req.Object.Raw, p); err != nil {
+		return denied(fmt.Errorf("failed to decode %v: %w", policyKind, err))
+	}
+	var old *ciliumv2.CiliumEgressGatewayPolicy
+	if req.Operation == admissionv1.Update && len(req.OldObject.Raw) > 0 {
+		old = &ciliumv2.CiliumEgressGatewayPolicy{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return denied(fmt.Errorf("failed to decode old %v: %w", policyKind, err))
+		}
+	}
+	warnings, err := s.validatePolicy(p, old)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{utils.FieldPolicy: p.Name}).
+			Infof("Rejected policy: %v", err)
+		return denied(err)
+	}
+	return allowed(warnings...)
+}
+
+// validatePolicy validates the monitored policy, returns the warnings for
+// the suspicious fields and the error if the policy should be rejected. The
+// old policy is nil on creation.
+func (s *server) validatePolicy(p, old *ciliumv2.CiliumEgressGatewayPolicy) ([]string, error) {
+	value, ok := p.Annotations[utils.WatchAnnotationPrefix]
+	if !ok {
+		return nil, nil
+	}
+	if value != utils.WatchAnnotationValue && value != "false" {
+		return nil, fmt.Errorf("annotation %q value %q is invalid, should be %q or %q",
+			utils.WatchAnnotationPrefix, value, utils.WatchAnnotationValue, "false")
+	}
+	if value != utils.WatchAnnotationValue || !utils.ManagedByInstance(p.Annotations) {
+		return nil, nil
+	}
+	if p.Spec.EgressGateway == nil {
+		return nil, fmt.Errorf("spec.egressGateway is required by the monitored policy")
+	}
+
+	// The gateway group may not be known by this pod yet, the policy is
+	// managed once the group is synced.
+	g, ok := gateway.Lookup(utils.PolicyGroup(p))
+	if !ok {
+		return nil, nil
+	}
+
+	var warnings []string
+	// The nodeSelector is only validated when it is set or changed, the
+	// updates of the other fields are not rejected after the gateway moved.
+	if s.options().SetPolicyNodeSelector && selectorChanged(p, old) {
+		if err := s.validateSelector(p.Spec.EgressGateway.NodeSelector, g.LeaderNode()); err != nil {
+			return nil, err
+		}
+		// Only the default group candidate selector is known by the webhook.
+		if hostname := utils.PolicyHostname(p); hostname != "" && g.Name() == gateway.DefaultGroup {
+			if err := s.validateHostname(hostname); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if ip := utils.PolicyIP(p); ip != "" && !g.EgressIPToNodeIP(s.options().SetPolicyEgressIPToNodeIP) {
+		owned, err := s.ipOwnedByNode(ip)
+		if err != nil {
+			return nil, err
+		}
+		if !owned {
+			warnings = append(warnings, fmt.Sprintf(
+				"spec.egressGateway.egressIP %q is not an address of any node, ensure it is assigned to the gateway node interface", ip))
+		}
+	}
+	return warnings, nil
+}
+
+// selectorChanged returns true if the nodeSelector of the monitored policy is
+// new or changed by the update.
+func selectorChanged(p, old *ciliumv2.CiliumEgressGatewayPolicy) bool {
+	if old == nil || old.Spec.EgressGateway == nil ||
+		old.Annotations[utils.WatchAnnotationPrefix] != utils.WatchAnnotationValue {
+		return true
+	}
+	selector, oldSelector := p.Spec.EgressGateway.NodeSelector, old.Spec.EgressGateway.NodeSelector
+	if selector == nil || oldSelector == nil {
+		return selector != oldSelector
+	}
+	return !selector.DeepEqual(oldSelector)
+}
+
+// validateSelector ensures the nodeSelector is compatible with the operator
+// managed hostname label.
+func (s *server) validateSelector(selector *slimv1.LabelSelector, leader string) error {
+	if selector == nil {
+		return nil
+	}
+	for _, e := range selector.MatchExpressions {
+		if e.Key == utils.HostnameLabelKey && e.Operator == slimv1.LabelSelectorOpDoesNotExist {
+			return fmt.Errorf("spec.egressGateway.nodeSelector.matchExpressions [%v DoesNotExist] conflicts with the operator managed label",
+				utils.HostnameLabelKey)
+		}
+	}
+	if leader != "" {
+		if err := utils.CheckHostnameMatchable(selector, leader); err != nil {
+			return fmt.Errorf("spec.egressGateway.nodeSelector conflicts with the current gateway node: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateHostname ensures the hostname in nodeSelector is a gateway
+// candidate node.
+func (s *server) validateHostname(hostname string) error {
+	nodes, err := s.nodeCache.List(labels.SelectorFromSet(labels.Set{
+		utils.HostnameLabelKey: hostname,
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to list nodes from cache: %w", err)
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("node with hostname %q in spec.egressGateway.nodeSelector not found", hostname)
+	}
+	for _, n := range nodes {
+		if s.options().CandidateSelector.Matches(labels.Set(n.Labels)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("node with hostname %q is not a gateway candidate node (selector %q)",
+		hostname, s.options().CandidateSelector.String())
+}
+
+func (s *server) ipOwnedByNode(ip string) (bool, error) {
+	nodes, err := s.nodeCache.List(labels.Everything())
+	if err != nil {
+		return false, fmt.Errorf("failed to list nodes from cache: %w", err)
+	}
+	for _, n := range nodes {
+		if utils.NodeOwnsIP(n, ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,305 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/fake"
+	"github.com/cnrancher/cilium-egress-operator/pkg/internal/gateway"
+	"github.com/cnrancher/cilium-egress-operator/pkg/utils"
+	"github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newNode(name, ip string, nodeLabels map[string]string) *corev1.Node {
+	l := map[string]string{utils.HostnameLabelKey: name}
+	for k, v := range nodeLabels {
+		l[k] = v
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      l,
+			Annotations: map[string]string{utils.ProvidedNodeIPAnnotationKey: ip},
+		},
+	}
+}
+
+// newTestServer returns the webhook server with the given options and nodes.
+func newTestServer(t *testing.T, opts Options, nodes ...*corev1.Node) *server {
+	t.Helper()
+	logrus.SetLevel(logrus.PanicLevel)
+	setOptions(opts)
+	t.Cleanup(func() { options.Store(nil) })
+	return &server{nodeCache: fake.NewNodeCache(nodes...)}
+}
+
+// setLeader sets the leader node of the default gateway group for the test.
+func setLeader(t *testing.T, ip, hostname string) {
+	t.Helper()
+	gateway.Default().SetLeaderNode(ip, hostname, false)
+	t.Cleanup(gateway.Default().ClearLeaderNode)
+}
+
+// monitoredPolicy returns a monitored policy with the egressIP and the
+// nodeSelector.
+func monitoredPolicy(ip string, selector *slimv1.LabelSelector) *ciliumv2.CiliumEgressGatewayPolicy {
+	return &ciliumv2.CiliumEgressGatewayPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "policy",
+			Annotations: map[string]string{
+				utils.WatchAnnotationPrefix: utils.WatchAnnotationValue,
+			},
+		},
+		Spec: ciliumv2.CiliumEgressGatewayPolicySpec{
+			EgressGateway: &ciliumv2.EgressGateway{
+				EgressIP:     ip,
+				NodeSelector: selector,
+			},
+		},
+	}
+}
+
+func hostnameSelector(hostname string, expressions ...slimv1.LabelSelectorRequirement) *slimv1.LabelSelector {
+	selector := &slimv1.LabelSelector{MatchExpressions: expressions}
+	if hostname != "" {
+		selector.MatchLabels = map[string]slimv1.MatchLabelsValue{utils.HostnameLabelKey: hostname}
+	}
+	return selector
+}
+
+func admissionRequest(t *testing.T, kind string, op admissionv1.Operation, obj any) *admissionv1.AdmissionRequest {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatalf("failed to encode object: %v", err)
+	}
+	return &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: "cilium.io", Version: "v2", Kind: kind},
+		Operation: op,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	candidates := labels.SelectorFromSet(labels.Set{"egress-gateway": "true"})
+	nodes := []*corev1.Node{
+		newNode("node-1", "10.0.0.1", map[string]string{"egress-gateway": "true"}),
+		newNode("node-2", "10.0.0.2", nil),
+	}
+	policyWith := func(mutate func(p *ciliumv2.CiliumEgressGatewayPolicy)) *ciliumv2.CiliumEgressGatewayPolicy {
+		p := monitoredPolicy("", hostnameSelector("node-1"))
+		mutate(p)
+		return p
+	}
+
+	tests := []struct {
+		name         string
+		kind         string
+		op           admissionv1.Operation
+		opts         Options
+		policy       *ciliumv2.CiliumEgressGatewayPolicy
+		old          *ciliumv2.CiliumEgressGatewayPolicy
+		want         bool
+		wantWarnings int
+	}{
+		{
+			name: "other kind",
+			kind: "CiliumNetworkPolicy",
+			op:   admissionv1.Create,
+			want: true,
+		},
+		{
+			name: "delete",
+			op:   admissionv1.Delete,
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway = nil
+			}),
+			want: true,
+		},
+		{
+			name: "not monitored",
+			op:   admissionv1.Create,
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Annotations = nil
+				p.Spec.EgressGateway = nil
+			}),
+			want: true,
+		},
+		{
+			name: "invalid monitored annotation",
+			op:   admissionv1.Create,
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Annotations[utils.WatchAnnotationPrefix] = "yes"
+			}),
+		},
+		{
+			name: "monitoring disabled",
+			op:   admissionv1.Update,
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Annotations[utils.WatchAnnotationPrefix] = "false"
+				p.Spec.EgressGateway = nil
+			}),
+			want: true,
+		},
+		{
+			name: "missing egressGateway",
+			op:   admissionv1.Create,
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway = nil
+			}),
+		},
+		{
+			name: "gateway group not found",
+			op:   admissionv1.Create,
+			opts: Options{SetPolicyNodeSelector: true},
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Annotations[utils.GroupAnnotation] = "not-found"
+				p.Spec.EgressGateway.NodeSelector = hostnameSelector("node-9")
+			}),
+			want: true,
+		},
+		{
+			name: "candidate hostname",
+			op:   admissionv1.Create,
+			opts: Options{SetPolicyNodeSelector: true, CandidateSelector: candidates},
+			policy: policyWith(func(*ciliumv2.CiliumEgressGatewayPolicy) {
+			}),
+			want: true,
+		},
+		{
+			name: "hostname not a candidate",
+			op:   admissionv1.Create,
+			opts: Options{SetPolicyNodeSelector: true, CandidateSelector: candidates},
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway.NodeSelector = hostnameSelector("node-2")
+			}),
+		},
+		{
+			name: "hostname not found",
+			op:   admissionv1.Create,
+			opts: Options{SetPolicyNodeSelector: true},
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway.NodeSelector = hostnameSelector("node-9")
+			}),
+		},
+		{
+			name: "hostname not checked without node selector management",
+			op:   admissionv1.Create,
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway.NodeSelector = hostnameSelector("node-9")
+			}),
+			want: true,
+		},
+		{
+			name: "hostname DoesNotExist",
+			op:   admissionv1.Create,
+			opts: Options{SetPolicyNodeSelector: true},
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway.NodeSelector = hostnameSelector("", slimv1.LabelSelectorRequirement{
+					Key:      utils.HostnameLabelKey,
+					Operator: slimv1.LabelSelectorOpDoesNotExist,
+				})
+			}),
+		},
+		{
+			name: "selector excludes the gateway node",
+			op:   admissionv1.Update,
+			opts: Options{SetPolicyNodeSelector: true},
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway.NodeSelector = hostnameSelector("node-1", slimv1.LabelSelectorRequirement{
+					Key:      utils.HostnameLabelKey,
+					Operator: slimv1.LabelSelectorOpNotIn,
+					Values:   []string{"node-1"},
+				})
+			}),
+		},
+		{
+			name: "selector unchanged by the update",
+			op:   admissionv1.Update,
+			opts: Options{SetPolicyNodeSelector: true, CandidateSelector: candidates},
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Labels = map[string]string{"app": "egress"}
+				p.Spec.EgressGateway.NodeSelector = hostnameSelector("node-2")
+			}),
+			old: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway.NodeSelector = hostnameSelector("node-2")
+			}),
+			want: true,
+		},
+		{
+			name: "selector changed by the update",
+			op:   admissionv1.Update,
+			opts: Options{SetPolicyNodeSelector: true, CandidateSelector: candidates},
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway.NodeSelector = hostnameSelector("node-2")
+			}),
+			old: policyWith(func(*ciliumv2.CiliumEgressGatewayPolicy) {}),
+		},
+		{
+			name: "monitoring enabled by the update",
+			op:   admissionv1.Update,
+			opts: Options{SetPolicyNodeSelector: true, CandidateSelector: candidates},
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway.NodeSelector = hostnameSelector("node-2")
+			}),
+			old: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Annotations = nil
+				p.Spec.EgressGateway.NodeSelector = hostnameSelector("node-2")
+			}),
+		},
+		{
+			name: "manual egressIP of a node",
+			op:   admissionv1.Create,
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway.EgressIP = "10.0.0.2"
+			}),
+			want: true,
+		},
+		{
+			name: "manual egressIP not on any node",
+			op:   admissionv1.Create,
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway.EgressIP = "10.0.0.100"
+			}),
+			want:         true,
+			wantWarnings: 1,
+		},
+		{
+			name: "egressIP managed by the operator",
+			op:   admissionv1.Create,
+			opts: Options{SetPolicyEgressIPToNodeIP: true},
+			policy: policyWith(func(p *ciliumv2.CiliumEgressGatewayPolicy) {
+				p.Spec.EgressGateway.EgressIP = "10.0.0.100"
+			}),
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.opts, nodes...)
+			setLeader(t, "10.0.0.1", "node-1")
+			kind := tt.kind
+			if kind == "" {
+				kind = policyKind
+			}
+			req := admissionRequest(t, kind, tt.op, tt.policy)
+			if tt.old != nil {
+				req.OldObject = admissionRequest(t, kind, tt.op, tt.old).Object
+			}
+			resp := s.validate(req)
+			if resp.Allowed != tt.want {
+				t.Errorf("validate() allowed = %v, want %v: %v", resp.Allowed, tt.want, resp.Result)
+			}
+			if len(resp.Warnings) != tt.wantWarnings {
+				t.Errorf("validate() warnings = %v, want %v warnings", resp.Warnings, tt.wantWarnings)
+			}
+		})
+	}
+}